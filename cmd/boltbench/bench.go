@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// benchmark runs a mix of read and write queries from concurrent sessions
+type benchmark struct {
+	driver     *neo4j.Driver
+	database   string
+	readQuery  string
+	writeQuery string
+	// writeRatio is the share of write queries, from 0 to 1
+	writeRatio float64
+	// sessions is the number of sessions running queries concurrently, each
+	// one query at a time
+	sessions int
+	// rate is the number of queries per second to run over all sessions,
+	// they run as fast as possible when zero
+	rate     float64
+	duration time.Duration
+	// operations bounds the number of queries to run, zero means no bound
+	operations int
+	// scheduled counts the queries scheduled so far
+	scheduled int64
+}
+
+func (b *benchmark) validate() error {
+	switch {
+	case b.sessions < 1:
+		return fmt.Errorf("expected at least 1 session, got %d", b.sessions)
+	case b.writeRatio < 0 || b.writeRatio > 1:
+		return fmt.Errorf("expected a write ratio between 0 and 1, got %v", b.writeRatio)
+	case b.rate < 0:
+		return fmt.Errorf("expected a positive rate, got %v", b.rate)
+	case b.duration <= 0 && b.operations <= 0:
+		return fmt.Errorf("expected a duration or a number of queries to run")
+	case b.writeRatio < 1 && b.readQuery == "":
+		return fmt.Errorf("expected a read query")
+	case b.writeRatio > 0 && b.writeQuery == "":
+		return fmt.Errorf("expected a write query")
+	}
+	return nil
+}
+
+// run runs the queries until the duration elapses or the operations are
+// all started, and waits for the running ones to complete
+func (b *benchmark) run() (*stats, time.Duration) {
+	ctx := context.Background()
+	if b.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.duration)
+		defer cancel()
+	}
+	results := make([]*stats, b.sessions)
+	var workers sync.WaitGroup
+	start := time.Now()
+	for i := range results {
+		results[i] = newStats()
+		workers.Add(1)
+		go func(worker int) {
+			defer workers.Done()
+			b.work(ctx, worker, start, results[worker])
+		}(i)
+	}
+	workers.Wait()
+	elapsed := time.Since(start)
+	result := newStats()
+	for _, workerStats := range results {
+		result.merge(workerStats)
+	}
+	return result, elapsed
+}
+
+// schedule returns when the next query is due, the queries being evenly
+// spread from the start at the rate, or due right away when they are not
+// paced. It returns false once the operations or the duration are over.
+func (b *benchmark) schedule(start time.Time) (time.Time, bool) {
+	operation := atomic.AddInt64(&b.scheduled, 1) - 1
+	if b.operations > 0 && operation >= int64(b.operations) {
+		return time.Time{}, false
+	}
+	if b.rate <= 0 {
+		return time.Now(), true
+	}
+	offset := time.Duration(float64(operation) * float64(time.Second) / b.rate)
+	if b.duration > 0 && offset >= b.duration {
+		return time.Time{}, false
+	}
+	return start.Add(offset), true
+}
+
+// work runs queries one at a time, each from a session of its access mode,
+// passing the worker number and the iteration as the $worker and $iteration
+// parameters. Paced queries are timed from when they are due rather than
+// from when they start, so that queries delayed by slow ones count as slow
+// as well.
+func (b *benchmark) work(ctx context.Context, worker int, start time.Time, stats *stats) {
+	random := rand.New(rand.NewSource(time.Now().UnixNano() + int64(worker)))
+	for iteration := 0; ; iteration++ {
+		due, ok := b.schedule(start)
+		if !ok || !sleepUntil(ctx, due) {
+			return
+		}
+		write := random.Float64() < b.writeRatio
+		accessMode, query := neo4j.ReadAccessMode, b.readQuery
+		if write {
+			accessMode, query = neo4j.WriteAccessMode, b.writeQuery
+		}
+		parameters := map[string]interface{}{"worker": worker, "iteration": iteration}
+		err := b.runQuery(accessMode, query, parameters)
+		stats.record(write, time.Since(due), err)
+	}
+}
+
+// sleepUntil waits for the time to come, and returns false when the context
+// is done first
+func sleepUntil(ctx context.Context, due time.Time) bool {
+	if wait := time.Until(due); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+		}
+	}
+	return ctx.Err() == nil
+}
+
+// runQuery runs the query from a session of the access mode, which only
+// holds a connection while the query runs, the query completes once all its
+// records are received
+func (b *benchmark) runQuery(accessMode neo4j.AccessMode, query string, parameters map[string]interface{}) error {
+	session, err := b.driver.NewSession(neo4j.SessionConfig{AccessMode: accessMode, DatabaseName: b.database})
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	result, err := session.RunContext(context.Background(), query, parameters)
+	if err != nil {
+		return err
+	}
+	_, err = result.Consume()
+	return err
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"github.com/fbiville/go-usain-go/pkg/bolttest"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	. "github.com/onsi/gomega"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBenchmark(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("runs the given number of queries", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.ExpectRun("RETURN $iteration AS i", bolttest.Success(map[string]interface{}{"fields": []string{"i"}})).
+				WithField(1, map[string]interface{}{"worker": 0, "iteration": 0}),
+			bolttest.Expect("PULL", bolttest.Record(0), bolttest.Success(nil)),
+			bolttest.ExpectRun("RETURN $iteration AS i", bolttest.Success(map[string]interface{}{"fields": []string{"i"}})).
+				WithField(1, map[string]interface{}{"worker": 0, "iteration": 1}),
+			bolttest.Expect("PULL", bolttest.Record(1), bolttest.Success(nil)),
+		)
+		driver, err := neo4j.NewDriver(server.URI(), "neo4j", "s3cr3t")
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		bench := &benchmark{driver: driver, readQuery: "RETURN $iteration AS i", sessions: 1, operations: 2}
+		Expect(bench.validate()).To(Succeed())
+
+		stats, _ := bench.run()
+
+		Expect(stats.reads).To(HaveLen(2))
+		Expect(stats.writes).To(BeEmpty())
+		Expect(stats.errors).To(BeEmpty())
+	})
+
+	t.Run("counts errors by code", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.ExpectRun("CREATE ()", bolttest.Failure("Neo.TransientError.Transaction.DeadlockDetected", "deadlock")),
+			bolttest.Expect("PULL", bolttest.Ignored()),
+			bolttest.Expect("RESET", bolttest.Success(nil)),
+		)
+		driver, err := neo4j.NewDriver(server.URI(), "neo4j", "s3cr3t")
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		bench := &benchmark{driver: driver, writeQuery: "CREATE ()", writeRatio: 1, sessions: 1, operations: 1}
+
+		stats, _ := bench.run()
+
+		Expect(stats.writes).To(BeEmpty())
+		Expect(stats.errors).To(Equal(map[string]int{"Neo.TransientError.Transaction.DeadlockDetected": 1}))
+	})
+
+	t.Run("fails when queries fail", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.ExpectRun("CREATE ()", bolttest.Failure("Neo.TransientError.Transaction.DeadlockDetected", "deadlock")),
+			bolttest.Expect("PULL", bolttest.Ignored()),
+			bolttest.Expect("RESET", bolttest.Success(nil)),
+		)
+		bench := &benchmark{writeQuery: "CREATE ()", writeRatio: 1, sessions: 1, operations: 1}
+
+		err := run(bench, server.URI(), "neo4j", "s3cr3t")
+
+		Expect(err).To(MatchError("1 queries failed"))
+	})
+
+	t.Run("rejects invalid workloads", func(t *testing.T) {
+		Expect((&benchmark{readQuery: "RETURN 1", sessions: 0, operations: 1}).validate()).
+			To(MatchError("expected at least 1 session, got 0"))
+		Expect((&benchmark{readQuery: "RETURN 1", sessions: 1}).validate()).
+			To(MatchError("expected a duration or a number of queries to run"))
+		Expect((&benchmark{readQuery: "RETURN 1", writeRatio: 0.5, sessions: 1, operations: 1}).validate()).
+			To(MatchError("expected a write query"))
+	})
+}
+
+func TestWriteReport(t *testing.T) {
+	RegisterTestingT(t)
+
+	stats := newStats()
+	for i := 1; i <= 100; i++ {
+		stats.record(i%4 == 0, time.Duration(i)*time.Millisecond, nil)
+	}
+	stats.record(false, 0, &neo4j.Neo4jError{Code: "Neo.TransientError.General.DatabaseUnavailable"})
+	stats.record(true, 0, &neo4j.Neo4jError{Code: "Neo.TransientError.Transaction.DeadlockDetected"})
+	stats.record(true, 0, &neo4j.Neo4jError{Code: "Neo.TransientError.Transaction.DeadlockDetected"})
+	stats.record(false, 0, errors.New("connection reset"))
+	output := &strings.Builder{}
+
+	writeReport(output, stats, 2*time.Second, 60)
+
+	Expect(output.String()).To(Equal(`Ran 104 queries in 2s, 52.0 queries/s (target 60.0 queries/s), 4 failed
+
+         queries  queries/s   p50   p95    p99    max
+  read        75       37.5  50ms  95ms   99ms   99ms
+  write       25       12.5  52ms  96ms  100ms  100ms
+  all        100       50.0  50ms  95ms   99ms  100ms
+
+Errors:
+       2  Neo.TransientError.Transaction.DeadlockDetected
+       1  Neo.TransientError.General.DatabaseUnavailable
+       1  connection reset
+`))
+	output.Reset()
+	writeReport(output, newStats(), time.Second, 0)
+	Expect(output.String()).To(HavePrefix("Ran 0 queries in 1s, 0.0 queries/s, 0 failed\n"))
+}
+
+func TestSchedule(t *testing.T) {
+	RegisterTestingT(t)
+	start := time.Unix(1, 0)
+
+	t.Run("spreads paced queries evenly from the start", func(t *testing.T) {
+		bench := &benchmark{rate: 10, operations: 3}
+
+		var due []time.Time
+		for {
+			next, ok := bench.schedule(start)
+			if !ok {
+				break
+			}
+			due = append(due, next)
+		}
+
+		Expect(due).To(Equal([]time.Time{start, start.Add(100 * time.Millisecond), start.Add(200 * time.Millisecond)}))
+	})
+
+	t.Run("schedules paced queries within the duration", func(t *testing.T) {
+		bench := &benchmark{rate: 4, duration: time.Second}
+
+		count := 0
+		for _, ok := bench.schedule(start); ok; _, ok = bench.schedule(start) {
+			count++
+		}
+
+		Expect(count).To(Equal(4))
+	})
+
+	t.Run("schedules queries that are not paced right away", func(t *testing.T) {
+		bench := &benchmark{operations: 1}
+
+		due, ok := bench.schedule(start)
+
+		Expect(ok).To(BeTrue())
+		Expect(due).To(BeTemporally("~", time.Now(), time.Second))
+		_, ok = bench.schedule(start)
+		Expect(ok).To(BeFalse())
+	})
+}
+
+func TestPercentile(t *testing.T) {
+	RegisterTestingT(t)
+
+	latencies := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	Expect(percentile(latencies, 50)).To(Equal(time.Duration(5)))
+	Expect(percentile(latencies, 95)).To(Equal(time.Duration(10)))
+	Expect(percentile(latencies, 0)).To(Equal(time.Duration(1)))
+	Expect(percentile(nil, 99)).To(Equal(time.Duration(0)))
+}
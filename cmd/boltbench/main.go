@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	"os"
+	"time"
+)
+
+func main() {
+	uri := flag.String("uri", "bolt://localhost", "Neo4j URI (e.g.: bolt://localhost)")
+	username := flag.String("username", "neo4j", "Neo4j username (e.g.: neo4j")
+	password := flag.String("password", "", "Neo4j password (e.g.: s3cr3t")
+	database := flag.String("database", "", "database to run queries against, defaults to the user home database")
+	readQuery := flag.String("read", "RETURN 1", "read query, which can use the $worker and $iteration parameters")
+	writeQuery := flag.String("write", "CREATE (n:BoltBench {worker: $worker, iteration: $iteration}) DELETE n", "write query, which can use the $worker and $iteration parameters")
+	writeRatio := flag.Float64("write-ratio", 0.2, "share of write queries, from 0 to 1")
+	sessions := flag.Int("sessions", 10, "number of concurrent sessions")
+	rate := flag.Float64("rate", 0, "queries per second over all sessions, 0 to run them as fast as possible, latencies of paced queries include the time they are overdue")
+	duration := flag.Duration("duration", 30*time.Second, "how long to run queries for, 0 to only stop after -count queries")
+	count := flag.Int("count", 0, "number of queries after which to stop, 0 to only stop after -duration")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\nRuns a mix of read and write queries and reports their throughput, latency and errors\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	bench := &benchmark{
+		database:   *database,
+		readQuery:  *readQuery,
+		writeQuery: *writeQuery,
+		writeRatio: *writeRatio,
+		sessions:   *sessions,
+		rate:       *rate,
+		duration:   *duration,
+		operations: *count,
+	}
+	if err := run(bench, *uri, *username, *password); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(bench *benchmark, uri string, username string, password string) error {
+	if err := bench.validate(); err != nil {
+		return err
+	}
+	driver, err := neo4j.NewDriver(uri, username, password)
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+	bench.driver = driver
+	fmt.Printf("Running queries from %d sessions against %s\n", bench.sessions, uri)
+	stats, elapsed := bench.run()
+	writeReport(os.Stdout, stats, elapsed, bench.rate)
+	if failed := stats.errorCount(); failed > 0 {
+		return fmt.Errorf("%d queries failed", failed)
+	}
+	return nil
+}
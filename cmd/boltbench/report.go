@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// stats collects the latencies of the successful queries and counts the
+// failed ones by error code
+type stats struct {
+	reads  []time.Duration
+	writes []time.Duration
+	errors map[string]int
+}
+
+func newStats() *stats {
+	return &stats{errors: make(map[string]int)}
+}
+
+func (s *stats) record(write bool, latency time.Duration, err error) {
+	switch {
+	case err != nil:
+		s.errors[errorCode(err)]++
+	case write:
+		s.writes = append(s.writes, latency)
+	default:
+		s.reads = append(s.reads, latency)
+	}
+}
+
+func (s *stats) merge(other *stats) {
+	s.reads = append(s.reads, other.reads...)
+	s.writes = append(s.writes, other.writes...)
+	for code, count := range other.errors {
+		s.errors[code] += count
+	}
+}
+
+func (s *stats) errorCount() int {
+	result := 0
+	for _, count := range s.errors {
+		result += count
+	}
+	return result
+}
+
+// errorCode returns the Neo4j code of server errors, and the message of
+// other errors
+func errorCode(err error) string {
+	var serverError *neo4j.Neo4jError
+	if errors.As(err, &serverError) && serverError.Code != "" {
+		return serverError.Code
+	}
+	return err.Error()
+}
+
+// percentile returns the latency the given percentage of the sorted
+// latencies do not exceed
+func percentile(sorted []time.Duration, percentage float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(percentage / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// writeReport writes the throughput, next to the target rate of paced
+// queries, and the latency percentiles of the read, write and all queries,
+// then the number of errors by code
+func writeReport(output io.Writer, stats *stats, elapsed time.Duration, rate float64) {
+	successes := len(stats.reads) + len(stats.writes)
+	total := successes + stats.errorCount()
+	target := ""
+	if rate > 0 {
+		target = fmt.Sprintf(" (target %.1f queries/s)", rate)
+	}
+	fmt.Fprintf(output, "Ran %d queries in %s, %.1f queries/s%s, %d failed\n\n", total, elapsed.Round(time.Millisecond), throughput(total, elapsed), target, stats.errorCount())
+	table := tabwriter.NewWriter(output, 0, 0, 2, ' ', tabwriter.AlignRight)
+	// names are padded to stay left aligned
+	fmt.Fprintln(table, "     \tqueries\tqueries/s\tp50\tp95\tp99\tmax\t")
+	all := append(append([]time.Duration(nil), stats.reads...), stats.writes...)
+	for _, row := range []struct {
+		name      string
+		latencies []time.Duration
+	}{{"read", stats.reads}, {"write", stats.writes}, {"all", all}} {
+		sorted := append([]time.Duration(nil), row.latencies...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i] < sorted[j]
+		})
+		fmt.Fprintf(table, "%-5s\t%d\t%.1f\t%s\t%s\t%s\t%s\t\n", row.name, len(sorted), throughput(len(sorted), elapsed),
+			formatLatency(percentile(sorted, 50)), formatLatency(percentile(sorted, 95)),
+			formatLatency(percentile(sorted, 99)), formatLatency(percentile(sorted, 100)))
+	}
+	_ = table.Flush()
+	if len(stats.errors) == 0 {
+		return
+	}
+	codes := make([]string, 0, len(stats.errors))
+	for code := range stats.errors {
+		codes = append(codes, code)
+	}
+	// the most frequent errors come first
+	sort.Slice(codes, func(i, j int) bool {
+		if stats.errors[codes[i]] != stats.errors[codes[j]] {
+			return stats.errors[codes[i]] > stats.errors[codes[j]]
+		}
+		return codes[i] < codes[j]
+	})
+	fmt.Fprintln(output, "\nErrors:")
+	for _, code := range codes {
+		fmt.Fprintf(output, "%8d  %s\n", stats.errors[code], code)
+	}
+}
+
+func throughput(count int, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(count) / elapsed.Seconds()
+}
+
+func formatLatency(latency time.Duration) string {
+	if latency >= time.Millisecond {
+		return latency.Round(10 * time.Microsecond).String()
+	}
+	return latency.Round(time.Microsecond).String()
+}
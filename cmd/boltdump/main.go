@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/boltdump"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+func main() {
+	format := flag.String("format", "auto", "input format: hex (plain, xxd or hexdump -C), binary, pcap or auto to detect it")
+	direction := flag.String("direction", "client", "direction of hex and binary traffic: client (to server) or server (to client)")
+	port := flag.Int("port", boltdump.DefaultPort, "server port of the connections to decode in pcap captures")
+	verbose := flag.Bool("v", false, "dump the bytes of each handshake step and message")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [file]\nDecodes the Bolt traffic of the file, or of the standard input\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if err := run(os.Stdout, flag.Arg(0), *format, *direction, *port, *verbose); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(output io.Writer, file string, format string, direction string, port int, verbose bool) error {
+	input, err := readInput(file)
+	if err != nil {
+		return err
+	}
+	dumper := &boltdump.Dumper{Output: output, Verbose: verbose}
+	if format == "auto" {
+		format = detectFormat(input)
+	}
+	switch format {
+	case "pcap":
+		return dumper.DumpPcap(bytes.NewReader(input), port)
+	case "hex":
+		input, err = decodeHex(string(input))
+		if err != nil {
+			return err
+		}
+	case "binary":
+	default:
+		return fmt.Errorf("unsupported format %q, expected hex, binary, pcap or auto", format)
+	}
+	switch direction {
+	case "client":
+		return dumper.DumpStream(input, boltdump.ClientToServer)
+	case "server":
+		return dumper.DumpStream(input, boltdump.ServerToClient)
+	}
+	return fmt.Errorf("unsupported direction %q, expected client or server", direction)
+}
+
+func readInput(file string) ([]byte, error) {
+	if file == "" || file == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(file)
+}
+
+func detectFormat(input []byte) string {
+	if boltdump.IsPcap(input) {
+		return "pcap"
+	}
+	if _, err := decodeHex(string(input)); err == nil {
+		return "hex"
+	}
+	return "binary"
+}
+
+// lines of the dumps of xxd, such as
+// 00000010: 0000 0000 000f b101 a186 7363 6865 6d65  ..........scheme
+// and of hexdump -C, such as
+// 00000010  00 00 00 00 00 0f b1 01  a1 86 73 63 68 65 6d 65  |..........scheme|
+var (
+	xxdLine     = regexp.MustCompile(`^\s*[0-9a-fA-F]{7,}: `)
+	hexdumpLine = regexp.MustCompile(`^\s*[0-9a-fA-F]{7,}  [0-9a-fA-F]{2}(\s|$)`)
+)
+
+// decodeHex decodes the dumps of xxd and hexdump -C, or plain hexadecimal
+// bytes, which may be separated by spaces, colons or commas and prefixed
+// with 0x
+func decodeHex(text string) ([]byte, error) {
+	lines := strings.Split(text, "\n")
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if xxdLine.MatchString(line) {
+			return decodeDump(lines, xxdColumns)
+		}
+		if hexdumpLine.MatchString(line) {
+			return decodeDump(lines, hexdumpColumns)
+		}
+		break
+	}
+	return decodePlainHex(text)
+}
+
+func decodePlainHex(text string) ([]byte, error) {
+	digits := strings.Builder{}
+	for _, token := range strings.FieldsFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ':' || r == ','
+	}) {
+		token = strings.TrimPrefix(strings.TrimPrefix(token, "0x"), "0X")
+		digits.WriteString(token)
+	}
+	if digits.Len() == 0 {
+		return nil, fmt.Errorf("no hexadecimal bytes found")
+	}
+	result, err := hex.DecodeString(digits.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid hexadecimal bytes: %w", err)
+	}
+	return result, nil
+}
+
+// xxdColumns splits the line in its offset and bytes, leaving out the text
+// column following them after two spaces
+func xxdColumns(line string) (string, string) {
+	offset, rest, _ := strings.Cut(line, ":")
+	digits, _, _ := strings.Cut(strings.TrimLeft(rest, " "), "  ")
+	return offset, digits
+}
+
+// hexdumpColumns splits the line in its offset and bytes, leaving out the
+// text column between pipes, the last line only holds the size of the data
+func hexdumpColumns(line string) (string, string) {
+	columns, _, _ := strings.Cut(line, "|")
+	offset, digits, _ := strings.Cut(strings.TrimSpace(columns), " ")
+	return offset, digits
+}
+
+// decodeDump decodes the lines starting with the offset of their bytes, a
+// line of * standing for repetitions of the previous line up to the offset of
+// the next one
+func decodeDump(lines []string, columns func(line string) (string, string)) ([]byte, error) {
+	var result, previous []byte
+	repeated := false
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line == "*" {
+			repeated = true
+			continue
+		}
+		offsetText, digits := columns(line)
+		offset, err := strconv.ParseUint(offsetText, 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offset on line %d: %q", i+1, offsetText)
+		}
+		if repeated {
+			repeated = false
+			missing := int(offset) - len(result)
+			if len(previous) == 0 || missing < 0 || missing%len(previous) != 0 {
+				return nil, fmt.Errorf("offset %X on line %d does not follow the repeated line", offset, i+1)
+			}
+			for ; missing > 0; missing -= len(previous) {
+				result = append(result, previous...)
+			}
+		}
+		if int(offset) != len(result) {
+			return nil, fmt.Errorf("expected offset %X on line %d, got %X", len(result), i+1, offset)
+		}
+		data, err := hex.DecodeString(strings.Join(strings.Fields(digits), ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid hexadecimal bytes on line %d: %w", i+1, err)
+		}
+		result = append(result, data...)
+		previous = data
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no hexadecimal bytes found")
+	}
+	return result, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	. "github.com/onsi/gomega"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// handshake followed by HELLO {scheme: "none"}
+var traffic = []byte{
+	0x60, 0x60, 0xB0, 0x17, 0, 0, 4, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0x0F, 0xB1, 0x01, 0xA1, 0x86, 's', 'c', 'h', 'e', 'm', 'e', 0x84, 'n', 'o', 'n', 'e', 0, 0,
+}
+
+const xxdDump = `00000000: 6060 b017 0000 0404 0000 0000 0000 0000  ` + "``" + `..............
+00000010: 0000 0000 000f b101 a186 7363 6865 6d65  ..........scheme
+00000020: 846e 6f6e 6500 00                        .none..
+`
+
+const hexdumpDump = `00000000  60 60 b0 17 00 00 04 04  00 00 00 00 00 00 00 00  |` + "``" + `..............|
+00000010  00 00 00 00 00 0f b1 01  a1 86 73 63 68 65 6d 65  |..........scheme|
+00000020  84 6e 6f 6e 65 00 00                              |.none..|
+00000027
+`
+
+func TestDecodeHex(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("decodes plain hexadecimal bytes", func(t *testing.T) {
+		result, err := decodeHex("0x60, 0x60, 0xB0, 0x17\n00:00:04:04 0000")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal([]byte{0x60, 0x60, 0xB0, 0x17, 0, 0, 4, 4, 0, 0}))
+	})
+
+	t.Run("decodes xxd dumps", func(t *testing.T) {
+		result, err := decodeHex(xxdDump)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(traffic))
+	})
+
+	t.Run("decodes hexdump dumps", func(t *testing.T) {
+		result, err := decodeHex(hexdumpDump)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(traffic))
+	})
+
+	t.Run("decodes repeated lines of dumps", func(t *testing.T) {
+		result, err := decodeHex(`00000000  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|
+*
+00000030  01                                                |.|
+00000031
+`)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(append(make([]byte, 48), 1)))
+	})
+
+	t.Run("rejects dumps with missing lines", func(t *testing.T) {
+		_, err := decodeHex(`00000000: 6060 b017 0000 0404 0000 0000 0000 0000  ` + "``" + `..............
+00000020: 846e 6f6e 6500 00                        .none..
+`)
+
+		Expect(err).To(MatchError("expected offset 10 on line 2, got 20"))
+	})
+
+	t.Run("rejects text", func(t *testing.T) {
+		_, err := decodeHex("not hexadecimal")
+
+		Expect(err).To(MatchError(ContainSubstring("invalid hexadecimal bytes")))
+	})
+}
+
+func TestDetectFormat(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(detectFormat([]byte(xxdDump))).To(Equal("hex"))
+	Expect(detectFormat([]byte(hexdumpDump))).To(Equal("hex"))
+	Expect(detectFormat([]byte("6060b017 00000404"))).To(Equal("hex"))
+	Expect(detectFormat(traffic)).To(Equal("binary"))
+	Expect(detectFormat([]byte{0xD4, 0xC3, 0xB2, 0xA1})).To(Equal("pcap"))
+}
+
+func TestRun(t *testing.T) {
+	RegisterTestingT(t)
+
+	for name, input := range map[string][]byte{"xxd": []byte(xxdDump), "hexdump": []byte(hexdumpDump), "binary": traffic} {
+		t.Run("decodes "+name+" input", func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "traffic")
+			Expect(os.WriteFile(file, input, 0o600)).To(Succeed())
+			output := &strings.Builder{}
+
+			err := run(output, file, "auto", "client", 7687, false)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output.String()).To(Equal(`C 00000000  HANDSHAKE 6060B017 [4.4]
+C 00000014  HELLO {scheme: "none"}
+`))
+		})
+	}
+
+	t.Run("fails on malformed traffic", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "traffic")
+		Expect(os.WriteFile(file, []byte("00 03 01 02 03 00 00"), 0o600)).To(Succeed())
+		output := &strings.Builder{}
+
+		err := run(output, file, "hex", "server", 7687, false)
+
+		Expect(err).To(MatchError("found 1 malformed or incomplete entries"))
+		Expect(output.String()).To(HavePrefix("S 00000000  <"))
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/boltproxy"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+)
+
+// failureFlags collects the failure rules, the flag being repeated
+type failureFlags []boltproxy.FailureRule
+
+func (f *failureFlags) String() string {
+	return fmt.Sprintf("%d rules", len(*f))
+}
+
+func (f *failureFlags) Set(value string) error {
+	rule, err := parseFailureRule(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, rule)
+	return nil
+}
+
+func main() {
+	listen := flag.String("listen", "localhost:7688", "address the proxy listens to")
+	target := flag.String("target", "localhost:7687", "address of the server to forward the connections to")
+	dialTimeout := flag.Duration("dial-timeout", boltproxy.DefaultDialTimeout, "time to connect to the server before closing the client connection")
+	latency := flag.Duration("latency", 0, "delay of every message, in both directions")
+	dropAfter := flag.Int("drop-after", 0, "number of requests after which connections are dropped, 0 to never drop them")
+	dropProbability := flag.Float64("drop-probability", 0, "probability of dropping the connection instead of forwarding a request")
+	var failures failureFlags
+	flag.Var(&failures, "fail", "substitutes a failure for the response to the requests matching MESSAGE[:TEXT]=CODE[,PROBABILITY], such as RUN:CREATE=Neo.TransientError.Transaction.DeadlockDetected,0.1, the flag can be repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\nForwards Bolt connections to a server, logging the messages exchanged\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	proxy := &boltproxy.Proxy{
+		Target:          *target,
+		DialTimeout:     *dialTimeout,
+		Log:             os.Stdout,
+		Latency:         *latency,
+		DropAfter:       *dropAfter,
+		DropProbability: *dropProbability,
+		Failures:        failures,
+	}
+	if err := run(proxy, *listen); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(proxy *boltproxy.Proxy, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Forwarding %s to %s\n", listener.Addr(), proxy.Target)
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	go func() {
+		<-interrupted
+		_ = listener.Close()
+		// a second interrupt does not wait for the connections to be over
+		<-interrupted
+		os.Exit(1)
+	}()
+	return proxy.Serve(listener)
+}
+
+// parseFailureRule parses MESSAGE[:TEXT]=CODE[,PROBABILITY]
+func parseFailureRule(value string) (boltproxy.FailureRule, error) {
+	separator := strings.LastIndex(value, "=")
+	if separator < 0 {
+		return boltproxy.FailureRule{}, fmt.Errorf("expected MESSAGE[:TEXT]=CODE[,PROBABILITY], got %q", value)
+	}
+	var rule boltproxy.FailureRule
+	rule.Message, rule.Contains, _ = strings.Cut(value[:separator], ":")
+	code, probability, found := strings.Cut(value[separator+1:], ",")
+	rule.Code = code
+	if found {
+		parsed, err := strconv.ParseFloat(probability, 64)
+		if err != nil || parsed <= 0 || parsed > 1 {
+			return boltproxy.FailureRule{}, fmt.Errorf("expected a probability between 0 and 1, got %q", probability)
+		}
+		rule.Probability = parsed
+	}
+	if rule.Message == "" || rule.Code == "" {
+		return boltproxy.FailureRule{}, fmt.Errorf("expected MESSAGE[:TEXT]=CODE[,PROBABILITY], got %q", value)
+	}
+	return rule, nil
+}
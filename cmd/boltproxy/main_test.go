@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/fbiville/go-usain-go/pkg/boltproxy"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestParseFailureRule(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("parses the message and the code", func(t *testing.T) {
+		rule, err := parseFailureRule("COMMIT=Neo.TransientError.General.DatabaseUnavailable")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rule).To(Equal(boltproxy.FailureRule{
+			Message: "COMMIT",
+			Code:    "Neo.TransientError.General.DatabaseUnavailable",
+		}))
+	})
+
+	t.Run("parses the text and the probability", func(t *testing.T) {
+		rule, err := parseFailureRule("RUN:CREATE=Neo.TransientError.Transaction.DeadlockDetected,0.1")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rule).To(Equal(boltproxy.FailureRule{
+			Message:     "RUN",
+			Contains:    "CREATE",
+			Code:        "Neo.TransientError.Transaction.DeadlockDetected",
+			Probability: 0.1,
+		}))
+	})
+
+	t.Run("keeps the separators of the text", func(t *testing.T) {
+		rule, err := parseFailureRule("RUN:n.a = 1:x=Neo.ClientError.Statement.SyntaxError")
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rule.Contains).To(Equal("n.a = 1:x"))
+		Expect(rule.Code).To(Equal("Neo.ClientError.Statement.SyntaxError"))
+	})
+
+	t.Run("rejects rules without a code", func(t *testing.T) {
+		for _, value := range []string{"RUN", "RUN=", "=Neo.ClientError.Statement.SyntaxError"} {
+			_, err := parseFailureRule(value)
+
+			Expect(err).To(MatchError(`expected MESSAGE[:TEXT]=CODE[,PROBABILITY], got "`+value+`"`), value)
+		}
+	})
+
+	t.Run("rejects invalid probabilities", func(t *testing.T) {
+		for _, probability := range []string{"often", "0", "-0.5", "1.5"} {
+			_, err := parseFailureRule("RUN=Neo.ClientError.Statement.SyntaxError," + probability)
+
+			Expect(err).To(MatchError(`expected a probability between 0 and 1, got "`+probability+`"`), probability)
+		}
+	})
+}
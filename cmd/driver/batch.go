@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	"io"
+	"strings"
+)
+
+// recordWriter streams the records of the statements in an output format
+type recordWriter interface {
+	// begin is called before the records of each statement
+	begin(keys []string) error
+	write(keys []string, values []neo4j.Value) error
+	// end is called after the records of each statement
+	end() error
+}
+
+func newRecordWriter(format string, output io.Writer) (recordWriter, error) {
+	switch format {
+	case "table":
+		return &tableWriter{output: output}, nil
+	case "csv":
+		return &csvWriter{output: output}, nil
+	case "json":
+		return &jsonWriter{output: output}, nil
+	case "jsonl":
+		return &jsonLinesWriter{encoder: json.NewEncoder(output)}, nil
+	}
+	return nil, fmt.Errorf("unsupported format %q, expected json, jsonl, csv or table", format)
+}
+
+// runBatch runs the statements one after the other in a single session, and
+// stops at the first failure
+func runBatch(driver *neo4j.Driver, database string, statements []string, parameters map[string]interface{}, writer recordWriter) error {
+	session, err := driver.NewSession(neo4j.SessionConfig{
+		AccessMode:   neo4j.WriteAccessMode,
+		DatabaseName: database,
+	})
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	for i, statement := range statements {
+		if err := runBatchStatement(session, statement, parameters, writer); err != nil {
+			return fmt.Errorf("statement %d failed: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func runBatchStatement(session *neo4j.Session, statement string, parameters map[string]interface{}, writer recordWriter) error {
+	result, err := session.RunContext(context.Background(), statement, parameters)
+	if err != nil {
+		return err
+	}
+	if err := writer.begin(result.Keys()); err != nil {
+		return err
+	}
+	if err := writeRecords(result, writer); err != nil {
+		// ends the records written so far, such as by closing the json array,
+		// so that the output stays well-formed
+		_ = writer.end()
+		return err
+	}
+	return writer.end()
+}
+
+func writeRecords(result *neo4j.Result, writer recordWriter) error {
+	for result.Next() {
+		if err := writer.write(result.Keys(), result.Record().Values); err != nil {
+			return err
+		}
+	}
+	_, err := result.Consume()
+	return err
+}
+
+// statementsOf splits the script in statements, the last one does not need
+// to end with a semicolon
+func statementsOf(script string) []string {
+	statements, rest := splitStatements(script)
+	if rest = strings.TrimSpace(rest); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+// tableWriter renders the records of each statement as a table, once all of
+// them are received
+type tableWriter struct {
+	output io.Writer
+	keys   []string
+	rows   [][]string
+}
+
+func (t *tableWriter) begin(keys []string) error {
+	t.keys = keys
+	t.rows = nil
+	return nil
+}
+
+func (t *tableWriter) write(_ []string, values []neo4j.Value) error {
+	row := make([]string, len(values))
+	for i, value := range values {
+		row[i] = displayValue(value)
+	}
+	t.rows = append(t.rows, row)
+	return nil
+}
+
+func (t *tableWriter) end() error {
+	if len(t.keys) > 0 {
+		writeTable(t.output, t.keys, t.rows)
+	}
+	return nil
+}
+
+// csvWriter writes a header line and the records of each statement
+type csvWriter struct {
+	output io.Writer
+	writer *csv.Writer
+}
+
+func (c *csvWriter) begin(keys []string) error {
+	c.writer = csv.NewWriter(c.output)
+	if len(keys) == 0 {
+		return nil
+	}
+	return c.writer.Write(keys)
+}
+
+func (c *csvWriter) write(_ []string, values []neo4j.Value) error {
+	fields := make([]string, len(values))
+	for i, value := range values {
+		field, err := textValue(value)
+		if err != nil {
+			return err
+		}
+		fields[i] = field
+	}
+	return c.writer.Write(fields)
+}
+
+func (c *csvWriter) end() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// jsonWriter writes an array of record objects per statement
+type jsonWriter struct {
+	output  io.Writer
+	records int
+}
+
+func (j *jsonWriter) begin([]string) error {
+	j.records = 0
+	_, err := io.WriteString(j.output, "[")
+	return err
+}
+
+func (j *jsonWriter) write(keys []string, values []neo4j.Value) error {
+	separator := "\n"
+	if j.records > 0 {
+		separator = ",\n"
+	}
+	j.records++
+	encoded, err := json.Marshal(recordObject(keys, values))
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(j.output, separator+string(encoded))
+	return err
+}
+
+func (j *jsonWriter) end() error {
+	closing := "]\n"
+	if j.records > 0 {
+		closing = "\n]\n"
+	}
+	_, err := io.WriteString(j.output, closing)
+	return err
+}
+
+// jsonLinesWriter writes a record object per line
+type jsonLinesWriter struct {
+	encoder *json.Encoder
+}
+
+func (j *jsonLinesWriter) begin([]string) error {
+	return nil
+}
+
+func (j *jsonLinesWriter) write(keys []string, values []neo4j.Value) error {
+	return j.encoder.Encode(recordObject(keys, values))
+}
+
+func (j *jsonLinesWriter) end() error {
+	return nil
+}
+
+func recordObject(keys []string, values []neo4j.Value) jsonObject {
+	result := make(jsonObject, len(values))
+	for i, value := range values {
+		result[i] = jsonEntry{keys[i], jsonValue(value)}
+	}
+	return result
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/fbiville/go-usain-go/pkg/bolttest"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	. "github.com/onsi/gomega"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRunBatch(t *testing.T) {
+	RegisterTestingT(t)
+
+	formats := map[string]string{
+		"json": `[
+{"n":1,"name":"Alice"},
+{"n":2,"name":null}
+]
+[]
+`,
+		"jsonl": `{"n":1,"name":"Alice"}
+{"n":2,"name":null}
+`,
+		"csv": `n,name
+1,Alice
+2,
+`,
+		"table": `+---+---------+
+| n | name    |
++---+---------+
+| 1 | "Alice" |
+| 2 | null    |
++---+---------+
+`,
+	}
+	for format, expected := range formats {
+		t.Run("streams results as "+format, func(t *testing.T) {
+			server := bolttest.NewServer(t,
+				bolttest.ExpectHello("Neo4j/4.4.0"),
+				bolttest.ExpectRun("UNWIND $rows AS row RETURN row.n AS n, row.name AS name").
+					WithField(1, map[string]interface{}{"rows": []interface{}{}}),
+				bolttest.Expect("PULL",
+					bolttest.Success(map[string]interface{}{"fields": []string{"n", "name"}}),
+					bolttest.Record(1, "Alice"),
+					bolttest.Record(2, nil),
+					bolttest.Success(nil)),
+				bolttest.ExpectRun("CREATE ()", bolttest.Success(map[string]interface{}{"fields": []string{}})),
+				bolttest.Expect("PULL", bolttest.Success(nil)),
+			)
+			driver, err := neo4j.NewDriver(server.URI(), "neo4j", "s3cr3t")
+			Expect(err).NotTo(HaveOccurred())
+			defer driver.Close()
+			output := &strings.Builder{}
+			writer, err := newRecordWriter(format, output)
+			Expect(err).NotTo(HaveOccurred())
+			statements := statementsOf("UNWIND $rows AS row RETURN row.n AS n, row.name AS name;\nCREATE ()")
+
+			err = runBatch(driver, "", statements, map[string]interface{}{"rows": []interface{}{}}, writer)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(output.String()).To(Equal(expected))
+		})
+	}
+
+	t.Run("stops at the first failure", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.ExpectRun("RETURN x", bolttest.Failure("Neo.ClientError.Statement.SyntaxError", "invalid query")),
+			bolttest.Expect("PULL", bolttest.Ignored()),
+			bolttest.Expect("RESET", bolttest.Success(nil)),
+		)
+		driver, err := neo4j.NewDriver(server.URI(), "neo4j", "s3cr3t")
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		writer, err := newRecordWriter("jsonl", &strings.Builder{})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = runBatch(driver, "", []string{"RETURN x", "RETURN 1"}, nil, writer)
+
+		Expect(err).To(MatchError(ContainSubstring("statement 1 failed: ")))
+		Expect(err).To(MatchError(ContainSubstring("invalid query")))
+	})
+
+	t.Run("closes json output of failures while streaming", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.ExpectRun("UNWIND [1, 0] AS n RETURN 1 / n AS n", bolttest.Success(map[string]interface{}{"fields": []string{"n"}})),
+			bolttest.Expect("PULL", bolttest.Record(1), bolttest.Failure("Neo.ClientError.Statement.ArithmeticError", "/ by zero")),
+			bolttest.Expect("RESET", bolttest.Success(nil)),
+		)
+		driver, err := neo4j.NewDriver(server.URI(), "neo4j", "s3cr3t")
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		output := &strings.Builder{}
+		writer, err := newRecordWriter("json", output)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = runBatch(driver, "", []string{"UNWIND [1, 0] AS n RETURN 1 / n AS n"}, nil, writer)
+
+		Expect(err).To(MatchError(ContainSubstring("/ by zero")))
+		Expect(output.String()).To(Equal("[\n{\"n\":1}\n]\n"))
+		Expect(json.Valid([]byte(output.String()))).To(BeTrue())
+	})
+}
+
+func TestScriptParameters(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("keeps plain parameters as strings", func(t *testing.T) {
+		var parameters []scriptParameter
+		flags := flag.NewFlagSet("driver", flag.ContinueOnError)
+		flags.Var(parameterFlags{parameters: &parameters}, "param", "")
+		flags.Var(parameterFlags{parameters: &parameters, expression: true}, "param-expr", "")
+
+		err := flags.Parse([]string{"--param", "name=Alice", "--param-expr", "age=40 + 2", "--param", "motto=a=b"})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parameters).To(Equal([]scriptParameter{
+			{name: "name", value: "Alice"},
+			{name: "age", value: "40 + 2", expression: true},
+			{name: "motto", value: "a=b"},
+		}))
+	})
+
+	t.Run("rejects parameters without name", func(t *testing.T) {
+		var parameters []scriptParameter
+		flags := flag.NewFlagSet("driver", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		flags.Var(parameterFlags{parameters: &parameters}, "param", "")
+
+		err := flags.Parse([]string{"--param", "Alice"})
+
+		Expect(err).To(MatchError(ContainSubstring("expected name=value, such as name=Alice")))
+	})
+
+	t.Run("evaluates expressions only", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.ExpectRun("RETURN size($name) + 37 AS value", bolttest.Success(map[string]interface{}{"fields": []string{"value"}})).
+				WithField(1, map[string]interface{}{"name": "Alice"}),
+			bolttest.Expect("PULL", bolttest.Record(42), bolttest.Success(nil)),
+		)
+		driver, err := neo4j.NewDriver(server.URI(), "neo4j", "s3cr3t")
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+
+		parameters, err := evaluateParameters(driver, "", []scriptParameter{
+			{name: "name", value: "Alice"},
+			{name: "age", value: "size($name) + 37", expression: true},
+		})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parameters).To(Equal(map[string]interface{}{"name": "Alice", "age": neo4j.Integer(42)}))
+	})
+}
+
+func TestJsonValue(t *testing.T) {
+	RegisterTestingT(t)
+
+	properties := &neo4j.Map{}
+	properties.Set("name", newString("Alice"))
+	alice := &neo4j.Node{Id: 1, Labels: []string{"Person"}, Properties: properties}
+	bob := &neo4j.Node{Id: 2, Labels: []string{"Person"}}
+	knows := &neo4j.UnboundRelationship{Id: 3, Type: "KNOWS"}
+	values := map[string]neo4j.Value{
+		"date":     &neo4j.Structure{TagByte: 0x44, Fields: []neo4j.Value{neo4j.Integer(19723)}},
+		"time":     &neo4j.Structure{TagByte: 0x54, Fields: []neo4j.Value{neo4j.Integer(45296500000000), neo4j.Integer(3600)}},
+		"local":    &neo4j.Structure{TagByte: 0x64, Fields: []neo4j.Value{neo4j.Integer(1704110400), neo4j.Integer(0)}},
+		"duration": &neo4j.Structure{TagByte: 0x45, Fields: []neo4j.Value{neo4j.Integer(14), neo4j.Integer(3), neo4j.Integer(-1), neo4j.Integer(500000000)}},
+		"point":    &neo4j.Structure{TagByte: 0x58, Fields: []neo4j.Value{neo4j.Integer(4326), neo4j.Float(2.35), neo4j.Float(48.85)}},
+		"path": &neo4j.Structure{TagByte: 0x50, Fields: []neo4j.Value{
+			&neo4j.List{alice, bob},
+			&neo4j.List{knows},
+			&neo4j.List{neo4j.Integer(-1), neo4j.Integer(1)},
+		}},
+	}
+	expected := map[string]string{
+		"date":     `"2024-01-01"`,
+		"time":     `"12:34:56.5+01:00"`,
+		"local":    `"2024-01-01T12:00:00"`,
+		"duration": `"P14M3DT-0.5S"`,
+		"point":    `{"srid":4326,"x":2.35,"y":48.85}`,
+		"path": `{"nodes":[{"id":1,"labels":["Person"],"properties":{"name":"Alice"}},{"id":2,"labels":["Person"],"properties":{}}],` +
+			`"relationships":[{"id":3,"type":"KNOWS","start":2,"end":1,"properties":{}}]}`,
+	}
+
+	for name, value := range values {
+		encoded, err := json.Marshal(jsonValue(value))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(encoded)).To(Equal(expected[name]), name)
+	}
+}
+
+func newString(value string) *neo4j.String {
+	result := neo4j.String(value)
+	return &result
+}
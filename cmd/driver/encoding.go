@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	"math"
+	"strings"
+	"time"
+)
+
+// jsonObject is a JSON object keeping its entries in order
+type jsonObject []jsonEntry
+
+type jsonEntry struct {
+	key   string
+	value interface{}
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	buffer := bytes.Buffer{}
+	buffer.WriteString("{")
+	for i, entry := range o {
+		if i > 0 {
+			buffer.WriteString(",")
+		}
+		key, err := json.Marshal(entry.key)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(key)
+		buffer.WriteString(":")
+		value, err := json.Marshal(entry.value)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(value)
+	}
+	buffer.WriteString("}")
+	return buffer.Bytes(), nil
+}
+
+// jsonValue converts the value to what encoding/json renders. Graph values
+// become objects, temporal values ISO 8601 strings and points objects with
+// their SRID and coordinates.
+func jsonValue(value neo4j.Value) interface{} {
+	if temporal, found := temporalString(value); found {
+		return temporal
+	}
+	switch v := value.(type) {
+	case *neo4j.Null:
+		return nil
+	case neo4j.Boolean:
+		return bool(v)
+	case neo4j.Integer:
+		return int64(v)
+	case neo4j.Float:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return v.String()
+		}
+		return float64(v)
+	case *neo4j.String:
+		return string(*v)
+	case *neo4j.List:
+		result := make([]interface{}, len(*v))
+		for i, element := range *v {
+			result[i] = jsonValue(element)
+		}
+		return result
+	case *neo4j.Map:
+		return jsonProperties(v)
+	case *neo4j.Node:
+		return withElementId(jsonObject{
+			{"id", v.Id},
+			{"labels", append([]string{}, v.Labels...)},
+			{"properties", jsonProperties(v.Properties)},
+		}, v.ElementId)
+	case *neo4j.Relationship:
+		return withElementId(jsonObject{
+			{"id", v.Id},
+			{"type", v.Type},
+			{"start", v.StartId},
+			{"end", v.EndId},
+			{"properties", jsonProperties(v.Properties)},
+		}, v.ElementId)
+	case *neo4j.UnboundRelationship:
+		return withElementId(jsonObject{
+			{"id", v.Id},
+			{"type", v.Type},
+			{"properties", jsonProperties(v.Properties)},
+		}, v.ElementId)
+	case *neo4j.Structure:
+		if result, converted := jsonStructure(v); converted {
+			return result
+		}
+		fields := make([]interface{}, len(v.Fields))
+		for i, field := range v.Fields {
+			fields[i] = jsonValue(field)
+		}
+		return jsonObject{{"structure", structureName(v)}, {"fields", fields}}
+	}
+	return value.String()
+}
+
+func jsonProperties(properties *neo4j.Map) jsonObject {
+	result := jsonObject{}
+	if properties == nil {
+		return result
+	}
+	for _, entry := range properties.Entries {
+		result = append(result, jsonEntry{entry.Key, jsonValue(entry.Value)})
+	}
+	return result
+}
+
+func withElementId(object jsonObject, elementId string) jsonObject {
+	if elementId == "" {
+		return object
+	}
+	return append(jsonObject{{"elementId", elementId}}, object...)
+}
+
+// jsonStructure converts points and paths
+func jsonStructure(structure *neo4j.Structure) (interface{}, bool) {
+	switch structureName(structure) {
+	case "POINT_2D", "POINT_3D":
+		coordinates := []string{"x", "y", "z"}
+		result := jsonObject{}
+		for i, field := range structure.Fields {
+			key := "srid"
+			if i > 0 && i <= len(coordinates) {
+				key = coordinates[i-1]
+			}
+			result = append(result, jsonEntry{key, jsonValue(field)})
+		}
+		return result, true
+	case "PATH":
+		return jsonPath(structure)
+	}
+	return nil, false
+}
+
+// jsonPath lists the nodes of the path and its relationships, oriented with
+// their start and end node IDs
+func jsonPath(path *neo4j.Structure) (interface{}, bool) {
+	if len(path.Fields) != 3 {
+		return nil, false
+	}
+	nodes, nodesOk := path.Fields[0].(*neo4j.List)
+	relationships, relationshipsOk := path.Fields[1].(*neo4j.List)
+	indices, indicesOk := path.Fields[2].(*neo4j.List)
+	if !nodesOk || !relationshipsOk || !indicesOk || len(*nodes) == 0 || len(*indices)%2 != 0 {
+		return nil, false
+	}
+	jsonNodes := make([]interface{}, len(*nodes))
+	for i, node := range *nodes {
+		jsonNodes[i] = jsonValue(node)
+	}
+	var jsonRelationships []interface{}
+	current, _ := (*nodes)[0].(*neo4j.Node)
+	for i := 0; i < len(*indices); i += 2 {
+		relationshipIndex, relationshipOk := (*indices)[i].(neo4j.Integer)
+		nodeIndex, nodeOk := (*indices)[i+1].(neo4j.Integer)
+		if !relationshipOk || !nodeOk || nodeIndex < 0 || int(nodeIndex) >= len(*nodes) {
+			return nil, false
+		}
+		position := int(relationshipIndex)
+		if position < 0 {
+			position = -position
+		}
+		if position == 0 || position > len(*relationships) {
+			return nil, false
+		}
+		relationship, relationshipOk := (*relationships)[position-1].(*neo4j.UnboundRelationship)
+		next, nextOk := (*nodes)[nodeIndex].(*neo4j.Node)
+		if !relationshipOk || current == nil || !nextOk {
+			return nil, false
+		}
+		start, end := current, next
+		if relationshipIndex < 0 {
+			start, end = next, current
+		}
+		jsonRelationships = append(jsonRelationships, jsonValue(&neo4j.Relationship{
+			Id:             relationship.Id,
+			ElementId:      relationship.ElementId,
+			StartId:        start.Id,
+			StartElementId: start.ElementId,
+			EndId:          end.Id,
+			EndElementId:   end.ElementId,
+			Type:           relationship.Type,
+			Properties:     relationship.Properties,
+		}))
+		current = next
+	}
+	if jsonRelationships == nil {
+		jsonRelationships = []interface{}{}
+	}
+	return jsonObject{{"nodes", jsonNodes}, {"relationships", jsonRelationships}}, true
+}
+
+// temporalString renders dates, times, date times and durations in the ISO
+// 8601 format
+func temporalString(value neo4j.Value) (string, bool) {
+	if dateTime, casted := value.(*neo4j.DateTime); casted {
+		return dateTime.String(), true
+	}
+	structure, casted := value.(*neo4j.Structure)
+	if !casted {
+		return "", false
+	}
+	fields := make([]int64, len(structure.Fields))
+	for i, field := range structure.Fields {
+		integer, casted := field.(neo4j.Integer)
+		if !casted {
+			return "", false
+		}
+		fields[i] = int64(integer)
+	}
+	switch name := structureName(structure); {
+	case name == "DATE" && len(fields) == 1:
+		return time.Unix(fields[0]*24*3600, 0).UTC().Format("2006-01-02"), true
+	case name == "LOCALTIME" && len(fields) == 1:
+		return time.Unix(0, fields[0]).UTC().Format("15:04:05.999999999"), true
+	case name == "TIME" && len(fields) == 2:
+		zone := time.FixedZone("", int(fields[1]))
+		return time.Date(1970, 1, 1, 0, 0, 0, 0, zone).Add(time.Duration(fields[0])).Format("15:04:05.999999999Z07:00"), true
+	case name == "LOCAL_DATETIME" && len(fields) == 2:
+		return time.Unix(fields[0], fields[1]).UTC().Format("2006-01-02T15:04:05.999999999"), true
+	case name == "DURATION" && len(fields) == 4:
+		return durationString(fields[0], fields[1], fields[2], fields[3]), true
+	}
+	return "", false
+}
+
+// durationString renders durations as P14M3DT7.5S, months and days are not
+// normalised as their length varies
+func durationString(months, days, seconds, nanoseconds int64) string {
+	sign := ""
+	if seconds < 0 && nanoseconds > 0 {
+		seconds++
+		nanoseconds = int64(time.Second) - nanoseconds
+		if seconds == 0 {
+			sign = "-"
+		}
+	}
+	result := fmt.Sprintf("P%dM%dDT%s%d", months, days, sign, seconds)
+	if nanoseconds > 0 {
+		result += strings.TrimRight(fmt.Sprintf(".%09d", nanoseconds), "0")
+	}
+	return result + "S"
+}
+
+func structureName(structure *neo4j.Structure) string {
+	if name := structure.Name(); name != "" {
+		return name
+	}
+	return fmt.Sprintf("<%X>", structure.TagByte)
+}
+
+// textValue renders the value as a CSV field: strings as is, null as an
+// empty field and other values as JSON
+func textValue(value neo4j.Value) (string, error) {
+	switch converted := jsonValue(value).(type) {
+	case nil:
+		return "", nil
+	case string:
+		return converted, nil
+	default:
+		encoded, err := json.Marshal(converted)
+		if err != nil {
+			return "", err
+		}
+		return string(encoded), nil
+	}
+}
+
+// displayValue renders the value in tables, like Cypher literals but for
+// temporal values, rendered in the ISO 8601 format
+func displayValue(value neo4j.Value) string {
+	if temporal, found := temporalString(value); found {
+		return temporal
+	}
+	return neo4j.FormatValue(value)
+}
@@ -4,31 +4,144 @@ import (
 	"flag"
 	"fmt"
 	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
+// scriptParameter is a parameter of the --query and --file statements,
+// given either as a plain string or as a Cypher expression
+type scriptParameter struct {
+	name       string
+	value      string
+	expression bool
+}
+
+// parameterFlags collects the repeated --param name=value flags, or the
+// --param-expr name=expression ones, in the order they are given
+type parameterFlags struct {
+	parameters *[]scriptParameter
+	expression bool
+}
+
+func (p parameterFlags) String() string {
+	if p.parameters == nil {
+		return ""
+	}
+	var result []string
+	for _, parameter := range *p.parameters {
+		if parameter.expression == p.expression {
+			result = append(result, parameter.name+"="+parameter.value)
+		}
+	}
+	return strings.Join(result, ", ")
+}
+
+func (p parameterFlags) Set(flagValue string) error {
+	name, value, found := strings.Cut(flagValue, "=")
+	if !found || strings.TrimSpace(name) == "" {
+		if p.expression {
+			return fmt.Errorf("expected name=expression, such as age=42 or name='Alice'")
+		}
+		return fmt.Errorf("expected name=value, such as name=Alice")
+	}
+	*p.parameters = append(*p.parameters, scriptParameter{name: strings.TrimSpace(name), value: value, expression: p.expression})
+	return nil
+}
+
 func main() {
 	uri := flag.String("uri", "bolt://localhost", "Neo4j URI (e.g.: bolt://localhost)")
 	username := flag.String("username", "neo4j", "Neo4j username (e.g.: neo4j")
 	password := flag.String("password", "", "Neo4j password (e.g.: s3cr3t")
+	database := flag.String("database", "", "database to run statements against, defaults to the user home database")
+	historyFile := flag.String("history", defaultHistoryFile(), "file keeping the history of the shell statements, none is kept when empty")
+	query := flag.String("query", "", "statements to run instead of starting the shell, separated by ;")
+	file := flag.String("file", "", "file of statements to run instead of starting the shell, separated by ;")
+	format := flag.String("format", "table", "output format of --query and --file results: json, jsonl, csv or table")
+	var parameters []scriptParameter
+	flag.Var(parameterFlags{parameters: &parameters}, "param", "string parameter of --query and --file statements as name=value, such as name=Alice, can be repeated")
+	flag.Var(parameterFlags{parameters: &parameters, expression: true}, "param-expr", "parameter of --query and --file statements as name=expression, where the expression is evaluated by the server, such as age=42 or since=date(), can be repeated")
 
 	flag.Parse()
 
-	runExample(*uri, *username, *password)
+	var err error
+	if *query != "" || *file != "" {
+		err = runScript(*uri, *username, *password, *database, *query, *file, *format, parameters)
+	} else {
+		err = runShell(*uri, *username, *password, *database, *historyFile)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 }
 
-func runExample(uri string, username string, password string) {
+func runShell(uri string, username string, password string, database string, historyFile string) error {
 	driver, err := neo4j.NewDriver(uri, username, password)
-	panicOnError(err)
-	defer func() {
-		panicOnError(driver.Close())
-	}()
-	result, err := driver.Run("RETURN 42", neo4j.ReadAccessMode)
-	panicOnError(err)
-	fmt.Printf("%v\n", result)
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+	history, err := loadHistory(historyFile)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Connected to", uri, "- type :help for help, :exit to exit")
+	shell := newShell(driver, os.Stdout, history)
+	shell.database = database
+	return shell.run(os.Stdin)
+}
+
+// runScript runs the statements of the query, then the ones of the file,
+// and streams their results to the standard output
+func runScript(uri string, username string, password string, database string, query string, file string, format string, scriptParameters []scriptParameter) error {
+	writer, err := newRecordWriter(format, os.Stdout)
+	if err != nil {
+		return err
+	}
+	statements := statementsOf(query)
+	if file != "" {
+		script, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		statements = append(statements, statementsOf(string(script))...)
+	}
+	driver, err := neo4j.NewDriver(uri, username, password)
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+	parameters, err := evaluateParameters(driver, database, scriptParameters)
+	if err != nil {
+		return err
+	}
+	return runBatch(driver, database, statements, parameters, writer)
+}
+
+// evaluateParameters evaluates the expressions of the parameters in order, so
+// that they can refer to the previous parameters, and keeps the other
+// parameters as strings
+func evaluateParameters(driver *neo4j.Driver, database string, scriptParameters []scriptParameter) (map[string]interface{}, error) {
+	parameters := make(map[string]interface{}, len(scriptParameters))
+	for _, parameter := range scriptParameters {
+		if !parameter.expression {
+			parameters[parameter.name] = parameter.value
+			continue
+		}
+		value, err := evaluateParameter(driver, database, parameter.value, parameters)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameter %s: %w", parameter.name, err)
+		}
+		parameters[parameter.name] = value
+	}
+	return parameters, nil
 }
 
-func panicOnError(err error) {
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
 	if err != nil {
-		panic(err)
+		return ""
 	}
+	return filepath.Join(home, ".neo4j_go_history")
 }
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const shellHelp = `Statements span as many lines as needed and end with ;
+Commands:
+  :begin                 open a transaction
+  :commit                commit the open transaction
+  :rollback              roll the open transaction back
+  :use <database>        run the next statements against the database
+  :param <name> => <expression>
+                         set a parameter to the value of the expression
+  :params                list the parameters
+  :history               list the statements and commands run so far
+  !<number>              run the entry of :history with the number again,
+                         !! runs the last one again
+  :help                  show this help
+  :exit                  exit the shell`
+
+// shell runs the statements and commands read from its input, one at a time
+type shell struct {
+	driver      *neo4j.Driver
+	output      io.Writer
+	history     *history
+	database    string
+	session     *neo4j.Session
+	transaction *neo4j.Transaction
+	// parameters holds the values of the parameter expressions
+	parameters map[string]interface{}
+	exited     bool
+}
+
+func newShell(driver *neo4j.Driver, output io.Writer, history *history) *shell {
+	return &shell{
+		driver:     driver,
+		output:     output,
+		history:    history,
+		parameters: make(map[string]interface{}),
+	}
+}
+
+// run reads the input until its end or until :exit, statement errors are
+// reported and do not stop the shell
+func (s *shell) run(input io.Reader) error {
+	defer s.close()
+	scanner := bufio.NewScanner(input)
+	pending := ""
+	s.prompt(pending)
+	for !s.exited && scanner.Scan() {
+		pending = s.runLine(pending, scanner.Text())
+		s.prompt(pending)
+	}
+	if s.exited {
+		return nil
+	}
+	return scanner.Err()
+}
+
+// runLine runs the command of the line, the history entry it recalls or the
+// statements it completes, and returns the start of the statement still
+// pending
+func (s *shell) runLine(pending string, line string) string {
+	if trimmed := strings.TrimSpace(line); strings.TrimSpace(pending) == "" {
+		if strings.HasPrefix(trimmed, "!") {
+			entry, err := s.history.recall(trimmed[1:])
+			if err != nil {
+				s.reportError(err)
+				return ""
+			}
+			fmt.Fprintln(s.output, entry)
+			return s.runLine("", entry)
+		}
+		if strings.HasPrefix(trimmed, ":") {
+			s.history.add(trimmed)
+			s.runCommand(trimmed)
+			return ""
+		}
+	}
+	statements, rest := splitStatements(pending + line + "\n")
+	for _, statement := range statements {
+		s.history.add(statement + ";")
+		s.runStatement(statement)
+	}
+	return rest
+}
+
+func (s *shell) prompt(pending string) {
+	if strings.TrimSpace(pending) != "" {
+		fmt.Fprint(s.output, "  ... ")
+		return
+	}
+	database := s.database
+	if database == "" {
+		database = "neo4j"
+	}
+	if s.transaction != nil {
+		fmt.Fprintf(s.output, "%s# ", database)
+		return
+	}
+	fmt.Fprintf(s.output, "%s> ", database)
+}
+
+func (s *shell) runCommand(line string) {
+	command, argument := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		command, argument = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	var err error
+	switch command {
+	case ":begin":
+		err = s.begin()
+	case ":commit":
+		err = s.endTransaction(func(transaction *neo4j.Transaction) error {
+			return transaction.Commit(context.Background())
+		})
+	case ":rollback":
+		err = s.endTransaction(func(transaction *neo4j.Transaction) error {
+			return transaction.Rollback(context.Background())
+		})
+	case ":use":
+		err = s.use(argument)
+	case ":param":
+		err = s.setParameter(argument)
+	case ":params":
+		s.listParameters()
+	case ":history":
+		s.history.list(s.output)
+	case ":help":
+		fmt.Fprintln(s.output, shellHelp)
+	case ":exit", ":quit":
+		s.exited = true
+	default:
+		err = fmt.Errorf("unknown command %s, see :help", command)
+	}
+	if err != nil {
+		s.reportError(err)
+	}
+}
+
+func (s *shell) begin() error {
+	if s.transaction != nil {
+		return fmt.Errorf("a transaction is already open")
+	}
+	session, err := s.currentSession()
+	if err != nil {
+		return err
+	}
+	transaction, err := session.BeginTransaction(context.Background())
+	if err != nil {
+		return err
+	}
+	s.transaction = transaction
+	return nil
+}
+
+func (s *shell) endTransaction(end func(*neo4j.Transaction) error) error {
+	if s.transaction == nil {
+		return fmt.Errorf("no transaction is open")
+	}
+	transaction := s.transaction
+	s.transaction = nil
+	return end(transaction)
+}
+
+func (s *shell) use(database string) error {
+	if database == "" {
+		return fmt.Errorf("expected a database name, such as :use neo4j")
+	}
+	if s.transaction != nil {
+		return fmt.Errorf("cannot change database while a transaction is open")
+	}
+	if err := s.closeSession(); err != nil {
+		return err
+	}
+	s.database = strings.Trim(database, "`;")
+	return nil
+}
+
+func (s *shell) setParameter(argument string) error {
+	name, expression, found := strings.Cut(argument, "=>")
+	name = strings.TrimSpace(name)
+	expression = strings.TrimSuffix(strings.TrimSpace(expression), ";")
+	if !found || name == "" || expression == "" {
+		return fmt.Errorf("expected :param <name> => <expression>, such as :param age => 42")
+	}
+	value, err := evaluateParameter(s.driver, s.database, expression, s.parameters)
+	if err != nil {
+		return err
+	}
+	s.parameters[name] = value
+	fmt.Fprintf(s.output, "%s => %s\n", name, displayValue(value))
+	return nil
+}
+
+// evaluateParameter evaluates the expression server-side, so that parameters
+// are written as Cypher literals such as {name: "Alice"} or date("2024-01-01")
+func evaluateParameter(driver *neo4j.Driver, database string, expression string, parameters map[string]interface{}) (neo4j.Value, error) {
+	session, err := driver.NewSession(neo4j.SessionConfig{DatabaseName: database})
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	result, err := session.RunContext(context.Background(), "RETURN "+expression+" AS value", parameters)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Next() {
+		if result.Err() != nil {
+			return nil, result.Err()
+		}
+		return nil, fmt.Errorf("expression %s returned no value", expression)
+	}
+	value := result.Record().Values[0]
+	if _, err := result.Consume(); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (s *shell) listParameters() {
+	names := make([]string, 0, len(s.parameters))
+	for name := range s.parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(s.output, "%s => %s\n", name, displayValue(s.parameters[name].(neo4j.Value)))
+	}
+}
+
+// runStatement runs the statement in the open transaction, if any, and
+// renders its records as a table
+func (s *shell) runStatement(statement string) {
+	started := time.Now()
+	result, err := s.runQuery(statement)
+	if err != nil {
+		s.queryFailed(err)
+		return
+	}
+	var rows [][]string
+	for result.Next() {
+		values := result.Record().Values
+		row := make([]string, len(values))
+		for i, value := range values {
+			row[i] = displayValue(value)
+		}
+		rows = append(rows, row)
+	}
+	if _, err := result.Consume(); err != nil {
+		s.queryFailed(err)
+		return
+	}
+	elapsed := time.Since(started)
+	if len(result.Keys()) > 0 {
+		writeTable(s.output, result.Keys(), rows)
+	}
+	rowCount := "rows"
+	if len(rows) == 1 {
+		rowCount = "row"
+	}
+	fmt.Fprintf(s.output, "%d %s in %d ms\n", len(rows), rowCount, elapsed.Milliseconds())
+}
+
+func (s *shell) runQuery(statement string) (*neo4j.Result, error) {
+	if s.transaction != nil {
+		return s.transaction.Run(context.Background(), statement, s.parameters)
+	}
+	session, err := s.currentSession()
+	if err != nil {
+		return nil, err
+	}
+	return session.RunContext(context.Background(), statement, s.parameters)
+}
+
+// queryFailed reports the error and forgets about the open transaction, if
+// any, which failed queries close
+func (s *shell) queryFailed(err error) {
+	s.reportError(err)
+	if s.transaction != nil {
+		s.transaction = nil
+		fmt.Fprintln(s.output, "The transaction has been rolled back")
+	}
+}
+
+func (s *shell) currentSession() (*neo4j.Session, error) {
+	if s.session != nil {
+		return s.session, nil
+	}
+	session, err := s.driver.NewSession(neo4j.SessionConfig{
+		AccessMode:   neo4j.WriteAccessMode,
+		DatabaseName: s.database,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.session = session
+	return session, nil
+}
+
+func (s *shell) closeSession() error {
+	if s.session == nil {
+		return nil
+	}
+	session := s.session
+	s.session = nil
+	return session.Close()
+}
+
+// close rolls back the open transaction, if any
+func (s *shell) close() {
+	s.transaction = nil
+	if err := s.closeSession(); err != nil {
+		s.reportError(err)
+	}
+}
+
+func (s *shell) reportError(err error) {
+	fmt.Fprintf(s.output, "Error: %v\n", err)
+}
+
+// splitStatements returns the complete statements of the input, that is
+// the ones ended with a semicolon, and what follows the last one. Semicolons
+// in quoted strings, quoted names, line comments and block comments do not
+// end statements.
+func splitStatements(input string) ([]string, string) {
+	var statements []string
+	start := 0
+	var quote rune
+	escaped := false
+	comment := false
+	blockComment := false
+	runes := []rune(input)
+	for i := 0; i < len(runes); i++ {
+		char := runes[i]
+		switch {
+		case comment:
+			comment = char != '\n'
+		case blockComment:
+			if char == '*' && i+1 < len(runes) && runes[i+1] == '/' {
+				blockComment = false
+				i++
+			}
+		case quote != 0:
+			if escaped {
+				escaped = false
+			} else if char == '\\' && quote != '`' {
+				escaped = true
+			} else if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"' || char == '`':
+			quote = char
+		case char == '/' && i+1 < len(runes) && runes[i+1] == '/':
+			comment = true
+		case char == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			blockComment = true
+			i++
+		case char == ';':
+			if statement := strings.TrimSpace(string(runes[start:i])); statement != "" {
+				statements = append(statements, statement)
+			}
+			start = i + 1
+		}
+	}
+	return statements, string(runes[start:])
+}
+
+// history keeps the statements and commands run, in a file when set
+type history struct {
+	file    string
+	entries []string
+}
+
+// loadHistory reads the previous entries of the file, if it exists
+func loadHistory(file string) (*history, error) {
+	result := &history{file: file}
+	if file == "" {
+		return result, nil
+	}
+	content, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range strings.Split(string(content), "\n") {
+		if entry != "" {
+			result.entries = append(result.entries, entry)
+		}
+	}
+	return result, nil
+}
+
+// add records the entry, with its line breaks turned into spaces so that
+// each entry fits on a line of the history file
+func (h *history) add(entry string) {
+	entry = strings.ReplaceAll(entry, "\n", " ")
+	h.entries = append(h.entries, entry)
+	if h.file == "" {
+		return
+	}
+	file, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, entry)
+}
+
+// recall returns the entry with the number, or the last entry when the
+// reference is !
+func (h *history) recall(reference string) (string, error) {
+	if len(h.entries) == 0 {
+		return "", fmt.Errorf("the history is empty")
+	}
+	if reference == "!" {
+		return h.entries[len(h.entries)-1], nil
+	}
+	number, err := strconv.Atoi(reference)
+	if err != nil || number < 1 || number > len(h.entries) {
+		return "", fmt.Errorf("expected !! or !<number> with a number from 1 to %d, see :history", len(h.entries))
+	}
+	return h.entries[number-1], nil
+}
+
+func (h *history) list(output io.Writer) {
+	for i, entry := range h.entries {
+		fmt.Fprintf(output, "%4d  %s\n", i+1, entry)
+	}
+}
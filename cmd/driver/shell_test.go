@@ -0,0 +1,125 @@
+package main
+
+import (
+	"github.com/fbiville/go-usain-go/pkg/bolttest"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	. "github.com/onsi/gomega"
+	"strings"
+	"testing"
+)
+
+func TestShell(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("runs statements and commands", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.ExpectRun("RETURN 40 + 2 AS value", bolttest.Success(map[string]interface{}{"fields": []string{"value"}})),
+			bolttest.Expect("PULL", bolttest.Record(42), bolttest.Success(nil)),
+			bolttest.ExpectRun("RETURN $x AS answer,\n  'a;b' AS text").
+				WithField(1, map[string]interface{}{"x": 42}),
+			bolttest.Expect("PULL",
+				bolttest.Success(map[string]interface{}{"fields": []string{"answer", "text"}}),
+				bolttest.Record(42, "a;b"),
+				bolttest.Success(nil)),
+			bolttest.Expect("BEGIN", bolttest.Success(nil)),
+			bolttest.ExpectRun("CREATE ()", bolttest.Success(map[string]interface{}{"fields": []string{}})),
+			bolttest.Expect("PULL", bolttest.Success(nil)),
+			bolttest.Expect("COMMIT", bolttest.Success(nil)),
+		)
+		driver, err := neo4j.NewDriver(server.URI(), "neo4j", "s3cr3t")
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		output := &strings.Builder{}
+		input := strings.NewReader(`:param x => 40 + 2
+RETURN $x AS answer,
+  'a;b' AS text;
+:begin
+CREATE ();
+:commit
+:history
+:exit
+`)
+
+		Expect(newShell(driver, output, &history{}).run(input)).To(Succeed())
+
+		Expect(output.String()).To(ContainSubstring("x => 42\n"))
+		Expect(output.String()).To(ContainSubstring(`+--------+-------+
+| answer | text  |
++--------+-------+
+| 42     | "a;b" |
++--------+-------+
+1 row in `))
+		Expect(output.String()).To(ContainSubstring("neo4j# 0 rows in "))
+		Expect(output.String()).To(ContainSubstring("   2  RETURN $x AS answer,   'a;b' AS text;\n"))
+		Expect(output.String()).NotTo(ContainSubstring("Error"))
+	})
+
+	t.Run("runs history entries again", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.ExpectRun("RETURN 1 AS one", bolttest.Success(map[string]interface{}{"fields": []string{"one"}})),
+			bolttest.Expect("PULL", bolttest.Record(1), bolttest.Success(nil)),
+			bolttest.ExpectRun("RETURN 1 AS one", bolttest.Success(map[string]interface{}{"fields": []string{"one"}})),
+			bolttest.Expect("PULL", bolttest.Record(1), bolttest.Success(nil)),
+			bolttest.ExpectRun("RETURN 1 AS one", bolttest.Success(map[string]interface{}{"fields": []string{"one"}})),
+			bolttest.Expect("PULL", bolttest.Record(1), bolttest.Success(nil)),
+		)
+		driver, err := neo4j.NewDriver(server.URI(), "neo4j", "s3cr3t")
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		output := &strings.Builder{}
+		shellHistory := &history{}
+
+		Expect(newShell(driver, output, shellHistory).run(strings.NewReader("RETURN 1 AS one;\n:params\n!1\n!!\n!9\n"))).To(Succeed())
+
+		Expect(strings.Count(output.String(), "1 row in ")).To(Equal(3))
+		Expect(strings.Count(output.String(), "neo4j> RETURN 1 AS one;\n")).To(Equal(2))
+		Expect(output.String()).To(ContainSubstring("Error: expected !! or !<number> with a number from 1 to 4, see :history"))
+		Expect(shellHistory.entries).To(Equal([]string{"RETURN 1 AS one;", ":params", "RETURN 1 AS one;", "RETURN 1 AS one;"}))
+	})
+
+	t.Run("reports failures and rolls back failed transactions", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.Expect("BEGIN", bolttest.Success(nil)),
+			bolttest.ExpectRun("RETURN x", bolttest.Failure("Neo.ClientError.Statement.SyntaxError", "invalid query")),
+			bolttest.Expect("PULL", bolttest.Ignored()),
+			bolttest.Expect("RESET", bolttest.Success(nil)),
+		)
+		driver, err := neo4j.NewDriver(server.URI(), "neo4j", "s3cr3t")
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		output := &strings.Builder{}
+
+		Expect(newShell(driver, output, &history{}).run(strings.NewReader(":begin\nRETURN x;\n:commit\n:nope\n"))).To(Succeed())
+
+		Expect(output.String()).To(ContainSubstring("invalid query"))
+		Expect(output.String()).To(ContainSubstring("The transaction has been rolled back\nneo4j> Error: no transaction is open"))
+		Expect(output.String()).To(ContainSubstring("Error: unknown command :nope"))
+	})
+}
+
+func TestSplitStatements(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("ignores semicolons of quotes and line comments", func(t *testing.T) {
+		statements, rest := splitStatements("RETURN ';' AS a; // comment; still a comment\nRETURN \"\\\";\" AS `b;`;\nMATCH (n)")
+
+		Expect(statements).To(Equal([]string{
+			"RETURN ';' AS a",
+			"// comment; still a comment\nRETURN \"\\\";\" AS `b;`",
+		}))
+		Expect(rest).To(Equal("\nMATCH (n)"))
+	})
+
+	t.Run("ignores semicolons of block comments", func(t *testing.T) {
+		statements, rest := splitStatements("RETURN 1 /* one; \n two; */ AS a; /* ;*/ RETURN 2; /* open;")
+
+		Expect(statements).To(Equal([]string{
+			"RETURN 1 /* one; \n two; */ AS a",
+			"/* ;*/ RETURN 2",
+		}))
+		Expect(rest).To(Equal(" /* open;"))
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// writeTable renders the rows below the keys, in columns as wide as their
+// widest cell
+func writeTable(output io.Writer, keys []string, rows [][]string) {
+	widths := make([]int, len(keys))
+	for i, key := range keys {
+		widths[i] = utf8.RuneCountInString(key)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			if width := utf8.RuneCountInString(cell); width > widths[i] {
+				widths[i] = width
+			}
+		}
+	}
+	separator := tableSeparator(widths)
+	fmt.Fprintln(output, separator)
+	writeTableRow(output, widths, keys)
+	fmt.Fprintln(output, separator)
+	for _, row := range rows {
+		writeTableRow(output, widths, row)
+	}
+	if len(rows) > 0 {
+		fmt.Fprintln(output, separator)
+	}
+}
+
+func tableSeparator(widths []int) string {
+	builder := strings.Builder{}
+	builder.WriteString("+")
+	for _, width := range widths {
+		builder.WriteString(strings.Repeat("-", width+2))
+		builder.WriteString("+")
+	}
+	return builder.String()
+}
+
+func writeTableRow(output io.Writer, widths []int, cells []string) {
+	builder := strings.Builder{}
+	builder.WriteString("|")
+	for i, cell := range cells {
+		builder.WriteString(" ")
+		builder.WriteString(cell)
+		builder.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)+1))
+		builder.WriteString("|")
+	}
+	fmt.Fprintln(output, builder.String())
+}
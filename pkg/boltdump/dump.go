@@ -0,0 +1,101 @@
+// Package boltdump decodes captured Bolt traffic, such as hex dumps and pcap
+// captures, into the handshake steps and messages it is made of
+package boltdump
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/internal/bolt"
+	"io"
+	"strings"
+)
+
+// Directions of the traffic
+const (
+	ClientToServer = bolt.ClientToServer
+	ServerToClient = bolt.ServerToClient
+)
+
+// Dumper writes the handshake steps and messages of the traffic one per line,
+// after the direction of the traffic and the offset of their first byte in
+// this direction, such as
+// C 00000014  RUN "RETURN 42" {} {mode: "r"}
+type Dumper struct {
+	Output io.Writer
+	// Verbose adds the hexadecimal dump of the bytes of each entry, message
+	// bytes once reassembled from their chunks
+	Verbose bool
+	// problems counts the malformed entries and incomplete streams found
+	problems int
+}
+
+var handshakeMagic = []byte{0x60, 0x60, 0xB0, 0x17}
+
+// DumpStream decodes one direction of the traffic of a connection, which
+// starts with the handshake when its first bytes are the handshake ones.
+// Client streams are decoded as if the server did not answer the handshake
+// with a manifest. It fails when some of the data is malformed or
+// incomplete, once all the data is decoded.
+func (d *Dumper) DumpStream(data []byte, direction string) error {
+	handshake := startsWithHandshake(data, direction)
+	stream := bolt.NewTrafficStream(direction, handshake)
+	for _, entry := range stream.Feed(data, false) {
+		d.write("", direction, entry)
+	}
+	d.checkComplete("", direction, stream, len(data))
+	return d.err()
+}
+
+// startsWithHandshake tells whether the data starts with the handshake of
+// the client, or with the version or manifest the server answers it with
+func startsWithHandshake(data []byte, direction string) bool {
+	if direction == ClientToServer {
+		return bytes.HasPrefix(data, handshakeMagic)
+	}
+	if len(data) < 4 || data[0] != 0 {
+		return false
+	}
+	manifest := data[1] == 0 && data[2] == 1 && data[3] == 0xFF
+	version := data[1] == 0 && data[3] >= 1 && data[3] <= 9
+	return manifest || version
+}
+
+func (d *Dumper) write(prefix string, direction string, entry bolt.TrafficEntry) {
+	if entry.Malformed {
+		d.problems++
+	}
+	fmt.Fprintf(d.Output, "%s%s %08X  %s\n", prefix, direction, entry.Offset, entry.Message)
+	if !d.Verbose {
+		return
+	}
+	raw, err := entry.RawBytes()
+	if err != nil || len(raw) == 0 {
+		return
+	}
+	for _, line := range strings.SplitAfter(strings.TrimRight(hex.Dump(raw), "\n"), "\n") {
+		fmt.Fprintf(d.Output, "%s    %s", prefix, line)
+	}
+	fmt.Fprintln(d.Output)
+}
+
+// checkComplete reports the bytes left over at the end of the stream, which
+// are not enough to complete a handshake step or a message
+func (d *Dumper) checkComplete(prefix string, direction string, stream *bolt.TrafficStream, size int) {
+	pending := stream.Pending()
+	if pending == 0 {
+		return
+	}
+	d.write(prefix, direction, bolt.TrafficEntry{
+		Offset:    size - pending,
+		Message:   fmt.Sprintf("<incomplete: %d bytes left over>", pending),
+		Malformed: true,
+	})
+}
+
+func (d *Dumper) err() error {
+	if d.problems == 0 {
+		return nil
+	}
+	return fmt.Errorf("found %d malformed or incomplete entries", d.problems)
+}
@@ -0,0 +1,189 @@
+package boltdump_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/fbiville/go-usain-go/pkg/boltdump"
+	. "github.com/onsi/gomega"
+	"strings"
+	"testing"
+)
+
+var clientHandshake = []byte{0x60, 0x60, 0xB0, 0x17, 0, 0, 4, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+
+var hello = []byte{0, 0x0F, 0xB1, 0x01, 0xA1, 0x86, 's', 'c', 'h', 'e', 'm', 'e', 0x84, 'n', 'o', 'n', 'e', 0, 0}
+
+func TestDumpStream(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("decodes the handshake and messages with their offsets", func(t *testing.T) {
+		output := &strings.Builder{}
+		dumper := &boltdump.Dumper{Output: output}
+
+		err := dumper.DumpStream(concat(clientHandshake, hello, []byte{0, 0, 0, 3, 0xB1, 0x3F, 0xA0, 0, 0}), boltdump.ClientToServer)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output.String()).To(Equal(`C 00000000  HANDSHAKE 6060B017 [4.4]
+C 00000014  HELLO {scheme: "none"}
+C 00000029  PULL {}
+`))
+	})
+
+	t.Run("decodes server traffic without handshake", func(t *testing.T) {
+		output := &strings.Builder{}
+		dumper := &boltdump.Dumper{Output: output}
+
+		err := dumper.DumpStream([]byte{0, 2, 0xB0, 0x7E, 0, 0}, boltdump.ServerToClient)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output.String()).To(Equal("S 00000000  IGNORED\n"))
+	})
+
+	t.Run("reports malformed and incomplete messages", func(t *testing.T) {
+		output := &strings.Builder{}
+		dumper := &boltdump.Dumper{Output: output}
+
+		err := dumper.DumpStream([]byte{0, 2, 0xB2, 0x70, 0, 0, 0, 5, 0xB1}, boltdump.ServerToClient)
+
+		Expect(err).To(MatchError("found 2 malformed or incomplete entries"))
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[0]).To(HavePrefix("S 00000000  <malformed: "))
+		Expect(lines[1]).To(Equal("S 00000006  <incomplete: 3 bytes left over>"))
+	})
+}
+
+func TestDumpPcap(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("decodes the connections to the port", func(t *testing.T) {
+		capture := newCapture()
+		capture.packet(52100, 7687, 1000, true, nil)
+		capture.packet(7687, 52100, 5000, true, nil)
+		capture.packet(52100, 7687, 1001, false, clientHandshake)
+		capture.packet(52100, 7687, 1001, false, clientHandshake)
+		capture.packet(7687, 52100, 5001, false, []byte{0, 0, 4, 4})
+		capture.packet(52100, 7687, 1021, false, hello[:5])
+		capture.packet(52100, 7687, 1026, false, hello[5:])
+		capture.packet(52100, 80, 1, false, []byte("GET / HTTP/1.1"))
+		output := &strings.Builder{}
+		dumper := &boltdump.Dumper{Output: output}
+
+		err := dumper.DumpPcap(bytes.NewReader(capture.Bytes()), boltdump.DefaultPort)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output.String()).To(Equal(`#1 10.0.0.1:52100 -> 10.0.0.2:7687
+#1 00:00:01.000000 C 00000000  HANDSHAKE 6060B017 [4.4]
+#1 00:00:01.000000 S 00000000  VERSION 4.4
+#1 00:00:01.000000 C 00000014  HELLO {scheme: "none"}
+`))
+	})
+
+	t.Run("reports gaps in the capture", func(t *testing.T) {
+		capture := newCapture()
+		capture.packet(7687, 52100, 5000, false, []byte{0, 2, 0xB0, 0x7E, 0, 0})
+		capture.packet(7687, 52100, 5010, false, []byte{0, 2, 0xB0, 0x7E, 0, 0})
+		output := &strings.Builder{}
+		dumper := &boltdump.Dumper{Output: output}
+
+		err := dumper.DumpPcap(bytes.NewReader(capture.Bytes()), boltdump.DefaultPort)
+
+		Expect(err).To(HaveOccurred())
+		Expect(output.String()).To(ContainSubstring("S 00000006  <gap: 4 bytes were not captured"))
+		Expect(output.String()).To(ContainSubstring("S 0000000A  IGNORED"))
+	})
+
+	t.Run("reorders segments captured out of order", func(t *testing.T) {
+		capture := newCapture()
+		capture.packet(52100, 7687, 1000, true, nil)
+		capture.packet(52100, 7687, 1026, false, hello[5:])
+		capture.packet(52100, 7687, 1001, false, clientHandshake)
+		capture.packet(52100, 7687, 1021, false, hello[:5])
+		output := &strings.Builder{}
+		dumper := &boltdump.Dumper{Output: output}
+
+		err := dumper.DumpPcap(bytes.NewReader(capture.Bytes()), boltdump.DefaultPort)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(output.String()).To(Equal(`#1 10.0.0.1:52100 -> 10.0.0.2:7687
+#1 00:00:01.000000 C 00000000  HANDSHAKE 6060B017 [4.4]
+#1 00:00:01.000000 C 00000014  HELLO {scheme: "none"}
+`))
+	})
+
+	t.Run("rejects packets larger than the snapshot length", func(t *testing.T) {
+		capture := newCapture()
+		header := make([]byte, 16)
+		binary.LittleEndian.PutUint32(header[8:], 0xFFFFFFFF)
+		capture.Write(header)
+
+		err := (&boltdump.Dumper{Output: &strings.Builder{}}).DumpPcap(bytes.NewReader(capture.Bytes()), boltdump.DefaultPort)
+
+		Expect(err).To(MatchError("malformed packet header: captured length of 4294967295 bytes exceeds the snapshot length of 65535 bytes"))
+	})
+
+	t.Run("rejects pcapng captures", func(t *testing.T) {
+		pcapng := []byte{0x0A, 0x0D, 0x0D, 0x0A, 0, 0, 0, 0x1C, 0x1A, 0x2B, 0x3C, 0x4D}
+
+		Expect(boltdump.IsPcap(pcapng)).To(BeTrue())
+		err := (&boltdump.Dumper{Output: &strings.Builder{}}).DumpPcap(bytes.NewReader(append(pcapng, make([]byte, 12)...)), boltdump.DefaultPort)
+		Expect(err).To(MatchError(ContainSubstring("pcapng captures are not supported")))
+	})
+}
+
+// capture writes a little-endian pcap capture of Ethernet frames, exchanged
+// between 10.0.0.1 and 10.0.0.2 one second after the epoch
+type capture struct {
+	bytes.Buffer
+}
+
+func newCapture() *capture {
+	result := &capture{}
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header, 0xA1B2C3D4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], 65535)
+	binary.LittleEndian.PutUint32(header[20:], 1)
+	result.Write(header)
+	return result
+}
+
+func (c *capture) packet(sourcePort, destinationPort uint16, sequence uint32, syn bool, payload []byte) {
+	source, destination := []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}
+	if sourcePort == 7687 {
+		source, destination = destination, source
+	}
+	tcp := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp, sourcePort)
+	binary.BigEndian.PutUint16(tcp[2:], destinationPort)
+	binary.BigEndian.PutUint32(tcp[4:], sequence)
+	tcp[12] = 5 << 4
+	tcp[13] = 0x10
+	if syn {
+		tcp[13] = 0x02
+	}
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)+len(payload)))
+	ip[9] = 6
+	copy(ip[12:], source)
+	copy(ip[16:], destination)
+	ethernet := make([]byte, 14)
+	binary.BigEndian.PutUint16(ethernet[12:], 0x0800)
+	frame := concat(ethernet, ip, tcp, payload)
+	header := make([]byte, 16)
+	binary.LittleEndian.PutUint32(header, 1)
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(frame)))
+	c.Write(header)
+	c.Write(frame)
+}
+
+func concat(slices ...[]byte) []byte {
+	var result []byte
+	for _, slice := range slices {
+		result = append(result, slice...)
+	}
+	return result
+}
@@ -0,0 +1,389 @@
+package boltdump
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/internal/bolt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// DefaultPort is the port Bolt servers listen on by default
+const DefaultPort = 7687
+
+// link types of the captured packets
+const (
+	linkNull      = 0
+	linkEthernet  = 1
+	linkRaw       = 101
+	linkLinuxSll  = 113
+	linkIPv4      = 228
+	linkIPv6      = 229
+	linkLinuxSll2 = 276
+)
+
+// maxPacketSize bounds the captured length of the packets, whatever the
+// snapshot length of the capture says, the largest one tools default to
+const maxPacketSize = 262144
+
+// maxOutOfOrder bounds the segments a stream keeps while waiting for the ones
+// captured later or not at all, past which the missing bytes are reported as
+// not captured
+const maxOutOfOrder = 64
+
+var errPcapng = fmt.Errorf("pcapng captures are not supported, convert them to pcap with editcap -F pcap")
+
+// IsPcap tells whether the data starts like a pcap capture, or like a
+// pcapng one that DumpPcap rejects
+func IsPcap(data []byte) bool {
+	_, _, err := pcapByteOrder(data)
+	return err == nil || err == errPcapng
+}
+
+func pcapByteOrder(data []byte) (binary.ByteOrder, time.Duration, error) {
+	if len(data) < 4 {
+		return nil, 0, fmt.Errorf("capture is too short")
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(data) {
+		case 0xA1B2C3D4:
+			return order, time.Microsecond, nil
+		case 0xA1B23C4D:
+			return order, time.Nanosecond, nil
+		case 0x0A0D0D0A:
+			return nil, 0, errPcapng
+		}
+	}
+	return nil, 0, fmt.Errorf("not a pcap capture")
+}
+
+// DumpPcap decodes the TCP connections of the capture to and from the port,
+// numbered in the order their first packet was captured. Each entry starts
+// with the connection number and the capture time of the packet completing
+// it. Streams start with the handshake when the capture includes it.
+func (d *Dumper) DumpPcap(reader io.Reader, port int) error {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return fmt.Errorf("could not read pcap header: %w", err)
+	}
+	order, precision, err := pcapByteOrder(header)
+	if err != nil {
+		return err
+	}
+	snapshotLength := order.Uint32(header[16:])
+	if snapshotLength == 0 || snapshotLength > maxPacketSize {
+		snapshotLength = maxPacketSize
+	}
+	capture := &capture{
+		dumper:      d,
+		port:        port,
+		linkType:    order.Uint32(header[20:]),
+		connections: make(map[string]*tcpConnection),
+	}
+	packetHeader := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(reader, packetHeader); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("could not read packet header: %w", err)
+		}
+		timestamp := time.Unix(int64(order.Uint32(packetHeader)), int64(order.Uint32(packetHeader[4:]))*int64(precision))
+		size := order.Uint32(packetHeader[8:])
+		if size > snapshotLength {
+			return fmt.Errorf("malformed packet header: captured length of %d bytes exceeds the snapshot length of %d bytes", size, snapshotLength)
+		}
+		packet := make([]byte, size)
+		if _, err := io.ReadFull(reader, packet); err != nil {
+			return fmt.Errorf("could not read packet: %w", err)
+		}
+		capture.packet(timestamp, packet)
+	}
+	capture.finish()
+	return d.err()
+}
+
+type capture struct {
+	dumper      *Dumper
+	port        int
+	linkType    uint32
+	connections map[string]*tcpConnection
+	// ordered lists the connections in the order of their first packet
+	ordered []*tcpConnection
+}
+
+type tcpConnection struct {
+	id     int
+	client *tcpStream
+	server *tcpStream
+}
+
+// tcpStream reassembles one direction of a connection, in sequence order
+type tcpStream struct {
+	direction string
+	// started is set once the first data or SYN packet sets nextSequence
+	started      bool
+	nextSequence uint32
+	// traffic is created on the first data, starting with the handshake
+	// when the data does
+	traffic   *bolt.TrafficStream
+	handshake bool
+	// fed counts the bytes fed to traffic, skipped the ones not captured
+	fed     int
+	skipped int
+	// outOfOrder holds the segments following data not captured yet, which
+	// may have been captured out of order
+	outOfOrder []pendingSegment
+}
+
+type pendingSegment struct {
+	timestamp time.Time
+	sequence  uint32
+	payload   []byte
+}
+
+type tcpSegment struct {
+	source      string
+	sourcePort  int
+	destination string
+	port        int
+	sequence    uint32
+	syn         bool
+	payload     []byte
+}
+
+func (c *capture) packet(timestamp time.Time, packet []byte) {
+	segment, ok := c.decode(packet)
+	if !ok || segment.sourcePort != c.port && segment.port != c.port {
+		return
+	}
+	clientAddress := net.JoinHostPort(segment.source, strconv.Itoa(segment.sourcePort))
+	serverAddress := net.JoinHostPort(segment.destination, strconv.Itoa(segment.port))
+	direction := ClientToServer
+	if segment.sourcePort == c.port {
+		clientAddress, serverAddress = serverAddress, clientAddress
+		direction = ServerToClient
+	}
+	key := clientAddress + " " + serverAddress
+	connection, found := c.connections[key]
+	if !found {
+		connection = &tcpConnection{
+			id:     len(c.ordered) + 1,
+			client: &tcpStream{direction: ClientToServer},
+			server: &tcpStream{direction: ServerToClient},
+		}
+		c.connections[key] = connection
+		c.ordered = append(c.ordered, connection)
+		fmt.Fprintf(c.dumper.Output, "#%d %s -> %s\n", connection.id, clientAddress, serverAddress)
+	}
+	stream := connection.client
+	if direction == ServerToClient {
+		stream = connection.server
+	}
+	c.feed(connection, stream, timestamp, segment)
+}
+
+// feed reassembles the segment, segments following missing data wait for it
+// until the stream buffers too many of them or the capture ends
+func (c *capture) feed(connection *tcpConnection, stream *tcpStream, timestamp time.Time, segment tcpSegment) {
+	sequence := segment.sequence
+	if segment.syn {
+		// SYN counts as one byte of the sequence
+		sequence++
+	}
+	if !stream.started {
+		stream.started = true
+		stream.nextSequence = sequence
+	}
+	if int32(sequence-stream.nextSequence) > 0 {
+		if len(segment.payload) > 0 {
+			stream.outOfOrder = append(stream.outOfOrder, pendingSegment{timestamp: timestamp, sequence: sequence, payload: segment.payload})
+		}
+		if len(stream.outOfOrder) > maxOutOfOrder {
+			c.skipGap(connection, stream)
+		}
+		return
+	}
+	c.deliver(connection, stream, timestamp, sequence, segment.payload)
+	c.drain(connection, stream, timestamp)
+}
+
+// drain feeds the buffered segments that no longer follow missing data, the
+// ones completed by the packet captured at the timestamp
+func (c *capture) drain(connection *tcpConnection, stream *tcpStream, timestamp time.Time) {
+	for {
+		next := -1
+		for i, pending := range stream.outOfOrder {
+			if int32(pending.sequence-stream.nextSequence) <= 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return
+		}
+		pending := stream.outOfOrder[next]
+		stream.outOfOrder = append(stream.outOfOrder[:next], stream.outOfOrder[next+1:]...)
+		c.deliver(connection, stream, timestamp, pending.sequence, pending.payload)
+	}
+}
+
+// skipGap reports the data missing before the first buffered segment as not
+// captured, then feeds the buffered segments that follow it
+func (c *capture) skipGap(connection *tcpConnection, stream *tcpStream) {
+	first := stream.outOfOrder[0]
+	for _, pending := range stream.outOfOrder[1:] {
+		if int32(pending.sequence-first.sequence) < 0 {
+			first = pending
+		}
+	}
+	gap := first.sequence - stream.nextSequence
+	c.dumper.write(c.prefix(connection, first.timestamp), stream.direction, bolt.TrafficEntry{
+		Offset:    stream.fed + stream.skipped,
+		Message:   fmt.Sprintf("<gap: %d bytes were not captured, what follows may not be decoded>", gap),
+		Malformed: true,
+	})
+	stream.skipped += int(gap)
+	stream.nextSequence = first.sequence
+	c.drain(connection, stream, first.timestamp)
+}
+
+// deliver feeds the payload starting at the sequence, which does not follow
+// missing data, skipping the bytes already fed
+func (c *capture) deliver(connection *tcpConnection, stream *tcpStream, timestamp time.Time, sequence uint32, payload []byte) {
+	end := sequence + uint32(len(payload))
+	if overlap := int32(stream.nextSequence - sequence); overlap > 0 {
+		// retransmitted data is only fed once
+		if int(overlap) >= len(payload) {
+			return
+		}
+		payload = payload[overlap:]
+	}
+	stream.nextSequence = end
+	if len(payload) == 0 {
+		return
+	}
+	if stream.traffic == nil {
+		// the server answers the handshake only if the capture includes it
+		stream.handshake = connection.client.handshake
+		if stream.direction == ClientToServer {
+			stream.handshake = startsWithHandshake(payload, ClientToServer)
+		}
+		stream.traffic = bolt.NewTrafficStream(stream.direction, stream.handshake)
+	}
+	serverManifest := connection.server.traffic != nil && connection.server.traffic.Manifest()
+	prefix := c.prefix(connection, timestamp)
+	for _, entry := range stream.traffic.Feed(payload, serverManifest) {
+		entry.Offset += stream.skipped
+		c.dumper.write(prefix, stream.direction, entry)
+	}
+	stream.fed += len(payload)
+}
+
+func (c *capture) prefix(connection *tcpConnection, timestamp time.Time) string {
+	return fmt.Sprintf("#%d %s ", connection.id, timestamp.UTC().Format("15:04:05.000000"))
+}
+
+// finish feeds the segments still waiting for missing data, and reports the
+// streams that end with an incomplete message
+func (c *capture) finish() {
+	for _, connection := range c.ordered {
+		prefix := fmt.Sprintf("#%d ", connection.id)
+		for _, stream := range []*tcpStream{connection.client, connection.server} {
+			for len(stream.outOfOrder) > 0 {
+				c.skipGap(connection, stream)
+			}
+			if stream.traffic != nil {
+				c.dumper.checkComplete(prefix, stream.direction, stream.traffic, stream.fed+stream.skipped)
+			}
+		}
+	}
+}
+
+// decode extracts the TCP segment of the packet, if any
+func (c *capture) decode(packet []byte) (tcpSegment, bool) {
+	var network []byte
+	switch c.linkType {
+	case linkNull:
+		if len(packet) < 4 {
+			return tcpSegment{}, false
+		}
+		network = packet[4:]
+	case linkEthernet:
+		if len(packet) < 14 {
+			return tcpSegment{}, false
+		}
+		offset := 14
+		// 802.1Q VLAN tags precede the actual ether type
+		for offset+4 <= len(packet) && binary.BigEndian.Uint16(packet[offset-2:]) == 0x8100 {
+			offset += 4
+		}
+		network = packet[offset:]
+	case linkRaw, linkIPv4, linkIPv6:
+		network = packet
+	case linkLinuxSll:
+		if len(packet) < 16 {
+			return tcpSegment{}, false
+		}
+		network = packet[16:]
+	case linkLinuxSll2:
+		if len(packet) < 20 {
+			return tcpSegment{}, false
+		}
+		network = packet[20:]
+	default:
+		return tcpSegment{}, false
+	}
+	return decodeIP(network)
+}
+
+func decodeIP(packet []byte) (tcpSegment, bool) {
+	if len(packet) == 0 {
+		return tcpSegment{}, false
+	}
+	var segment tcpSegment
+	var transport []byte
+	switch packet[0] >> 4 {
+	case 4:
+		headerLength := int(packet[0]&0x0F) * 4
+		if len(packet) < 20 || headerLength < 20 || len(packet) < headerLength || packet[9] != 6 {
+			return tcpSegment{}, false
+		}
+		// the frame may be padded past the IP packet
+		totalLength := int(binary.BigEndian.Uint16(packet[2:]))
+		if totalLength < headerLength || totalLength > len(packet) {
+			totalLength = len(packet)
+		}
+		segment.source = net.IP(packet[12:16]).String()
+		segment.destination = net.IP(packet[16:20]).String()
+		transport = packet[headerLength:totalLength]
+	case 6:
+		// extension headers are not supported
+		if len(packet) < 40 || packet[6] != 6 {
+			return tcpSegment{}, false
+		}
+		end := 40 + int(binary.BigEndian.Uint16(packet[4:]))
+		if end > len(packet) {
+			end = len(packet)
+		}
+		segment.source = net.IP(packet[8:24]).String()
+		segment.destination = net.IP(packet[24:40]).String()
+		transport = packet[40:end]
+	default:
+		return tcpSegment{}, false
+	}
+	if len(transport) < 20 {
+		return tcpSegment{}, false
+	}
+	dataOffset := int(transport[12]>>4) * 4
+	if dataOffset < 20 || dataOffset > len(transport) {
+		return tcpSegment{}, false
+	}
+	segment.sourcePort = int(binary.BigEndian.Uint16(transport))
+	segment.port = int(binary.BigEndian.Uint16(transport[2:]))
+	segment.sequence = binary.BigEndian.Uint32(transport[4:])
+	segment.syn = transport[13]&0x02 != 0
+	segment.payload = transport[dataOffset:]
+	return segment, true
+}
@@ -0,0 +1,402 @@
+// Package boltproxy forwards Bolt connections to a server, logging the
+// messages exchanged and injecting faults such as latency, dropped
+// connections and failures
+package boltproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/internal/bolt"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDialTimeout bounds the time to connect to the server when the proxy
+// does not set its own
+const DefaultDialTimeout = 5 * time.Second
+
+// maxManifestVersions bounds the versions of the manifests the proxy relays
+const maxManifestVersions = 256
+
+var manifestV1 = []byte{0, 0, 1, 0xFF}
+
+// Proxy forwards the connections it accepts to the target server. It writes
+// a line per handshake step and message to the log, such as
+// #1 12:00:00.000 C  RUN "RETURN 42" {} {mode: "r"}
+// after the connection number, the time and the direction, which is followed
+// by * for the requests the proxy does not forward and the responses it
+// substitutes.
+type Proxy struct {
+	// Target is the address of the server, such as localhost:7687
+	Target string
+	// DialTimeout bounds the time to connect to the server, the client
+	// connection being closed once it elapses, DefaultDialTimeout when zero
+	DialTimeout time.Duration
+	// Log receives the traffic, nothing is logged when nil
+	Log io.Writer
+	// Latency delays every message, in both directions
+	Latency time.Duration
+	// DropAfter closes the connections instead of forwarding the request
+	// following the first DropAfter ones, connections are not dropped when
+	// zero
+	DropAfter int
+	// DropProbability is the probability of closing the connection instead
+	// of forwarding a request
+	DropProbability float64
+	// Failures lists the rules substituting failures for the responses to
+	// some requests, the first matching rule applies
+	Failures []FailureRule
+
+	logMutex    sync.Mutex
+	randomMutex sync.Mutex
+	random      *rand.Rand
+}
+
+// FailureRule substitutes a FAILURE for the response to the requests it
+// matches, which are not forwarded to the server. The proxy then answers the
+// requests of the connection with IGNORED until the client sends RESET, like
+// a server would.
+type FailureRule struct {
+	// Message names the requests to match, such as RUN
+	Message string
+	// Contains, when set, must be part of the request as logged, such as a
+	// part of the query of RUN
+	Contains string
+	// Code is the code of the failure, such as
+	// Neo.TransientError.General.DatabaseUnavailable
+	Code string
+	// Probability of substituting the failure for a matching request, the
+	// failure is always substituted when zero
+	Probability float64
+}
+
+func (f FailureRule) matches(name, description string) bool {
+	return f.Message == name && strings.Contains(description, f.Contains)
+}
+
+// Serve forwards the connections the listener accepts, numbered from 1. It
+// returns once the listener is closed and the connections it accepted are
+// over.
+func (p *Proxy) Serve(listener net.Listener) error {
+	var handlers sync.WaitGroup
+	defer handlers.Wait()
+	for id := 1; ; id++ {
+		client, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		handlers.Add(1)
+		go func(id int) {
+			defer handlers.Done()
+			p.handle(id, client)
+		}(id)
+	}
+}
+
+func (p *Proxy) handle(id int, client net.Conn) {
+	defer client.Close()
+	p.logf(id, "%s -> %s", client.RemoteAddr(), p.Target)
+	dialTimeout := p.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = DefaultDialTimeout
+	}
+	server, err := net.DialTimeout("tcp", p.Target, dialTimeout)
+	if err != nil {
+		p.logf(id, "could not connect to the server: %v", err)
+		return
+	}
+	defer server.Close()
+	c := &connection{
+		proxy:         p,
+		id:            id,
+		client:        client,
+		server:        server,
+		clientChunker: &bolt.Chunker{Connection: client},
+		serverChunker: &bolt.Chunker{Connection: server},
+		requests:      bolt.NewTrafficStream(bolt.ClientToServer, true),
+		responses:     bolt.NewTrafficStream(bolt.ServerToClient, true),
+	}
+	if err := c.relayHandshake(); err != nil {
+		p.logf(id, "closed during the handshake: %v", err)
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.forwardResponses()
+		// unblocks forwardRequests
+		_ = client.Close()
+	}()
+	c.forwardRequests()
+	_ = server.Close()
+	<-done
+	p.logf(id, "closed")
+}
+
+func (p *Proxy) logf(id int, format string, args ...interface{}) {
+	if p.Log == nil {
+		return
+	}
+	p.logMutex.Lock()
+	defer p.logMutex.Unlock()
+	fmt.Fprintf(p.Log, "#%d %s %s\n", id, time.Now().Format("15:04:05.000"), fmt.Sprintf(format, args...))
+}
+
+// chance tells whether an event of the probability happens
+func (p *Proxy) chance(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+	p.randomMutex.Lock()
+	defer p.randomMutex.Unlock()
+	if p.random == nil {
+		p.random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return p.random.Float64() < probability
+}
+
+// failureFor returns the FAILURE to substitute for the response to the
+// request, if any
+func (p *Proxy) failureFor(name, description string) *packstream.Structure {
+	for _, rule := range p.Failures {
+		if !rule.matches(name, description) {
+			continue
+		}
+		if rule.Probability > 0 && !p.chance(rule.Probability) {
+			return nil
+		}
+		message := packstream.String(fmt.Sprintf("failure substituted by the proxy for %s", name))
+		code := packstream.String(rule.Code)
+		metadata := &packstream.OrderedDictionary{}
+		metadata.Set("code", &code)
+		metadata.Set("message", &message)
+		return &packstream.Structure{TagByte: 0x7F, Fields: []packstream.Value{metadata}}
+	}
+	return nil
+}
+
+type connection struct {
+	proxy         *Proxy
+	id            int
+	client        net.Conn
+	server        net.Conn
+	clientChunker *bolt.Chunker
+	serverChunker *bolt.Chunker
+	// requests and responses describe the handshake steps
+	requests  *bolt.TrafficStream
+	responses *bolt.TrafficStream
+
+	// mutex guards what follows and the writes to the client, so that the
+	// responses the proxy substitutes are written in the order of their
+	// requests
+	mutex sync.Mutex
+	// pending lists the requests waiting for their summary response, with
+	// nil for the forwarded ones and the response to substitute otherwise
+	pending []*packstream.Structure
+	// failed tells whether the proxy substituted a failure, until the client
+	// sends RESET
+	failed bool
+}
+
+// relayHandshake forwards the handshake steps, reading exactly the bytes
+// each of them is made of
+func (c *connection) relayHandshake() error {
+	proposal := make([]byte, 20)
+	if _, err := io.ReadFull(c.client, proposal); err != nil {
+		return err
+	}
+	if err := c.relayHandshakeStep(c.requests, c.server, proposal); err != nil {
+		return err
+	}
+	answer := make([]byte, 4)
+	if _, err := io.ReadFull(c.server, answer); err != nil {
+		return err
+	}
+	manifest := bytes.Equal(answer, manifestV1)
+	if manifest {
+		count, err := readUvarint(c.server, &answer)
+		if err != nil {
+			return err
+		}
+		if count > maxManifestVersions {
+			return fmt.Errorf("manifest of %d versions", count)
+		}
+		versions := make([]byte, 4*count)
+		if _, err := io.ReadFull(c.server, versions); err != nil {
+			return err
+		}
+		answer = append(answer, versions...)
+		if _, err := readUvarint(c.server, &answer); err != nil {
+			return err
+		}
+	}
+	if err := c.relayHandshakeStep(c.responses, c.client, answer); err != nil {
+		return err
+	}
+	if !manifest {
+		return nil
+	}
+	choice := make([]byte, 4)
+	if _, err := io.ReadFull(c.client, choice); err != nil {
+		return err
+	}
+	if _, err := readUvarint(c.client, &choice); err != nil {
+		return err
+	}
+	return c.relayHandshakeStep(c.requests, c.server, choice)
+}
+
+func (c *connection) relayHandshakeStep(stream *bolt.TrafficStream, destination net.Conn, data []byte) error {
+	for _, entry := range stream.Feed(data, c.responses.Manifest()) {
+		c.proxy.logf(c.id, "%s  %s", entry.Direction, entry.Message)
+	}
+	_, err := destination.Write(data)
+	return err
+}
+
+// readUvarint reads a variable-length integer one byte at a time, appending
+// its bytes to raw
+func readUvarint(reader io.Reader, raw *[]byte) (uint64, error) {
+	start := len(*raw)
+	next := make([]byte, 1)
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		if _, err := io.ReadFull(reader, next); err != nil {
+			return 0, err
+		}
+		*raw = append(*raw, next[0])
+		if next[0] < 0x80 {
+			value, _ := binary.Uvarint((*raw)[start:])
+			return value, nil
+		}
+	}
+	return 0, fmt.Errorf("variable-length integer overflows 64 bits")
+}
+
+// forwardRequests forwards the client messages until either side closes the
+// connection, or the proxy drops it
+func (c *connection) forwardRequests() {
+	for count := 1; ; count++ {
+		raw, err := c.clientChunker.ReadUnchunked()
+		if err != nil {
+			return
+		}
+		description := bolt.DescribeMessage(raw)
+		if c.proxy.DropAfter > 0 && count > c.proxy.DropAfter || c.proxy.chance(c.proxy.DropProbability) {
+			c.proxy.logf(c.id, "%s* %s", bolt.ClientToServer, description)
+			c.proxy.logf(c.id, "dropped")
+			return
+		}
+		time.Sleep(c.proxy.Latency)
+		name := messageName(raw)
+		if response := c.substitute(name, description); response != nil {
+			c.proxy.logf(c.id, "%s* %s", bolt.ClientToServer, description)
+			if err := c.queue(response); err != nil {
+				return
+			}
+			continue
+		}
+		c.proxy.logf(c.id, "%s  %s", bolt.ClientToServer, description)
+		if name != "GOODBYE" {
+			_ = c.queue(nil)
+		}
+		if err := c.serverChunker.WriteChunked(raw); err != nil {
+			return
+		}
+	}
+}
+
+// substitute returns the response the proxy substitutes for the one of the
+// server, if any
+func (c *connection) substitute(name, description string) *packstream.Structure {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if name == "RESET" {
+		c.failed = false
+		return nil
+	}
+	if name == "GOODBYE" {
+		return nil
+	}
+	if c.failed {
+		return &packstream.Structure{TagByte: 0x7E}
+	}
+	failure := c.proxy.failureFor(name, description)
+	c.failed = failure != nil
+	return failure
+}
+
+// queue adds a request waiting for its response, which is substituted
+// unless nil
+func (c *connection) queue(response *packstream.Structure) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pending = append(c.pending, response)
+	return c.writeSubstitutedResponses()
+}
+
+// forwardResponses forwards the server messages until either side closes the
+// connection
+func (c *connection) forwardResponses() {
+	for {
+		raw, err := c.serverChunker.ReadUnchunked()
+		if err != nil {
+			return
+		}
+		time.Sleep(c.proxy.Latency)
+		if err := c.forwardResponse(raw); err != nil {
+			return
+		}
+	}
+}
+
+func (c *connection) forwardResponse(raw []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.proxy.logf(c.id, "%s  %s", bolt.ServerToClient, bolt.DescribeMessage(raw))
+	if err := c.clientChunker.WriteChunked(raw); err != nil {
+		return err
+	}
+	switch messageName(raw) {
+	case "SUCCESS", "FAILURE", "IGNORED":
+		if len(c.pending) > 0 {
+			c.pending = c.pending[1:]
+		}
+	}
+	return c.writeSubstitutedResponses()
+}
+
+// writeSubstitutedResponses writes the responses of the first pending
+// requests, up to the first forwarded one
+func (c *connection) writeSubstitutedResponses() error {
+	for len(c.pending) > 0 && c.pending[0] != nil {
+		response := c.pending[0]
+		c.pending = c.pending[1:]
+		c.proxy.logf(c.id, "%s* %s", bolt.ServerToClient, bolt.FormatMessage(response))
+		if err := c.clientChunker.WriteChunked(response.Pack()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func messageName(raw []byte) string {
+	value, _, err := packstream.UnpackValue(raw)
+	if err != nil {
+		return ""
+	}
+	message, ok := value.(*packstream.Structure)
+	if !ok {
+		return ""
+	}
+	return bolt.MessageName(message)
+}
@@ -0,0 +1,165 @@
+package boltproxy_test
+
+import (
+	"errors"
+	"github.com/fbiville/go-usain-go/pkg/boltproxy"
+	"github.com/fbiville/go-usain-go/pkg/bolttest"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	. "github.com/onsi/gomega"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProxy(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("logs the traffic in both directions", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.ExpectRun("RETURN 1", bolttest.Success(map[string]interface{}{"fields": []string{"x"}})),
+			bolttest.Expect("PULL", bolttest.Record(1), bolttest.Success(nil)),
+		)
+		log := &strings.Builder{}
+		proxy := &boltproxy.Proxy{Target: server.Address(), Log: log}
+		uri, wait := startProxy(t, proxy)
+
+		Expect(runQuery(uri, "RETURN 1")).To(Succeed())
+		wait()
+
+		Expect(log.String()).To(ContainSubstring("C  HANDSHAKE 6060B017"))
+		Expect(log.String()).To(ContainSubstring("S  VERSION 4.4\n"))
+		Expect(log.String()).To(ContainSubstring(`credentials: "******"`))
+		Expect(log.String()).NotTo(ContainSubstring("s3cr3t"))
+		Expect(log.String()).To(ContainSubstring(`C  RUN "RETURN 1"`))
+		Expect(log.String()).To(ContainSubstring("S  RECORD [1]\n"))
+		Expect(log.String()).To(ContainSubstring("C  GOODBYE\n"))
+		Expect(log.String()).To(HaveSuffix(" closed\n"))
+	})
+
+	t.Run("substitutes failures for the responses to matching requests", func(t *testing.T) {
+		server := bolttest.NewSequenceServer(t,
+			[]bolttest.Step{
+				bolttest.ExpectHello("Neo4j/4.4.0"),
+				bolttest.Expect("RESET", bolttest.Success(nil)),
+			},
+			[]bolttest.Step{
+				bolttest.ExpectHello("Neo4j/4.4.0"),
+				bolttest.ExpectRun("RETURN 1", bolttest.Success(map[string]interface{}{"fields": []string{"x"}})),
+				bolttest.Expect("PULL", bolttest.Record(1), bolttest.Success(nil)),
+			},
+		)
+		log := &strings.Builder{}
+		proxy := &boltproxy.Proxy{
+			Target: server.Address(),
+			Log:    log,
+			Failures: []boltproxy.FailureRule{
+				{Message: "RUN", Contains: "CREATE", Code: "Neo.TransientError.Transaction.DeadlockDetected"},
+			},
+		}
+		uri, wait := startProxy(t, proxy)
+
+		err := runQuery(uri, "CREATE ()")
+		Expect(runQuery(uri, "RETURN 1")).To(Succeed())
+		wait()
+
+		var serverError *neo4j.Neo4jError
+		Expect(errors.As(err, &serverError)).To(BeTrue())
+		Expect(serverError.Code).To(Equal("Neo.TransientError.Transaction.DeadlockDetected"))
+		Expect(log.String()).To(ContainSubstring(`C* RUN "CREATE ()"`))
+		Expect(log.String()).To(ContainSubstring(`S* FAILURE {code: "Neo.TransientError.Transaction.DeadlockDetected"`))
+		Expect(log.String()).To(ContainSubstring("C* PULL"))
+		Expect(log.String()).To(ContainSubstring("S* IGNORED\n"))
+		Expect(server.Received()).To(Equal([]string{"HELLO", "RESET", "GOODBYE", "HELLO", "RUN", "PULL"}))
+	})
+
+	t.Run("drops connections", func(t *testing.T) {
+		server := bolttest.NewServer(t, bolttest.ExpectHello("Neo4j/4.4.0"))
+		log := &strings.Builder{}
+		proxy := &boltproxy.Proxy{Target: server.Address(), Log: log, DropAfter: 1}
+		uri, wait := startProxy(t, proxy)
+
+		err := runQuery(uri, "RETURN 1")
+		wait()
+
+		Expect(err).To(HaveOccurred())
+		Expect(log.String()).To(ContainSubstring(`C* RUN "RETURN 1"`))
+		Expect(log.String()).To(ContainSubstring(" dropped\n"))
+		Expect(server.Received()).To(Equal([]string{"HELLO"}))
+	})
+
+	t.Run("gives up connecting to the server after the dial timeout", func(t *testing.T) {
+		server := bolttest.NewServer(t)
+		log := &strings.Builder{}
+		// the deadline of the dial is over before it starts
+		proxy := &boltproxy.Proxy{Target: server.Address(), Log: log, DialTimeout: time.Nanosecond}
+		uri, wait := startProxy(t, proxy)
+
+		err := runQuery(uri, "RETURN 1")
+		wait()
+
+		Expect(err).To(HaveOccurred())
+		Expect(log.String()).To(ContainSubstring("could not connect to the server: "))
+		Expect(log.String()).To(ContainSubstring("i/o timeout"))
+	})
+
+	t.Run("delays messages", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.ExpectRun("RETURN 1", bolttest.Success(map[string]interface{}{"fields": []string{"x"}})),
+			bolttest.Expect("PULL", bolttest.Record(1), bolttest.Success(nil)),
+		)
+		proxy := &boltproxy.Proxy{Target: server.Address(), Latency: 20 * time.Millisecond}
+		uri, wait := startProxy(t, proxy)
+		start := time.Now()
+
+		Expect(runQuery(uri, "RETURN 1")).To(Succeed())
+
+		// HELLO and its response, then RUN and its response
+		Expect(time.Since(start)).To(BeNumerically(">=", 80*time.Millisecond))
+		wait()
+	})
+}
+
+// startProxy serves the proxy on a local port, the function it returns closes
+// the listener and waits for the connections to be over
+func startProxy(t *testing.T, proxy *boltproxy.Proxy) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	served := make(chan error, 1)
+	go func() {
+		served <- proxy.Serve(listener)
+	}()
+	var once sync.Once
+	wait := func() {
+		once.Do(func() {
+			_ = listener.Close()
+			Expect(<-served).To(Succeed())
+		})
+	}
+	t.Cleanup(wait)
+	return "bolt://" + listener.Addr().String(), wait
+}
+
+// runQuery runs the query against the URI with a driver of its own, which
+// is closed before returning
+func runQuery(uri string, query string) error {
+	driver, err := neo4j.NewDriver(uri, "neo4j", "s3cr3t")
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+	session, err := driver.NewSession(neo4j.SessionConfig{})
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	result, err := session.Run(query)
+	if err != nil {
+		return err
+	}
+	_, err = result.Consume()
+	return err
+}
@@ -0,0 +1,96 @@
+package bolttest
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/internal/bolt"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	"io"
+	"testing"
+)
+
+// NewReplayServer starts a server serving the server side of recorded
+// traffic: the n-th connection replays the n-th recorded connection,
+// expecting the recorded client messages by name and answering them with
+// the recorded server messages. The recorded version is negotiated without
+// manifest.
+func NewReplayServer(t testing.TB, recording io.Reader) *Server {
+	t.Helper()
+	entries, err := bolt.ReadTraffic(recording)
+	if err != nil {
+		t.Fatalf("could not read recording: %v", err)
+	}
+	scripts, err := replayScripts(entries)
+	if err != nil {
+		t.Fatalf("could not replay recording: %v", err)
+	}
+	return startServer(t, scripts, false)
+}
+
+func replayScripts(entries []bolt.TrafficEntry) ([]connectionScript, error) {
+	var result []connectionScript
+	indices := make(map[int]int)
+	for _, entry := range entries {
+		index, found := indices[entry.Connection]
+		if !found {
+			index = len(result)
+			indices[entry.Connection] = index
+			result = append(result, connectionScript{})
+		}
+		script := &result[index]
+		raw, err := entry.RawBytes()
+		if err != nil {
+			return nil, fmt.Errorf("invalid bytes of %s from connection %d: %w", entry.Message, entry.Connection, err)
+		}
+		if entry.Kind == bolt.HandshakeEntry {
+			if version, ok := negotiatedVersion(entry.Direction, raw); ok {
+				script.version = version
+			}
+			continue
+		}
+		message, err := unpackMessage(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s from connection %d: %w", entry.Message, entry.Connection, err)
+		}
+		if entry.Direction == bolt.ClientToServer {
+			script.steps = append(script.steps, Expect(bolt.MessageName(message)))
+			continue
+		}
+		if len(script.steps) == 0 {
+			return nil, fmt.Errorf("connection %d: server sent %s before any client message", entry.Connection, entry.Message)
+		}
+		step := &script.steps[len(script.steps)-1]
+		step.responses = append(step.responses, Response{structure: message})
+	}
+	for i, script := range result {
+		if script.version == nil {
+			return nil, fmt.Errorf("connection number %d has no recorded handshake", i+1)
+		}
+	}
+	return result, nil
+}
+
+// negotiatedVersion extracts the version from the server response, or from
+// the client choice when the server answered with a manifest
+func negotiatedVersion(direction string, raw []byte) ([]byte, bool) {
+	manifest := []byte{0, 0, 1, 0xFF}
+	switch {
+	case direction == bolt.ServerToClient && len(raw) == 4 && !bytes.Equal(raw, manifest):
+		return raw, true
+	case direction == bolt.ClientToServer && len(raw) >= 5 && len(raw) < 20:
+		return raw[:4], true
+	}
+	return nil, false
+}
+
+func unpackMessage(raw []byte) (*packstream.Structure, error) {
+	value, _, err := packstream.UnpackValue(raw)
+	if err != nil {
+		return nil, err
+	}
+	message, ok := value.(*packstream.Structure)
+	if !ok {
+		return nil, fmt.Errorf("expected a message, got %s", value)
+	}
+	return message, nil
+}
@@ -0,0 +1,134 @@
+package bolttest
+
+import (
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+)
+
+// Step expects a client message and answers it with canned responses
+type Step struct {
+	message   string
+	matchers  []func(*packstream.Structure) error
+	responses []Response
+}
+
+// Expect expects the client message of the given name, such as "RUN", and
+// answers it with the responses
+func Expect(message string, responses ...Response) Step {
+	return Step{message: message, responses: responses}
+}
+
+// ExpectHello expects HELLO and answers it with the server agent
+func ExpectHello(agent string) Step {
+	return Expect("HELLO", Success(map[string]interface{}{"server": agent, "connection_id": "bolt-0"}))
+}
+
+// ExpectRun expects RUN with the given query and answers it with the
+// responses
+func ExpectRun(query string, responses ...Response) Step {
+	return Expect("RUN", responses...).WithField(0, query)
+}
+
+// WithField additionally expects the field of the message to equal the value,
+// compared once converted to PackStream regardless of the order of
+// dictionary entries
+func (s Step) WithField(index int, value interface{}) Step {
+	expected, err := packstream.ValueOf(value)
+	s.matchers = append(append([]func(*packstream.Structure) error(nil), s.matchers...), func(message *packstream.Structure) error {
+		if err != nil {
+			return fmt.Errorf("invalid expected field %d: %w", index, err)
+		}
+		if index >= len(message.Fields) {
+			return fmt.Errorf("expected %s to have a field number %d", s.message, index+1)
+		}
+		if actual := message.Fields[index]; !equal(actual, expected) {
+			return fmt.Errorf("expected field number %d of %s to be %s, got %s", index+1, s.message, expected, actual)
+		}
+		return nil
+	})
+	return s
+}
+
+func (s Step) check(name string, message *packstream.Structure) error {
+	if name != s.message {
+		return fmt.Errorf("expected %s, got %s", s.message, name)
+	}
+	for _, matcher := range s.matchers {
+		if err := matcher(message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Response is a canned server message
+type Response struct {
+	structure *packstream.Structure
+	err       error
+}
+
+// Success responds with SUCCESS and the metadata
+func Success(metadata map[string]interface{}) Response {
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	return response(0x70, metadata)
+}
+
+// Record responds with RECORD and the values
+func Record(values ...interface{}) Response {
+	if values == nil {
+		values = []interface{}{}
+	}
+	return response(0x71, values)
+}
+
+// Failure responds with FAILURE and the error code and message
+func Failure(code, message string) Response {
+	return response(0x7F, map[string]interface{}{"code": code, "message": message})
+}
+
+// Ignored responds with IGNORED
+func Ignored() Response {
+	return Response{structure: &packstream.Structure{TagByte: 0x7E}}
+}
+
+func response(tag byte, fields ...interface{}) Response {
+	result := &packstream.Structure{TagByte: tag}
+	for _, field := range fields {
+		value, err := packstream.ValueOf(field)
+		if err != nil {
+			return Response{err: err}
+		}
+		result.Fields = append(result.Fields, value)
+	}
+	return Response{structure: result}
+}
+
+func equal(actual, expected packstream.Value) bool {
+	switch expectedValue := expected.(type) {
+	case *packstream.OrderedDictionary:
+		actualValue, ok := actual.(*packstream.OrderedDictionary)
+		if !ok || actualValue.Len() != expectedValue.Len() {
+			return false
+		}
+		for _, entry := range expectedValue.Entries {
+			if value := actualValue.Get(entry.Key); value == nil || !equal(value, entry.Value) {
+				return false
+			}
+		}
+		return true
+	case *packstream.List:
+		actualValue, ok := actual.(*packstream.List)
+		if !ok || len(*actualValue) != len(*expectedValue) {
+			return false
+		}
+		for i := range *expectedValue {
+			if !equal((*actualValue)[i], (*expectedValue)[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return actual.String() == expected.String()
+}
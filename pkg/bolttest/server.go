@@ -0,0 +1,239 @@
+// Package bolttest provides an in-process Bolt server following a script, so
+// that driver behaviour can be tested without a database
+package bolttest
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/internal/bolt"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+var handshakeMagic = []byte{0x60, 0x60, 0xB0, 0x17}
+
+// Server replays its script on every connection: it expects the client
+// messages of the script in order, answers each of them with the canned
+// responses, and fails the test on any other message. GOODBYE ends the
+// connection wherever it occurs.
+type Server struct {
+	t        testing.TB
+	listener net.Listener
+	scripts  []connectionScript
+	// repeat tells whether the last script is replayed on any further
+	// connection, which fails the test otherwise
+	repeat bool
+
+	mutex       sync.Mutex
+	connections map[net.Conn]struct{}
+	accepted    int
+	received    []string
+	// completed tells whether a connection went through its whole script,
+	// pending is the message the furthest connection waits for otherwise
+	completed bool
+	pending   string
+	progress  int
+	handlers  sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// connectionScript is what the server negotiates and expects on a
+// connection
+type connectionScript struct {
+	version []byte
+	steps   []Step
+}
+
+// NewServer starts a server negotiating Bolt 4.4, which is closed once the
+// test completes
+func NewServer(t testing.TB, script ...Step) *Server {
+	t.Helper()
+	return NewServerWithVersion(t, 4, 4, script...)
+}
+
+// NewServerWithVersion starts a server negotiating the given protocol
+// version, which is closed once the test completes
+func NewServerWithVersion(t testing.TB, major, minor byte, script ...Step) *Server {
+	t.Helper()
+	return startServer(t, []connectionScript{{version: []byte{0, 0, minor, major}, steps: script}}, true)
+}
+
+// NewSequenceServer starts a server negotiating Bolt 4.4, which follows the
+// n-th script on the n-th connection and fails the test on any further
+// connection
+func NewSequenceServer(t testing.TB, scripts ...[]Step) *Server {
+	t.Helper()
+	connectionScripts := make([]connectionScript, len(scripts))
+	for i, script := range scripts {
+		connectionScripts[i] = connectionScript{version: []byte{0, 0, 4, 4}, steps: script}
+	}
+	return startServer(t, connectionScripts, false)
+}
+
+func startServer(t testing.TB, scripts []connectionScript, repeat bool) *Server {
+	t.Helper()
+	for _, script := range scripts {
+		for _, step := range script.steps {
+			for _, response := range step.responses {
+				if response.err != nil {
+					t.Fatalf("invalid response to %s: %v", step.message, response.err)
+				}
+			}
+		}
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not start stub server: %v", err)
+	}
+	server := &Server{
+		t:           t,
+		listener:    listener,
+		scripts:     scripts,
+		repeat:      repeat,
+		connections: make(map[net.Conn]struct{}),
+	}
+	server.handlers.Add(1)
+	go server.serve()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Address returns the host and port the server listens to
+func (s *Server) Address() string {
+	return s.listener.Addr().String()
+}
+
+// URI returns the bolt:// URI of the server
+func (s *Server) URI() string {
+	return fmt.Sprintf("bolt://%s", s.Address())
+}
+
+// Received returns the names of the messages received so far, over all
+// connections
+func (s *Server) Received() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.received...)
+}
+
+// Close stops the server and fails the test unless a connection went through
+// the whole script
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		_ = s.listener.Close()
+		s.mutex.Lock()
+		for connection := range s.connections {
+			_ = connection.Close()
+		}
+		s.mutex.Unlock()
+		s.handlers.Wait()
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if !s.completed && s.pending != "" {
+			s.t.Errorf("stub server script was not completed, still expecting %s", s.pending)
+		}
+	})
+}
+
+func (s *Server) serve() {
+	defer s.handlers.Done()
+	for {
+		connection, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.connections[connection] = struct{}{}
+		s.accepted++
+		index := s.accepted - 1
+		s.mutex.Unlock()
+		s.handlers.Add(1)
+		go s.handle(connection, index)
+	}
+}
+
+func (s *Server) handle(connection net.Conn, index int) {
+	defer s.handlers.Done()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.connections, connection)
+		s.mutex.Unlock()
+		_ = connection.Close()
+	}()
+	handshake := make([]byte, 20)
+	if _, err := io.ReadFull(connection, handshake); err != nil {
+		return
+	}
+	if !bytes.Equal(handshake[:4], handshakeMagic) {
+		s.t.Errorf("expected handshake to start with %X, got %X", handshakeMagic, handshake[:4])
+		return
+	}
+	if index >= len(s.scripts) && !s.repeat {
+		s.t.Errorf("unexpected connection number %d, expected at most %d", index+1, len(s.scripts))
+		return
+	}
+	script := s.scripts[len(s.scripts)-1]
+	if index < len(s.scripts) {
+		script = s.scripts[index]
+	}
+	if _, err := connection.Write(script.version); err != nil {
+		return
+	}
+	chunker := &bolt.Chunker{Connection: connection}
+	for position := 0; ; position++ {
+		s.reach(script.steps, position)
+		message, err := readMessage(chunker)
+		if err != nil {
+			return
+		}
+		name := bolt.MessageName(message)
+		s.mutex.Lock()
+		s.received = append(s.received, name)
+		s.mutex.Unlock()
+		if position == len(script.steps) {
+			if name != "GOODBYE" {
+				s.t.Errorf("expected no more messages after the end of the script, got %s", name)
+			}
+			return
+		}
+		step := script.steps[position]
+		if name == "GOODBYE" && step.message != "GOODBYE" {
+			return
+		}
+		if err := step.check(name, message); err != nil {
+			s.t.Errorf("unexpected message number %d: %v", position+1, err)
+			return
+		}
+		rawResponses := make([][]byte, len(step.responses))
+		for i, response := range step.responses {
+			rawResponses[i] = response.structure.Pack()
+		}
+		if len(rawResponses) > 0 {
+			if err := chunker.WriteChunked(rawResponses...); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) reach(steps []Step, position int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if position == len(steps) {
+		s.completed = true
+	} else if s.pending == "" || position > s.progress {
+		s.progress = position
+		s.pending = steps[position].message
+	}
+}
+
+func readMessage(chunker *bolt.Chunker) (*packstream.Structure, error) {
+	rawMessage, err := chunker.ReadUnchunked()
+	if err != nil {
+		return nil, err
+	}
+	return unpackMessage(rawMessage)
+}
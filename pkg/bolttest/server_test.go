@@ -0,0 +1,167 @@
+package bolttest_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/bolttest"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	. "github.com/onsi/gomega"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("replays the script", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.ExpectRun("RETURN 42", bolttest.Success(map[string]interface{}{"fields": []string{"42"}})),
+			bolttest.Expect("PULL", bolttest.Record(42), bolttest.Success(nil)),
+		)
+		driver, err := neo4j.NewDriver(server.URI(), "neo4j", "s3cr3t")
+		Expect(err).NotTo(HaveOccurred())
+
+		result, err := driver.Run("RETURN 42", neo4j.ReadAccessMode)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(&packstream.List{packstream.Integer(42)}))
+		Expect(driver.Close()).To(Succeed())
+		Eventually(server.Received).Should(Equal([]string{"HELLO", "RUN", "PULL", "GOODBYE"}))
+	})
+
+	t.Run("answers with failures", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.Expect("HELLO", bolttest.Failure("Neo.ClientError.Security.Unauthorized", "invalid credentials")),
+		)
+
+		_, err := neo4j.NewDriver(server.URI(), "neo4j", "wrong")
+
+		Expect(err).To(MatchError(ContainSubstring("invalid credentials")))
+	})
+
+	t.Run("fails the test on unexpected messages", func(t *testing.T) {
+		recorder := &failureRecorder{TB: t}
+		server := bolttest.NewServer(recorder,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.ExpectRun("RETURN 1", bolttest.Success(nil)),
+		)
+		driver, err := neo4j.NewDriver(server.URI(), "neo4j", "s3cr3t")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = driver.Run("RETURN 42", neo4j.ReadAccessMode)
+
+		Expect(err).To(HaveOccurred())
+		server.Close()
+		Expect(recorder.failures()).To(ConsistOf(
+			`unexpected message number 2: expected field number 1 of RUN to be "RETURN 1", got "RETURN 42"`,
+			"stub server script was not completed, still expecting RUN",
+		))
+	})
+
+	t.Run("fails the test when the script is not completed", func(t *testing.T) {
+		recorder := &failureRecorder{TB: t}
+		server := bolttest.NewServer(recorder,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.Expect("ROUTE", bolttest.Success(nil)),
+		)
+		driver, err := neo4j.NewDriver(server.URI(), "neo4j", "s3cr3t")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(driver.Close()).To(Succeed())
+
+		server.Close()
+
+		Expect(recorder.failures()).To(Equal([]string{"stub server script was not completed, still expecting ROUTE"}))
+	})
+}
+
+func TestSequenceServer(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("follows a script per connection", func(t *testing.T) {
+		server := bolttest.NewSequenceServer(t,
+			[]bolttest.Step{bolttest.ExpectHello("Neo4j/4.4.0")},
+			[]bolttest.Step{bolttest.Expect("HELLO", bolttest.Failure("Neo.ClientError.Security.Unauthorized", "invalid credentials"))},
+		)
+		driver, err := neo4j.NewDriver(server.URI(), "neo4j", "s3cr3t")
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+
+		err = driver.VerifyConnectivity(context.Background())
+
+		Expect(err).To(MatchError(ContainSubstring("invalid credentials")))
+	})
+}
+
+func TestReplayServer(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("replays recorded traffic", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.ExpectRun("RETURN 42", bolttest.Success(map[string]interface{}{"fields": []string{"42"}})),
+			bolttest.Expect("PULL", bolttest.Record(42), bolttest.Success(nil)),
+		)
+		recording := &bytes.Buffer{}
+		recorder := neo4j.NewTrafficRecorder(recording)
+		recordedDriver, err := neo4j.NewDriver(server.URI(), "neo4j", "s3cr3t", func(config *neo4j.Config) {
+			config.Dialer = recorder.Dialer(config.Dialer)
+		})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = recordedDriver.Run("RETURN 42", neo4j.ReadAccessMode)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recordedDriver.Close()).To(Succeed())
+		Expect(recorder.Err()).NotTo(HaveOccurred())
+		replay := bolttest.NewReplayServer(t, recording)
+		driver, err := neo4j.NewDriver(replay.URI(), "neo4j", "s3cr3t")
+		Expect(err).NotTo(HaveOccurred())
+
+		result, err := driver.Run("RETURN 42", neo4j.ReadAccessMode)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(&packstream.List{packstream.Integer(42)}))
+		Expect(driver.Close()).To(Succeed())
+		Eventually(replay.Received).Should(Equal([]string{"HELLO", "RUN", "PULL", "GOODBYE"}))
+	})
+
+	t.Run("fails the test on connections missing from the recording", func(t *testing.T) {
+		recorder := &failureRecorder{TB: t}
+		recording := strings.NewReader(`{"connection": 1, "direction": "S", "kind": "handshake", "bytes": "00000404"}`)
+		replay := bolttest.NewReplayServer(recorder, recording)
+		first, err := net.Dial("tcp", replay.Address())
+		Expect(err).NotTo(HaveOccurred())
+		defer first.Close()
+		second, err := net.Dial("tcp", replay.Address())
+		Expect(err).NotTo(HaveOccurred())
+		defer second.Close()
+		_, err = second.Write(append([]byte{0x60, 0x60, 0xB0, 0x17}, make([]byte, 16)...))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = first.Write(append([]byte{0x60, 0x60, 0xB0, 0x17}, make([]byte, 16)...))
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(recorder.failures).Should(ContainElement("unexpected connection number 2, expected at most 1"))
+	})
+}
+
+// failureRecorder records failures instead of failing the test
+type failureRecorder struct {
+	testing.TB
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (f *failureRecorder) Errorf(format string, args ...interface{}) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.messages = append(f.messages, fmt.Sprintf(format, args...))
+}
+
+func (f *failureRecorder) failures() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string(nil), f.messages...)
+}
@@ -1,54 +1,172 @@
 package bolt
 
 import (
-	"bytes"
-	"encoding/binary"
+	"bufio"
 	"fmt"
 	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	"io"
 	"math"
 	"net"
+	"sync"
 )
 
+// Chunker writes messages in chunks and reads them back, through buffers
+// created on first use
 type Chunker struct {
 	Connection net.Conn
+	// MaxMessageSize bounds the size of read messages, zero means no limit
+	MaxMessageSize int
+	// Counter, when set, counts the bytes written and read
+	Counter ByteCounter
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+	header  [2]byte
 }
 
-// FIXME: assumes message is writable to a single chunk
+// ByteCounter is told about the bytes a chunker writes and reads, chunk
+// headers included
+type ByteCounter interface {
+	BytesSent(n int)
+	BytesReceived(n int)
+}
+
+// maxPooledBufferSize keeps the buffers of exceptionally large messages out
+// of the pool
+const maxPooledBufferSize = 1 << 20
+
+var messageBuffers = sync.Pool{
+	New: func() interface{} {
+		buffer := make([]byte, 0, 4096)
+		return &buffer
+	},
+}
+
+// WriteChunked writes the messages, each split in as many chunks as needed
+// and followed by an end marker, with a single flush for all of them
 func (c *Chunker) WriteChunked(rawMessages ...[]byte) error {
-	var payload []byte
+	if c.writer == nil {
+		c.writer = bufio.NewWriterSize(c.Connection, math.MaxUint16+4)
+	}
+	sent := 0
 	for _, message := range rawMessages {
-		chunked := chunk(message)
-		payload = append(payload, chunked...)
+		if err := c.writeChunks(message); err != nil {
+			return err
+		}
+		sent += chunkedSize(len(message))
 	}
-	_, err := c.Connection.Write(payload)
-	return err
+	if err := c.writer.Flush(); err != nil {
+		return err
+	}
+	if c.Counter != nil {
+		c.Counter.BytesSent(sent)
+	}
+	return nil
+}
+
+// chunkedSize returns the number of bytes of a chunked message, end marker
+// included
+func chunkedSize(size int) int {
+	chunkCount := (size + math.MaxUint16 - 1) / math.MaxUint16
+	return size + 2*chunkCount + 2
 }
 
-// FIXME: assumes message is readable from a single chunk
+func (c *Chunker) writeChunks(message []byte) error {
+	for len(message) > 0 {
+		size := len(message)
+		if size > math.MaxUint16 {
+			size = math.MaxUint16
+		}
+		if err := c.writeHeader(uint16(size)); err != nil {
+			return err
+		}
+		if _, err := c.writer.Write(message[:size]); err != nil {
+			return err
+		}
+		message = message[size:]
+	}
+	return c.writeHeader(0)
+}
+
+func (c *Chunker) writeHeader(size uint16) error {
+	if err := c.writer.WriteByte(byte(size >> 8)); err != nil {
+		return err
+	}
+	return c.writer.WriteByte(byte(size))
+}
+
+// ReadUnchunked reads the chunks of the next message until the end marker,
+// skipping the empty NOOP chunks servers may send between messages
 func (c *Chunker) ReadUnchunked() ([]byte, error) {
-	chunk := make([]byte, 2)
-	err := binary.Read(c.Connection, packstream.Endianness, chunk)
+	buffer, err := c.ReadPooled()
 	if err != nil {
 		return nil, err
 	}
-	if bytes.Equal(chunk, []byte{0, 0}) {
-		return nil, fmt.Errorf("could not read response chunk")
+	defer Release(buffer)
+	return append([]byte(nil), *buffer...), nil
+}
+
+// ReadPooled reads the next message like ReadUnchunked, into a pooled buffer
+// that must be handed back with Release once the message is unpacked
+func (c *Chunker) ReadPooled() (*[]byte, error) {
+	if c.reader == nil {
+		c.reader = bufio.NewReader(c.Connection)
+	}
+	buffer := messageBuffers.Get().(*[]byte)
+	message := (*buffer)[:0]
+	received := 0
+	for {
+		if _, err := io.ReadFull(c.reader, c.header[:]); err != nil {
+			return nil, c.failRead(buffer, received, err)
+		}
+		received += 2
+		chunkSize := int(packstream.Endianness.Uint16(c.header[:]))
+		if chunkSize == 0 {
+			if len(message) == 0 {
+				continue
+			}
+			c.countReceived(received)
+			*buffer = message
+			return buffer, nil
+		}
+		offset := len(message)
+		if c.MaxMessageSize > 0 && offset+chunkSize > c.MaxMessageSize {
+			return nil, c.failRead(buffer, received, &ProtocolError{Message: fmt.Sprintf("message exceeds the maximum size of %d bytes", c.MaxMessageSize)})
+		}
+		message = grow(message, chunkSize)
+		if _, err := io.ReadFull(c.reader, message[offset:]); err != nil {
+			return nil, c.failRead(buffer, received, err)
+		}
+		received += chunkSize
+	}
+}
+
+func (c *Chunker) failRead(buffer *[]byte, received int, err error) error {
+	Release(buffer)
+	c.countReceived(received)
+	return err
+}
+
+func (c *Chunker) countReceived(n int) {
+	if c.Counter != nil && n > 0 {
+		c.Counter.BytesReceived(n)
+	}
+}
+
+// Release hands a buffer returned by ReadPooled back to the pool
+func Release(buffer *[]byte) {
+	if cap(*buffer) > maxPooledBufferSize {
+		return
 	}
-	responseSize := packstream.Endianness.Uint16(chunk)
-	response := make([]byte, responseSize+2)
-	err = binary.Read(c.Connection, packstream.Endianness, response)
-	return response[0:responseSize], err
+	*buffer = (*buffer)[:0]
+	messageBuffers.Put(buffer)
 }
 
-func chunk(rawMessage []byte) []byte {
-	length := len(rawMessage)
-	if length > math.MaxUint16 {
-		return nil
+func grow(buffer []byte, n int) []byte {
+	length := len(buffer) + n
+	if length <= cap(buffer) {
+		return buffer[:length]
 	}
-	buffer := make([]byte, 2+length+2)
-	packstream.Endianness.PutUint16(buffer[:2], uint16(length))
-	copy(buffer[2:len(buffer)-2], rawMessage)
-	buffer[len(buffer)-2] = 0
-	buffer[len(buffer)-1] = 0
-	return buffer
+	grown := make([]byte, length, 2*cap(buffer)+n)
+	copy(grown, buffer)
+	return grown
 }
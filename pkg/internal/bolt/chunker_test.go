@@ -16,16 +16,13 @@ func TestMessageChunking(t *testing.T) {
 		Connection: left,
 	}
 
-	go func() {
-		err := chunker.WriteChunked([]byte{1, 2, 3, 4})
-
-		Expect(err).NotTo(HaveOccurred(), "must write chunk to left side")
-		err = left.Close()
-		Expect(err).NotTo(HaveOccurred(), "must send EOF to unblock read from right side")
-	}()
+	written := inBackground(func() error {
+		return closing(left, chunker.WriteChunked([]byte{1, 2, 3, 4}))
+	})
 
 	chunk, err := io.ReadAll(right)
 	Expect(err).NotTo(HaveOccurred())
+	Expect(<-written).To(Succeed(), "must write chunk to left side and send EOF")
 	Expect(chunk).To(Equal([]byte{0, 4, 1, 2, 3, 4, 0, 0}))
 }
 
@@ -37,15 +34,201 @@ func TestMessageUnchunking(t *testing.T) {
 		Connection: right,
 	}
 
-	go func() {
+	written := inBackground(func() error {
 		_, err := left.Write([]byte{0, 9, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 0})
-		Expect(err).NotTo(HaveOccurred(), "must write chunk to left side")
-		err = left.Close()
-		Expect(err).NotTo(HaveOccurred(), "must send EOF to unblock read from right side")
-	}()
+		return closing(left, err)
+	})
 
 	message, err := chunker.ReadUnchunked()
 
 	Expect(err).NotTo(HaveOccurred())
+	Expect(<-written).To(Succeed(), "must write chunk to left side and send EOF")
 	Expect(message).To(Equal([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9}))
 }
+
+func TestLargeMessageChunking(t *testing.T) {
+	RegisterTestingT(t)
+
+	left, right := net.Pipe()
+	chunker := &bolt.Chunker{
+		Connection: left,
+	}
+	message := make([]byte, 65535+10)
+	for i := range message {
+		message[i] = byte(i)
+	}
+
+	written := inBackground(func() error {
+		return closing(left, chunker.WriteChunked(message))
+	})
+
+	chunks, err := io.ReadAll(right)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(<-written).To(Succeed(), "must write chunks to left side and send EOF")
+	Expect(chunks).To(HaveLen(2 + 65535 + 2 + 10 + 2))
+	Expect(chunks[:2]).To(Equal([]byte{0xFF, 0xFF}))
+	Expect(chunks[2 : 2+65535]).To(Equal(message[:65535]))
+	Expect(chunks[2+65535 : 2+65535+2]).To(Equal([]byte{0, 10}))
+	Expect(chunks[2+65535+2 : 2+65535+2+10]).To(Equal(message[65535:]))
+	Expect(chunks[len(chunks)-2:]).To(Equal([]byte{0, 0}))
+}
+
+func TestMultiChunkMessageUnchunking(t *testing.T) {
+	RegisterTestingT(t)
+
+	left, right := net.Pipe()
+	chunker := &bolt.Chunker{
+		Connection: right,
+	}
+
+	written := inBackground(func() error {
+		_, err := left.Write([]byte{
+			0, 0, // NOOP
+			0, 2, 1, 2,
+			0, 3, 3, 4, 5,
+			0, 0,
+			0, 0, // NOOP
+			0, 1, 6,
+			0, 0,
+		})
+		return closing(left, err)
+	})
+
+	first, err := chunker.ReadUnchunked()
+	Expect(err).NotTo(HaveOccurred())
+	second, err := chunker.ReadUnchunked()
+	Expect(err).NotTo(HaveOccurred())
+	_, err = chunker.ReadUnchunked()
+	Expect(<-written).To(Succeed(), "must write chunks to left side and send EOF")
+
+	Expect(first).To(Equal([]byte{1, 2, 3, 4, 5}))
+	Expect(second).To(Equal([]byte{6}))
+	Expect(err).To(Equal(io.EOF))
+}
+
+func TestLargeMessageRoundTrip(t *testing.T) {
+	RegisterTestingT(t)
+
+	left, right := net.Pipe()
+	writer := &bolt.Chunker{Connection: left}
+	reader := &bolt.Chunker{Connection: right}
+	message := make([]byte, 3*65535+1)
+	for i := range message {
+		message[i] = byte(i % 251)
+	}
+
+	written := inBackground(func() error {
+		return writer.WriteChunked(message, []byte{42})
+	})
+
+	first, err := reader.ReadUnchunked()
+	Expect(err).NotTo(HaveOccurred())
+	second, err := reader.ReadUnchunked()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(<-written).To(Succeed(), "must write chunks to left side")
+	Expect(first).To(Equal(message))
+	Expect(second).To(Equal([]byte{42}))
+}
+
+func TestMessageSizeLimit(t *testing.T) {
+	RegisterTestingT(t)
+
+	left, right := net.Pipe()
+	chunker := &bolt.Chunker{
+		Connection:     right,
+		MaxMessageSize: 4,
+	}
+
+	written := inBackground(func() error {
+		_, err := left.Write([]byte{0, 2, 1, 2, 0, 3, 3, 4, 5, 0, 0})
+		return err
+	})
+
+	_, err := chunker.ReadUnchunked()
+	_ = right.Close()
+	<-written
+
+	Expect(err).To(Equal(&bolt.ProtocolError{Message: "message exceeds the maximum size of 4 bytes"}))
+}
+
+func BenchmarkWriteChunked(b *testing.B) {
+	chunker := &bolt.Chunker{Connection: &loopingConn{}}
+	run := make([]byte, 128)
+	pull := make([]byte, 16)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := chunker.WriteChunked(run, pull); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadPooled(b *testing.B) {
+	chunker := &bolt.Chunker{Connection: &loopingConn{loop: []byte{0, 4, 1, 2, 3, 4, 0, 0}}}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		message, err := chunker.ReadPooled()
+		if err != nil {
+			b.Fatal(err)
+		}
+		bolt.Release(message)
+	}
+}
+
+// inBackground runs the function in a goroutine of its own, so that tests
+// assert on its error in their own goroutine once they are done reading
+func inBackground(function func() error) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- function()
+	}()
+	return result
+}
+
+// closing closes the connection once written to, so that reads of the other
+// side end
+func closing(connection net.Conn, err error) error {
+	if err != nil {
+		return err
+	}
+	return connection.Close()
+}
+
+// loopingConn replays its prefix once and then its loop forever, and
+// discards whatever is written to it
+type loopingConn struct {
+	net.Conn
+	prefix []byte
+	loop   []byte
+	offset int
+}
+
+func (l *loopingConn) Read(buffer []byte) (int, error) {
+	if len(l.prefix) > 0 {
+		n := copy(buffer, l.prefix)
+		l.prefix = l.prefix[n:]
+		return n, nil
+	}
+	if len(l.loop) == 0 {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(buffer) {
+		copied := copy(buffer[n:], l.loop[l.offset:])
+		l.offset = (l.offset + copied) % len(l.loop)
+		n += copied
+	}
+	return n, nil
+}
+
+func (l *loopingConn) Write(buffer []byte) (int, error) {
+	return len(buffer), nil
+}
+
+func (l *loopingConn) Close() error {
+	return nil
+}
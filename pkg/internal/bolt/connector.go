@@ -1,41 +1,146 @@
 package bolt
 
 import (
+	"context"
 	"fmt"
 	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
 	"net"
 	"net/url"
+	"runtime"
+	"strings"
 	"time"
 )
 
 const userAgent = "Go-usain/0.0.1"
 
 type Connector struct {
-	chunker    *Chunker
-	handshaker *Handshaker
-	connection net.Conn
+	chunker      *Chunker
+	handshaker   *Handshaker
+	connection   net.Conn
+	address      string
+	version      *serverVersion
+	hydrator     *packstream.Hydrator
+	structures   *packstream.Structures
+	decoder      packstream.Decoder
+	serverAgent  string
+	connectionId string
+	logger       MessageLogger
 }
 
+// MessageLogger is told about every message sent and received over a
+// connection, identified as bolt-12@localhost:7687 once the server assigned
+// it an ID and as localhost:7687 before. Messages are formatted on a single
+// line, with credentials redacted.
+type MessageLogger interface {
+	LogClientMessage(connection string, message string)
+	LogServerMessage(connection string, message string)
+}
+
+// routeTag is the tag of the ROUTE message, which the DATETIME_ZONE_ID
+// structure of the protocol shares
+const routeTag byte = 0x66
+
+// DefaultMaxMessageSize bounds the size of the messages connectors read,
+// unless configured otherwise
+const DefaultMaxMessageSize = 256 << 20
+
 func (c *Connector) Close() error {
 	return c.connection.Close()
 }
 
+// Dialer opens the connections to servers
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
 func NewConnector(host string) (*Connector, error) {
+	return DialConnector(context.Background(), &net.Dialer{}, host, DefaultCapabilities)
+}
+
+// DialConnector connects to the host with the dialer, which is asked for a
+// tcp connection and is free to reach the host by other means. The
+// capabilities are the flags offered in the handshake manifest.
+func DialConnector(ctx context.Context, dialer Dialer, host string, capabilities uint64) (*Connector, error) {
 	address := schemeless(host)
-	connection, err := net.Dial("tcp", address)
+	connection, err := dialer.DialContext(ctx, "tcp", address)
 	if err != nil {
 		return nil, err
 	}
 	return &Connector{
 		connection: connection,
-		chunker:    &Chunker{Connection: connection},
-		handshaker: &Handshaker{connection: connection},
+		address:    address,
+		chunker:    &Chunker{Connection: connection, MaxMessageSize: DefaultMaxMessageSize},
+		handshaker: newHandshaker(connection, capabilities),
+		decoder:    packstream.Decoder{Limits: packstream.DefaultLimits},
 	}, nil
 }
 
+// SetLimits bounds the size of read messages and of the values they hold,
+// zero means no limit
+func (c *Connector) SetLimits(maxMessageSize int, limits packstream.Limits) {
+	c.chunker.MaxMessageSize = maxMessageSize
+	c.decoder.Limits = limits
+}
+
+// SetDuplicateKeyPolicy decides what happens to keys occurring more than once
+// in received dictionaries
+func (c *Connector) SetDuplicateKeyPolicy(policy packstream.DuplicateKeyPolicy) {
+	c.decoder.DuplicateKeys = policy
+}
+
+// SetStructures hydrates the user-defined structures, to be called before
+// the handshake
+func (c *Connector) SetStructures(structures *packstream.Structures) {
+	c.structures = structures
+}
+
+// SetMessageLogger logs the messages exchanged from now on, nil disables
+// logging
+func (c *Connector) SetMessageLogger(logger MessageLogger) {
+	c.logger = logger
+}
+
+// SetByteCounter counts the bytes of the messages exchanged from now on, nil
+// disables counting
+func (c *Connector) SetByteCounter(counter ByteCounter) {
+	c.chunker.Counter = counter
+}
+
+// LogContext identifies the connection in logs
+func (c *Connector) LogContext() string {
+	if c.connectionId == "" {
+		return c.address
+	}
+	return fmt.Sprintf("%s@%s", c.connectionId, c.address)
+}
+
+// send logs and writes the messages in one go
+func (c *Connector) send(messages ...*packstream.Structure) error {
+	rawMessages := make([][]byte, len(messages))
+	for i, message := range messages {
+		if c.logger != nil {
+			c.logger.LogClientMessage(c.LogContext(), FormatMessage(RedactCredentials(message)))
+		}
+		rawMessages[i] = message.Pack()
+	}
+	return c.chunker.WriteChunked(rawMessages...)
+}
+
+// Address returns the host and port the connector is connected to
+func (c *Connector) Address() string {
+	return c.address
+}
+
+// SetDeadline bounds the time any subsequent read and write can take, the
+// zero value removes the deadline
+func (c *Connector) SetDeadline(deadline time.Time) error {
+	return c.connection.SetDeadline(deadline)
+}
+
 type serverVersion struct {
-	major byte
-	minor byte
+	major      byte
+	minor      byte
+	minorRange byte
 }
 
 func NewVersion(major, minor byte) *serverVersion {
@@ -45,25 +150,132 @@ func NewVersion(major, minor byte) *serverVersion {
 	}
 }
 
+// NewVersionRange proposes all versions from major.minor down to
+// major.(minor-minorRange) in a single handshake slot
+func NewVersionRange(major, minor, minorRange byte) *serverVersion {
+	return &serverVersion{
+		major:      major,
+		minor:      minor,
+		minorRange: minorRange,
+	}
+}
+
+func (v *serverVersion) Major() byte {
+	return v.major
+}
+
+func (v *serverVersion) Minor() byte {
+	return v.minor
+}
+
+func (v *serverVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
 func (v *serverVersion) toByteArray() []byte {
-	return []byte{0x0, 0x0, v.minor, v.major}
+	return []byte{0x0, v.minorRange, v.minor, v.major}
 }
 
-func (c *Connector) ShakeHands(version *serverVersion) error {
-	return c.handshaker.shakeHands(version.toByteArray())
+func (v *serverVersion) includes(other *serverVersion) bool {
+	return v.major == other.major &&
+		other.minor <= v.minor &&
+		int(other.minor) >= int(v.minor)-int(v.minorRange)
 }
 
-func (c *Connector) SendHello(username, password string) error {
-	hello := newHelloMessage(username, password)
-	return c.chunker.WriteChunked(hello.Pack())
+func (v *serverVersion) atLeast(major, minor byte) bool {
+	return v.major > major || v.major == major && v.minor >= minor
 }
 
-func (c *Connector) ReceiveSuccess() (*packstream.Structure, error) {
-	response, err := c.chunker.ReadUnchunked()
+// ShakeHands proposes up to 4 versions, by order of preference
+func (c *Connector) ShakeHands(versions ...*serverVersion) error {
+	version, err := c.handshaker.shakeHands(versions)
+	if err != nil {
+		return err
+	}
+	c.version = version
+	c.hydrator = packstream.NewHydrator(version.major, version.minor, c.structures)
+	return nil
+}
+
+// Version returns the protocol version negotiated during the handshake
+func (c *Connector) Version() *serverVersion {
+	return c.version
+}
+
+// Capabilities returns the capability flags agreed upon during the handshake,
+// they are always 0 when the server does not support the handshake manifest
+func (c *Connector) Capabilities() uint64 {
+	return c.handshaker.capabilities
+}
+
+// SupportsLogon returns true when authentication is performed with LOGON
+// after HELLO, instead of being part of HELLO
+func (c *Connector) SupportsLogon() bool {
+	return c.version.atLeast(5, 1)
+}
+
+// SendHello initializes the connection, the routing context is only sent
+// when the connection is used by a routing driver
+func (c *Connector) SendHello(username, password string, routingContext map[string]string) error {
+	hello := newHelloMessage(c.version, username, password, routingContext)
+	return c.send(hello)
+}
+
+func (c *Connector) SendLogon(username, password string) error {
+	if !c.SupportsLogon() {
+		return fmt.Errorf("LOGON is not supported by protocol version %s", c.version)
+	}
+	logon := newLogonMessage(username, password)
+	return c.send(logon)
+}
+
+func (c *Connector) SendLogoff() error {
+	if !c.SupportsLogon() {
+		return fmt.Errorf("LOGOFF is not supported by protocol version %s", c.version)
+	}
+	logoff := newLogoffMessage()
+	return c.send(logoff)
+}
+
+// SendGoodbye notifies the server that the connection is about to be closed,
+// no response is expected
+func (c *Connector) SendGoodbye() error {
+	goodbye := newGoodbyeMessage()
+	return c.send(goodbye)
+}
+
+// Reset clears the failure of the connection and rolls back its transaction,
+// if any. The responses to the requests sent since the failure, which the
+// server ignores, are skipped.
+func (c *Connector) Reset() error {
+	if err := c.send(newResetMessage()); err != nil {
+		return err
+	}
+	for {
+		response, err := c.Receive()
+		if err != nil {
+			return err
+		}
+		switch response.Name() {
+		case "IGNORED":
+			continue
+		case "SUCCESS":
+			return nil
+		}
+		return fmt.Errorf("expected SUCCESS in response to RESET but got %v", response)
+	}
+}
+
+// Receive reads the next response, FAILURE responses are turned into
+// ServerError and RECORD fields are hydrated
+func (c *Connector) Receive() (*packstream.Structure, error) {
+	response, err := c.chunker.ReadPooled()
 	if err != nil {
 		return nil, err
 	}
-	value, _, err := packstream.UnpackValue(response)
+	// unpacked values do not refer to the buffer, which can be reused
+	value, _, err := c.decoder.Unpack(*response)
+	Release(response)
 	if err != nil {
 		return nil, err
 	}
@@ -71,74 +283,290 @@ func (c *Connector) ReceiveSuccess() (*packstream.Structure, error) {
 	if !casted {
 		return nil, fmt.Errorf("expected structure but got %v\n", value)
 	}
-	if structure.Name() != "SUCCESS" {
-		return nil, fmt.Errorf("expected SUCCESS but got %v\n", structure)
+	if c.logger != nil {
+		c.logger.LogServerMessage(c.LogContext(), FormatMessage(structure))
+	}
+	switch structure.Name() {
+	case "FAILURE":
+		return nil, newServerError(structure)
+	case "RECORD":
+		if len(structure.Fields) != 1 {
+			return nil, fmt.Errorf("expected RECORD to have 1 field, got %d", len(structure.Fields))
+		}
+		record, err := c.hydrator.Hydrate(structure.Fields[0])
+		if err != nil {
+			return nil, err
+		}
+		structure.Fields[0] = record
 	}
 	return structure, nil
 }
-func (c *Connector) SendRun(query string, accessMode string) error {
-	run := newRunMessage(query, accessMode)
-	pull := newPullMessage(1000)
-	return c.chunker.WriteChunked(run.Pack(), pull.Pack())
-}
 
-func (c *Connector) ReceiveRecord() (*packstream.List, error) {
-	response, err := c.chunker.ReadUnchunked()
+func (c *Connector) ReceiveSuccess() (*packstream.Structure, error) {
+	structure, err := c.Receive()
 	if err != nil {
 		return nil, err
 	}
-	value, _, err := packstream.UnpackValue(response)
+	if structure.Name() != "SUCCESS" {
+		return nil, fmt.Errorf("expected SUCCESS but got %v\n", structure)
+	}
+	return structure, nil
+}
+
+// ReceiveHelloSuccess expects the response to HELLO and keeps track of the
+// server agent and connection ID it includes
+func (c *Connector) ReceiveHelloSuccess() error {
+	success, err := c.ReceiveSuccess()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	structure, casted := value.(*packstream.Structure)
+	if len(success.Fields) == 0 {
+		return fmt.Errorf("expected HELLO metadata but got none")
+	}
+	metadata, casted := success.Fields[0].(*packstream.OrderedDictionary)
 	if !casted {
-		return nil, fmt.Errorf("expected structure but got %v\n", value)
+		return fmt.Errorf("expected HELLO metadata but got %v", success.Fields[0])
+	}
+	if agent, casted := metadata.Get("server").(*packstream.String); casted {
+		c.serverAgent = string(*agent)
+	}
+	if connectionId, casted := metadata.Get("connection_id").(*packstream.String); casted {
+		c.connectionId = string(*connectionId)
+	}
+	return nil
+}
+
+// ServerAgent returns the server agent (e.g.: Neo4j/5.4.0) received in the
+// response to HELLO
+func (c *Connector) ServerAgent() string {
+	return c.serverAgent
+}
+
+// ConnectionId returns the server-side connection ID received in the
+// response to HELLO
+func (c *Connector) ConnectionId() string {
+	return c.connectionId
+}
+
+// SendRun sends the query alongside the first PULL, the database defaults
+// to the user home database when empty
+func (c *Connector) SendRun(query string, parameters *packstream.Dictionary, accessMode string, database string) error {
+	run := newRunMessage(query, c.dehydrate(parameters), transactionExtra(accessMode, database))
+	pull := newPullMessage(DefaultFetchSize)
+	return c.send(run, pull)
+}
+
+// SendBegin starts an explicit transaction, the database defaults to the
+// user home database when empty
+func (c *Connector) SendBegin(accessMode string, database string) error {
+	begin := newBeginMessage(accessMode, database)
+	return c.send(begin)
+}
+
+// SendRunInTransaction sends the query of the current explicit transaction
+// alongside the first PULL
+func (c *Connector) SendRunInTransaction(query string, parameters *packstream.Dictionary) error {
+	run := newRunMessage(query, c.dehydrate(parameters), &packstream.Dictionary{})
+	pull := newPullMessage(DefaultFetchSize)
+	return c.send(run, pull)
+}
+
+// dehydrate turns the parameters into the structures of the negotiated
+// protocol version, such as date times
+func (c *Connector) dehydrate(parameters *packstream.Dictionary) *packstream.Dictionary {
+	if parameters == nil || c.hydrator == nil {
+		return parameters
+	}
+	return c.hydrator.Dehydrate(parameters).(*packstream.Dictionary)
+}
+
+// SendCommit commits the current explicit transaction
+func (c *Connector) SendCommit() error {
+	return c.send(&packstream.Structure{TagByte: 0x12})
+}
+
+// SendRollback rolls the current explicit transaction back
+func (c *Connector) SendRollback() error {
+	return c.send(&packstream.Structure{TagByte: 0x13})
+}
+
+// SupportsRoute returns true when routing tables can be fetched with ROUTE,
+// instead of calling the dbms.routing.getRoutingTable procedure
+func (c *Connector) SupportsRoute() bool {
+	return c.version.atLeast(4, 3)
+}
+
+// SendRoute requests the routing table of the database, the database
+// defaults to the user home database when empty
+func (c *Connector) SendRoute(routingContext map[string]string, database string) error {
+	if !c.SupportsRoute() {
+		return fmt.Errorf("ROUTE is not supported by protocol version %s", c.version)
+	}
+	route := newRouteMessage(c.version, routingContext, database)
+	return c.send(route)
+}
+
+// SendPull requests the next batch of n records of the current result
+func (c *Connector) SendPull(n int) error {
+	pull := newPullMessage(n)
+	return c.send(pull)
+}
+
+func (c *Connector) ReceiveRecord() (*packstream.List, error) {
+	structure, err := c.Receive()
+	if err != nil {
+		return nil, err
 	}
 	if structure.Name() != "RECORD" {
 		return nil, fmt.Errorf("expected RECORD, got %v response", structure)
 	}
-	return structure.Fields[0].(*packstream.List), nil
+	record, casted := structure.Fields[0].(*packstream.List)
+	if !casted {
+		return nil, fmt.Errorf("expected RECORD values to be a list, got %v", structure.Fields[0])
+	}
+	return record, nil
 }
 
-func newHelloMessage(username string, password string) *packstream.Structure {
+func newHelloMessage(version *serverVersion, username string, password string, routingContext map[string]string) *packstream.Structure {
 	agent := packstream.String(userAgent)
+	extra := packstream.Dictionary{
+		"user_agent": []packstream.Value{&agent},
+	}
+	if routingContext != nil {
+		extra["routing"] = []packstream.Value{stringDictionary(routingContext)}
+	}
+	if version.atLeast(5, 3) {
+		extra["bolt_agent"] = []packstream.Value{newBoltAgent()}
+	}
+	if !version.atLeast(5, 1) {
+		for key, value := range *authenticationToken(username, password) {
+			extra[key] = value
+		}
+	}
+	return &packstream.Structure{
+		TagByte: 0x01,
+		Fields:  []packstream.Value{&extra},
+	}
+}
+
+func newBoltAgent() *packstream.Dictionary {
+	product := packstream.String(userAgent)
+	platform := packstream.String(fmt.Sprintf("%s; %s", runtime.GOOS, runtime.GOARCH))
+	language := packstream.String(fmt.Sprintf("Go/%s", runtime.Version()))
+	return &packstream.Dictionary{
+		"product":  []packstream.Value{&product},
+		"platform": []packstream.Value{&platform},
+		"language": []packstream.Value{&language},
+	}
+}
+
+func newGoodbyeMessage() *packstream.Structure {
+	return &packstream.Structure{TagByte: 0x02}
+}
+
+func newResetMessage() *packstream.Structure {
+	return &packstream.Structure{TagByte: 0x0F}
+}
+
+func newLogonMessage(username string, password string) *packstream.Structure {
+	return &packstream.Structure{
+		TagByte: 0x6A,
+		Fields:  []packstream.Value{authenticationToken(username, password)},
+	}
+}
+
+func newLogoffMessage() *packstream.Structure {
+	return &packstream.Structure{TagByte: 0x6B}
+}
+
+func authenticationToken(username string, password string) *packstream.Dictionary {
 	scheme := packstream.String("basic")
 	principal := packstream.String(username)
 	credentials := packstream.String(password)
-	return &packstream.Structure{
-		TagByte: 0x01,
-		Fields: []packstream.Value{
-			&packstream.Dictionary{
-				"user_agent":  []packstream.Value{&agent},
-				"scheme":      []packstream.Value{&scheme},
-				"principal":   []packstream.Value{&principal},
-				"credentials": []packstream.Value{&credentials},
-			},
-		},
+	return &packstream.Dictionary{
+		"scheme":      []packstream.Value{&scheme},
+		"principal":   []packstream.Value{&principal},
+		"credentials": []packstream.Value{&credentials},
 	}
 }
 
 const transactionTimeout = time.Second * 30
 
-func newRunMessage(query string, accessMode string) *packstream.Structure {
+// DefaultFetchSize is the number of records pulled at once
+const DefaultFetchSize = 1000
+
+func newRunMessage(query string, parameters *packstream.Dictionary, extra *packstream.Dictionary) *packstream.Structure {
 	queryValue := packstream.String(query)
-	accessModeValue := packstream.String(accessMode)
+	if parameters == nil {
+		parameters = &packstream.Dictionary{}
+	}
 	return &packstream.Structure{
 		TagByte: 0x10,
 		Fields: []packstream.Value{
 			&queryValue,
-			&packstream.Dictionary{},
-			&packstream.Dictionary{
-				"bookmarks":   []packstream.Value{&packstream.List{}},
-				"tx_timeout":  []packstream.Value{packstream.Integer(transactionTimeout.Milliseconds())},
-				"tx_metadata": []packstream.Value{&packstream.Dictionary{}},
-				"mode":        []packstream.Value{&accessModeValue},
-			},
+			parameters,
+			extra,
+		},
+	}
+}
+
+// transactionExtra returns the metadata of the transactions RUN and BEGIN
+// start
+func transactionExtra(accessMode string, database string) *packstream.Dictionary {
+	accessModeValue := packstream.String(accessMode)
+	extra := packstream.Dictionary{
+		"bookmarks":   []packstream.Value{&packstream.List{}},
+		"tx_timeout":  []packstream.Value{packstream.Integer(transactionTimeout.Milliseconds())},
+		"tx_metadata": []packstream.Value{&packstream.Dictionary{}},
+		"mode":        []packstream.Value{&accessModeValue},
+	}
+	if database != "" {
+		databaseValue := packstream.String(database)
+		extra["db"] = []packstream.Value{&databaseValue}
+	}
+	return &extra
+}
+
+func newBeginMessage(accessMode string, database string) *packstream.Structure {
+	return &packstream.Structure{
+		TagByte: 0x11,
+		Fields:  []packstream.Value{transactionExtra(accessMode, database)},
+	}
+}
+
+func newRouteMessage(version *serverVersion, routingContext map[string]string, database string) *packstream.Structure {
+	var databaseValue packstream.Value = packstream.NilInstance()
+	if database != "" {
+		name := packstream.String(database)
+		databaseValue = &name
+	}
+	var lastField packstream.Value = databaseValue
+	if version.atLeast(4, 4) {
+		extra := packstream.Dictionary{}
+		if database != "" {
+			extra["db"] = []packstream.Value{databaseValue}
+		}
+		lastField = &extra
+	}
+	return &packstream.Structure{
+		TagByte: routeTag,
+		Fields: []packstream.Value{
+			stringDictionary(routingContext),
+			&packstream.List{},
+			lastField,
 		},
 	}
 }
 
+func stringDictionary(entries map[string]string) *packstream.Dictionary {
+	result := packstream.Dictionary{}
+	for key, value := range entries {
+		stringValue := packstream.String(value)
+		result[key] = []packstream.Value{&stringValue}
+	}
+	return &result
+}
+
 func newPullMessage(i int) *packstream.Structure {
 	return &packstream.Structure{
 		TagByte: 0x3F,
@@ -150,11 +578,21 @@ func newPullMessage(i int) *packstream.Structure {
 	}
 }
 
+// ParseAddress extracts the host and port of a URI such as
+// bolt://example.com:7687, the port defaults to 7687. Plain host:port
+// addresses are accepted as well.
+func ParseAddress(uri string) string {
+	return schemeless(uri)
+}
+
 func schemeless(host string) string {
+	if !strings.Contains(host, "://") {
+		host = "bolt://" + host
+	}
 	uri, _ := url.Parse(host)
 	port := uri.Port()
 	if port == "" {
 		port = "7687"
 	}
-	return fmt.Sprintf("%s:%s", uri.Hostname(), port)
+	return net.JoinHostPort(uri.Hostname(), port)
 }
@@ -0,0 +1,331 @@
+package bolt_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/internal/bolt"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	. "github.com/onsi/gomega"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestHandshake(t *testing.T) {
+	RegisterTestingT(t)
+
+	testCases := []struct {
+		serverResponse  []byte
+		expectedVersion string
+	}{
+		{[]byte{0, 0, 4, 5}, "5.4"},
+		{[]byte{0, 0, 0, 5}, "5.0"},
+		{[]byte{0, 0, 2, 4}, "4.2"},
+	}
+
+	for _, testCase := range testCases {
+		serverResponse := testCase.serverResponse
+		expectedVersion := testCase.expectedVersion
+		t.Run(fmt.Sprintf("negotiates %s", expectedVersion), func(t *testing.T) {
+			connector, server := connectorWithServer()
+			defer connector.Close()
+			go func() {
+				handshake := make([]byte, 20)
+				_, err := io.ReadFull(server, handshake)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(handshake).To(Equal([]byte{
+					0x60, 0x60, 0xB0, 0x17,
+					0, 0, 1, 0xFF,
+					0, 4, 4, 5,
+					0, 2, 4, 4,
+					0, 0, 0, 0,
+				}))
+				_, err = server.Write(serverResponse)
+				Expect(err).NotTo(HaveOccurred())
+			}()
+
+			err := connector.ShakeHands(bolt.NewVersionRange(5, 4, 4), bolt.NewVersionRange(4, 4, 2))
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(connector.Version().String()).To(Equal(expectedVersion))
+		})
+	}
+
+	t.Run("fails when no version is agreed upon", func(t *testing.T) {
+		connector, server := connectorWithServer()
+		defer connector.Close()
+		go func() {
+			_, err := io.ReadFull(server, make([]byte, 20))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = server.Write([]byte{0, 0, 0, 0})
+			Expect(err).NotTo(HaveOccurred())
+		}()
+
+		err := connector.ShakeHands(bolt.NewVersion(4, 2))
+
+		Expect(err).To(MatchError(`expected one of [4.2], but got "0.0" version`))
+	})
+
+	t.Run("negotiates with the server manifest", func(t *testing.T) {
+		connector, server := connectorWithServer()
+		defer connector.Close()
+		choice := make(chan []byte, 1)
+		go func() {
+			_, err := io.ReadFull(server, make([]byte, 20))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = server.Write([]byte{
+				0, 0, 1, 0xFF,
+				3,
+				0, 0, 0, 6,
+				0, 7, 7, 5,
+				0, 4, 4, 4,
+				0x80, 0x01,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			clientChoice := make([]byte, 5)
+			_, err = io.ReadFull(server, clientChoice)
+			Expect(err).NotTo(HaveOccurred())
+			choice <- clientChoice
+		}()
+
+		err := connector.ShakeHands(bolt.NewVersionRange(5, 4, 4), bolt.NewVersionRange(4, 4, 2))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(<-choice).To(Equal([]byte{0, 0, 4, 5, 0}))
+		Expect(connector.Version().String()).To(Equal("5.4"))
+		Expect(connector.Capabilities()).To(BeZero())
+	})
+
+	t.Run("picks the capabilities offered by both sides", func(t *testing.T) {
+		connector, server := connectorOffering(0x81)
+		defer connector.Close()
+		choice := make(chan []byte, 1)
+		go func() {
+			_, err := io.ReadFull(server, make([]byte, 20))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = server.Write([]byte{0, 0, 1, 0xFF, 1, 0, 4, 4, 5, 0x82, 0x01})
+			Expect(err).NotTo(HaveOccurred())
+			clientChoice := make([]byte, 6)
+			_, err = io.ReadFull(server, clientChoice)
+			Expect(err).NotTo(HaveOccurred())
+			choice <- clientChoice
+		}()
+
+		err := connector.ShakeHands(bolt.NewVersionRange(5, 4, 4))
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(<-choice).To(Equal([]byte{0, 0, 4, 5, 0x80, 0x01}))
+		Expect(connector.Capabilities()).To(Equal(uint64(0x80)))
+	})
+
+	t.Run("fails when the server manifest has no common version", func(t *testing.T) {
+		connector, server := connectorWithServer()
+		defer connector.Close()
+		go func() {
+			_, err := io.ReadFull(server, make([]byte, 20))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = server.Write([]byte{0, 0, 1, 0xFF, 1, 0, 0, 0, 6, 0})
+			Expect(err).NotTo(HaveOccurred())
+		}()
+
+		err := connector.ShakeHands(bolt.NewVersionRange(5, 4, 4))
+
+		Expect(err).To(MatchError("expected one of [5.4], but server only supports [6.0]"))
+	})
+}
+
+func TestAuthentication(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("sends credentials in HELLO before Bolt 5.1", func(t *testing.T) {
+		connector, server := negotiatedConnector(4, 4)
+		defer connector.Close()
+		go func() {
+			Expect(connector.SendHello("neo4j", "s3cr3t", nil)).To(Succeed())
+		}()
+
+		hello := readMessage(server)
+
+		Expect(hello.Name()).To(Equal("HELLO"))
+		extra := hello.Fields[0].(*packstream.OrderedDictionary).AsMap()
+		Expect(extra).To(HaveKey("user_agent"))
+		Expect(extra).To(HaveKey("principal"))
+		Expect(extra).To(HaveKey("credentials"))
+		Expect(connector.SupportsLogon()).To(BeFalse())
+	})
+
+	t.Run("sends credentials in LOGON since Bolt 5.1", func(t *testing.T) {
+		connector, server := negotiatedConnector(5, 1)
+		defer connector.Close()
+		go func() {
+			Expect(connector.SendHello("neo4j", "s3cr3t", nil)).To(Succeed())
+			Expect(connector.SendLogon("neo4j", "s3cr3t")).To(Succeed())
+			Expect(connector.SendLogoff()).To(Succeed())
+		}()
+
+		hello := readMessage(server)
+		logon := readMessage(server)
+		logoff := readMessage(server)
+
+		Expect(hello.Name()).To(Equal("HELLO"))
+		helloExtra := hello.Fields[0].(*packstream.OrderedDictionary).AsMap()
+		Expect(helloExtra).To(HaveKey("user_agent"))
+		Expect(helloExtra).NotTo(HaveKey("credentials"))
+		Expect(helloExtra).NotTo(HaveKey("bolt_agent"))
+		Expect(logon.Name()).To(Equal("LOGON"))
+		Expect(logon.Fields[0].(*packstream.OrderedDictionary).AsMap()).To(HaveKey("credentials"))
+		Expect(logoff).To(Equal(&packstream.Structure{TagByte: 0x6B}))
+	})
+
+	t.Run("sends bolt agent since Bolt 5.3", func(t *testing.T) {
+		connector, server := negotiatedConnector(5, 3)
+		defer connector.Close()
+		go func() {
+			Expect(connector.SendHello("neo4j", "s3cr3t", nil)).To(Succeed())
+		}()
+
+		hello := readMessage(server)
+
+		Expect(hello.Fields[0].(*packstream.OrderedDictionary).AsMap()).To(HaveKey("bolt_agent"))
+	})
+}
+
+func connectorWithServer() (*bolt.Connector, net.Conn) {
+	return connectorOffering(bolt.DefaultCapabilities)
+}
+
+// connectorOffering connects to a server, offering the capabilities in the
+// handshake manifest
+func connectorOffering(capabilities uint64) (*bolt.Connector, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		server, err := listener.Accept()
+		Expect(err).NotTo(HaveOccurred())
+		accepted <- server
+	}()
+	connector, err := bolt.DialConnector(context.Background(), &net.Dialer{}, listener.Addr().String(), capabilities)
+	Expect(err).NotTo(HaveOccurred())
+	return connector, <-accepted
+}
+
+func negotiatedConnector(major, minor byte) (*bolt.Connector, net.Conn) {
+	connector, server := connectorWithServer()
+	go func() {
+		_, err := io.ReadFull(server, make([]byte, 20))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = server.Write([]byte{0, 0, minor, major})
+		Expect(err).NotTo(HaveOccurred())
+	}()
+	Expect(connector.ShakeHands(bolt.NewVersion(major, minor))).To(Succeed())
+	return connector, server
+}
+
+// BenchmarkReceiveRecord measures the allocations per record, from the chunks
+// to hydrated values
+func BenchmarkReceiveRecord(b *testing.B) {
+	name := packstream.String("Jane")
+	record := packstream.Structure{TagByte: 0x71, Fields: []packstream.Value{&packstream.List{
+		packstream.Integer(42),
+		&name,
+		packstream.Float(1.5),
+	}}}
+	message := record.Pack()
+	chunk := append([]byte{0, byte(len(message))}, message...)
+	connection := &loopingConn{prefix: []byte{0, 0, 4, 4}, loop: append(chunk, 0, 0)}
+	connector, err := bolt.DialConnector(context.Background(), fixedDialer{connection}, "localhost", bolt.DefaultCapabilities)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := connector.ShakeHands(bolt.NewVersion(4, 4)); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := connector.ReceiveRecord(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type fixedDialer struct {
+	connection net.Conn
+}
+
+func (f fixedDialer) DialContext(context.Context, string, string) (net.Conn, error) {
+	return f.connection, nil
+}
+
+// serverChunkers keeps a chunker per server connection, as chunkers buffer
+// what they read ahead
+var serverChunkers sync.Map
+
+func readMessage(server net.Conn) *packstream.Structure {
+	chunker, _ := serverChunkers.LoadOrStore(server, &bolt.Chunker{Connection: server})
+	message, err := chunker.(*bolt.Chunker).ReadUnchunked()
+	Expect(err).NotTo(HaveOccurred())
+	value, _, err := packstream.UnpackValue(message)
+	Expect(err).NotTo(HaveOccurred())
+	return value.(*packstream.Structure)
+}
+
+func TestRoute(t *testing.T) {
+	RegisterTestingT(t)
+
+	routingContext := map[string]string{"address": "example.com:7687"}
+
+	t.Run("sends routing context in HELLO", func(t *testing.T) {
+		connector, server := negotiatedConnector(4, 4)
+		defer connector.Close()
+		go func() {
+			Expect(connector.SendHello("neo4j", "s3cr3t", routingContext)).To(Succeed())
+		}()
+
+		hello := readMessage(server)
+
+		address := packstream.String("example.com:7687")
+		Expect(hello.Fields[0].(*packstream.OrderedDictionary).Get("routing")).To(Equal(
+			packstream.NewOrderedDictionary("address", &address),
+		))
+	})
+
+	t.Run("sends database name as is with Bolt 4.3", func(t *testing.T) {
+		connector, server := negotiatedConnector(4, 3)
+		defer connector.Close()
+		go func() {
+			Expect(connector.SendRoute(routingContext, "movies")).To(Succeed())
+		}()
+
+		route := readMessage(server)
+
+		database := packstream.String("movies")
+		Expect(route.TagByte).To(Equal(byte(0x66)))
+		Expect(*route.Fields[1].(*packstream.List)).To(BeEmpty())
+		Expect(route.Fields[2]).To(Equal(&database))
+	})
+
+	t.Run("sends database name in extra dictionary since Bolt 4.4", func(t *testing.T) {
+		connector, server := negotiatedConnector(4, 4)
+		defer connector.Close()
+		go func() {
+			Expect(connector.SendRoute(routingContext, "movies")).To(Succeed())
+		}()
+
+		route := readMessage(server)
+
+		database := packstream.String("movies")
+		Expect(route.Fields[2]).To(Equal(packstream.NewOrderedDictionary("db", &database)))
+	})
+
+	t.Run("is not supported before Bolt 4.3", func(t *testing.T) {
+		connector, _ := negotiatedConnector(4, 2)
+		defer connector.Close()
+
+		Expect(connector.SendRoute(routingContext, "")).To(MatchError("ROUTE is not supported by protocol version 4.2"))
+	})
+}
@@ -0,0 +1,38 @@
+package bolt
+
+import (
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+)
+
+// ProtocolError reports messages that do not follow the protocol or exceed
+// the configured limits
+type ProtocolError = packstream.ProtocolError
+
+// ServerError is the error reported by the server in a FAILURE response
+type ServerError struct {
+	Code    string
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("server error [%s]: %s", e.Code, e.Message)
+}
+
+func newServerError(failure *packstream.Structure) *ServerError {
+	result := &ServerError{}
+	if len(failure.Fields) == 0 {
+		return result
+	}
+	metadata, casted := failure.Fields[0].(*packstream.OrderedDictionary)
+	if !casted {
+		return result
+	}
+	if code, casted := metadata.Get("code").(*packstream.String); casted {
+		result.Code = string(*code)
+	}
+	if message, casted := metadata.Get("message").(*packstream.String); casted {
+		result.Message = string(*message)
+	}
+	return result
+}
@@ -0,0 +1,136 @@
+package bolt
+
+import (
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	"sort"
+	"strings"
+)
+
+// MessageName names the message, telling ROUTE apart from the
+// DATETIME_ZONE_ID structure sharing its tag
+func MessageName(message *packstream.Structure) string {
+	if message.TagByte == routeTag {
+		return "ROUTE"
+	}
+	if name := message.Name(); name != "" {
+		return name
+	}
+	return fmt.Sprintf("<%X>", message.TagByte)
+}
+
+// RedactCredentials returns a copy of HELLO and LOGON messages where the
+// credentials are masked, other messages are returned as is
+func RedactCredentials(message *packstream.Structure) *packstream.Structure {
+	name := MessageName(message)
+	if name != "HELLO" && name != "LOGON" || len(message.Fields) == 0 {
+		return message
+	}
+	masked := packstream.String("******")
+	var redacted packstream.Value
+	switch token := message.Fields[0].(type) {
+	case *packstream.Dictionary:
+		if _, found := (*token)["credentials"]; !found {
+			return message
+		}
+		copied := make(packstream.Dictionary, len(*token))
+		for key, values := range *token {
+			copied[key] = values
+		}
+		copied["credentials"] = []packstream.Value{&masked}
+		redacted = &copied
+	case *packstream.OrderedDictionary:
+		if token.Get("credentials") == nil {
+			return message
+		}
+		copied := &packstream.OrderedDictionary{Entries: append([]packstream.DictionaryEntry(nil), token.Entries...)}
+		copied.Set("credentials", &masked)
+		redacted = copied
+	default:
+		return message
+	}
+	fields := append([]packstream.Value{redacted}, message.Fields[1:]...)
+	return &packstream.Structure{TagByte: message.TagByte, Fields: fields}
+}
+
+// FormatMessage renders the message on a single line, such as
+// RUN "RETURN 42" {} {mode: "r"}
+func FormatMessage(message *packstream.Structure) string {
+	result := strings.Builder{}
+	result.WriteString(MessageName(message))
+	for _, field := range message.Fields {
+		result.WriteString(" ")
+		writeValue(&result, field)
+	}
+	return result.String()
+}
+
+// FormatValue renders the value like the fields of FormatMessage, such as
+// {name: "Alice", tags: ["a", "b"]}
+func FormatValue(value packstream.Value) string {
+	result := strings.Builder{}
+	writeValue(&result, value)
+	return result.String()
+}
+
+func writeValue(result *strings.Builder, value packstream.Value) {
+	switch v := value.(type) {
+	case *packstream.Nil:
+		result.WriteString("null")
+	case *packstream.List:
+		result.WriteString("[")
+		for i, element := range *v {
+			if i > 0 {
+				result.WriteString(", ")
+			}
+			writeValue(result, element)
+		}
+		result.WriteString("]")
+	case *packstream.OrderedDictionary:
+		result.WriteString("{")
+		for i, entry := range v.Entries {
+			writeEntry(result, i, entry.Key, entry.Value)
+		}
+		result.WriteString("}")
+	case *packstream.Dictionary:
+		keys := make([]string, 0, len(*v))
+		for key := range *v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		result.WriteString("{")
+		i := 0
+		for _, key := range keys {
+			for _, entry := range (*v)[key] {
+				writeEntry(result, i, key, entry)
+				i++
+			}
+		}
+		result.WriteString("}")
+	case *packstream.Structure:
+		name := v.Name()
+		if name == "" {
+			name = fmt.Sprintf("<%X>", v.TagByte)
+		}
+		result.WriteString(name)
+		result.WriteString("(")
+		for i, field := range v.Fields {
+			if i > 0 {
+				result.WriteString(", ")
+			}
+			writeValue(result, field)
+		}
+		result.WriteString(")")
+	default:
+		result.WriteString(value.String())
+	}
+}
+
+func writeEntry(result *strings.Builder, index int, key string, value packstream.Value) {
+	if index > 0 {
+		result.WriteString(", ")
+	}
+	result.WriteString(key)
+	result.WriteString(": ")
+	writeValue(result, value)
+}
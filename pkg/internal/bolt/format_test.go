@@ -0,0 +1,39 @@
+package bolt_test
+
+import (
+	"github.com/fbiville/go-usain-go/pkg/internal/bolt"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestFormatMessage(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("renders messages on a single line", func(t *testing.T) {
+		label := packstream.String("Person")
+		record := &packstream.Structure{TagByte: 0x71, Fields: []packstream.Value{&packstream.List{
+			&packstream.Structure{TagByte: 0x4E, Fields: []packstream.Value{
+				packstream.Integer(1),
+				&packstream.List{&label},
+				packstream.NewOrderedDictionary("age", packstream.Integer(42), "alias", packstream.NilInstance()),
+			}},
+			packstream.Float(1.5),
+		}}}
+
+		Expect(bolt.FormatMessage(record)).To(Equal(`RECORD [NODE(1, ["Person"], {age: 42, alias: null}), 1.5]`))
+	})
+
+	t.Run("redacts credentials", func(t *testing.T) {
+		principal := packstream.String("neo4j")
+		credentials := packstream.String("s3cr3t")
+		logon := &packstream.Structure{TagByte: 0x6A, Fields: []packstream.Value{
+			packstream.NewOrderedDictionary("principal", &principal, "credentials", &credentials),
+		}}
+
+		redacted := bolt.RedactCredentials(logon)
+
+		Expect(bolt.FormatMessage(redacted)).To(Equal(`LOGON {principal: "neo4j", credentials: "******"}`))
+		Expect(bolt.FormatMessage(logon)).To(ContainSubstring("s3cr3t"))
+	})
+}
@@ -5,40 +5,147 @@ import (
 	"encoding/binary"
 	"fmt"
 	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	"io"
 	"net"
 )
 
+const maxProposedVersions = 4
+
+// maxManifestVersions bounds the number of versions a server can list in
+// its manifest, the actual number is expected to be well below that
+const maxManifestVersions = 256
+
+// manifestV1 is proposed in place of a version to signal the server that the
+// client understands the handshake manifest, where the server lists all its
+// supported versions and capabilities and the client picks one
+var manifestV1 = &serverVersion{major: 0xFF, minor: 0x01}
+
+// DefaultCapabilities are the capability flags the driver offers in the
+// handshake manifest, the protocol defines none of them yet
+const DefaultCapabilities uint64 = 0
+
 type Handshaker struct {
 	connection net.Conn
+	// offered are the capability flags the client is willing to use,
+	// capabilities the ones agreed upon with the server
+	offered      uint64
+	capabilities uint64
 }
 
-func (h *Handshaker) shakeHands(supportedVersion []byte) error {
+func newHandshaker(connection net.Conn, offered uint64) *Handshaker {
+	return &Handshaker{connection: connection, offered: offered}
+}
+
+// shakeHands proposes the given versions, by order of preference. The
+// manifest marker is proposed first whenever there is a slot left for it.
+func (h *Handshaker) shakeHands(supportedVersions []*serverVersion) (*serverVersion, error) {
+	if len(supportedVersions) == 0 || len(supportedVersions) > maxProposedVersions {
+		return nil, fmt.Errorf("expected between 1 and %d versions to propose, got %d", maxProposedVersions, len(supportedVersions))
+	}
 	_, err := h.connection.Write([]byte{0x60, 0x60, 0xB0, 0x17})
 	if err != nil {
-		return fmt.Errorf("could not send handshake preamble %w", err)
-	}
-	_, err = h.connection.Write(append(
-		supportedVersion,
-		0x0, 0x0, 0x0, 0x0,
-		0x0, 0x0, 0x0, 0x0,
-		0x0, 0x0, 0x0, 0x0,
-	))
+		return nil, fmt.Errorf("could not send handshake preamble %w", err)
+	}
+	proposals := make([]byte, 0, 4*maxProposedVersions)
+	if len(supportedVersions) < maxProposedVersions {
+		proposals = append(proposals, manifestV1.toByteArray()...)
+	}
+	for _, version := range supportedVersions {
+		proposals = append(proposals, version.toByteArray()...)
+	}
+	for len(proposals) < cap(proposals) {
+		proposals = append(proposals, 0x0)
+	}
+	_, err = h.connection.Write(proposals)
 	if err != nil {
-		return fmt.Errorf("could not send handshake supported versions %w", err)
+		return nil, fmt.Errorf("could not send handshake supported versions %w", err)
 	}
 	response := []byte{0x0, 0x0, 0x0, 0x0}
 	err = binary.Read(h.connection, packstream.Endianness, response)
 	if err != nil {
-		return fmt.Errorf("could not receive handshake response %w", err)
+		return nil, fmt.Errorf("could not receive handshake response %w", err)
 	}
 	responseLength := len(response)
 	if responseLength != 4 {
-		return fmt.Errorf("expected handshake response to be 4 byte long, got %d", responseLength)
+		return nil, fmt.Errorf("expected handshake response to be 4 byte long, got %d", responseLength)
+	}
+	if bytes.Equal(response, manifestV1.toByteArray()) {
+		return h.negotiateManifest(supportedVersions)
+	}
+	agreedVersion := NewVersion(response[3], response[2])
+	for _, version := range supportedVersions {
+		if version.includes(agreedVersion) {
+			return agreedVersion, nil
+		}
+	}
+	return nil, fmt.Errorf("expected one of %v, but got \"%s\" version", supportedVersions, agreedVersion)
+}
+
+// negotiateManifest reads the server versions and capabilities listed in the
+// manifest, then sends back the best version supported by both sides
+// alongside the capabilities the client is willing to use
+func (h *Handshaker) negotiateManifest(supportedVersions []*serverVersion) (*serverVersion, error) {
+	reader := &byteReader{reader: h.connection}
+	versionCount, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not receive handshake manifest version count %w", err)
+	}
+	if versionCount > maxManifestVersions {
+		return nil, fmt.Errorf("expected at most %d versions in handshake manifest, got %d", maxManifestVersions, versionCount)
+	}
+	serverVersions := make([]*serverVersion, versionCount)
+	for i := range serverVersions {
+		rawVersion := make([]byte, 4)
+		if _, err := io.ReadFull(h.connection, rawVersion); err != nil {
+			return nil, fmt.Errorf("could not receive handshake manifest version %d %w", i+1, err)
+		}
+		serverVersions[i] = NewVersionRange(rawVersion[3], rawVersion[2], rawVersion[1])
 	}
-	if !bytes.Equal(response, supportedVersion) {
-		return fmt.Errorf("expected \"%d.%d\", but got \"%d.%d\" version",
-			supportedVersion[2], supportedVersion[3],
-			response[2], response[3])
+	serverCapabilities, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not receive handshake manifest capabilities %w", err)
+	}
+	agreedVersion := bestVersion(supportedVersions, serverVersions)
+	if agreedVersion == nil {
+		_, _ = h.connection.Write([]byte{0x0, 0x0, 0x0, 0x0, 0x0})
+		return nil, fmt.Errorf("expected one of %v, but server only supports %v", supportedVersions, serverVersions)
+	}
+	h.capabilities = h.offered & serverCapabilities
+	rawCapabilities := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(rawCapabilities, h.capabilities)
+	choice := append(NewVersion(agreedVersion.major, agreedVersion.minor).toByteArray(), rawCapabilities[:n]...)
+	if _, err := h.connection.Write(choice); err != nil {
+		return nil, fmt.Errorf("could not send handshake manifest choice %w", err)
+	}
+	return agreedVersion, nil
+}
+
+// bestVersion returns the most preferred client version that the server
+// supports, or nil if there is none
+func bestVersion(clientVersions, serverVersions []*serverVersion) *serverVersion {
+	for _, clientVersion := range clientVersions {
+		for minor := int(clientVersion.minor); minor >= int(clientVersion.minor)-int(clientVersion.minorRange) && minor >= 0; minor-- {
+			candidate := NewVersion(clientVersion.major, byte(minor))
+			for _, serverVersion := range serverVersions {
+				if serverVersion.includes(candidate) {
+					return candidate
+				}
+			}
+		}
 	}
 	return nil
 }
+
+// byteReader reads one byte at a time, so that no byte past the handshake
+// is consumed from the connection
+type byteReader struct {
+	reader io.Reader
+}
+
+func (b *byteReader) ReadByte() (byte, error) {
+	result := make([]byte, 1)
+	if _, err := io.ReadFull(b.reader, result); err != nil {
+		return 0, err
+	}
+	return result[0], nil
+}
@@ -0,0 +1,415 @@
+package bolt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Directions of the traffic
+const (
+	ClientToServer = "C"
+	ServerToClient = "S"
+)
+
+// Kinds of traffic entries
+const (
+	HandshakeEntry = "handshake"
+	MessageEntry   = "message"
+)
+
+// TrafficEntry is a handshake step or a message sent over a connection, the
+// recorder writes one per line as JSON with the credentials of HELLO and
+// LOGON redacted
+type TrafficEntry struct {
+	Time       time.Time `json:"time"`
+	Connection int       `json:"connection"`
+	Direction  string    `json:"direction"`
+	Kind       string    `json:"kind"`
+	// Offset locates the first byte of the entry in its direction of the
+	// traffic
+	Offset int `json:"offset"`
+	// Message describes the entry, such as RUN "RETURN 42" {} {}
+	Message string `json:"message"`
+	// Bytes holds the hexadecimal handshake bytes, or the hexadecimal message
+	// bytes once reassembled from its chunks
+	Bytes string `json:"bytes"`
+	// Malformed is set when the entry could not be decoded, in which case
+	// Message describes why
+	Malformed bool `json:"malformed,omitempty"`
+}
+
+// RawBytes decodes the hexadecimal bytes of the entry
+func (t TrafficEntry) RawBytes() ([]byte, error) {
+	return hex.DecodeString(t.Bytes)
+}
+
+// ReadTraffic reads the entries a recorder wrote
+func ReadTraffic(reader io.Reader) ([]TrafficEntry, error) {
+	var result []TrafficEntry
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 2*DefaultMaxMessageSize+1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry TrafficEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("could not read traffic entry on line %d: %w", line, err)
+		}
+		result = append(result, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read traffic: %w", err)
+	}
+	return result, nil
+}
+
+// Recorder writes the traffic of the connections it wraps, numbered in the
+// order they are wrapped
+type Recorder struct {
+	mutex       sync.Mutex
+	encoder     *json.Encoder
+	connections int
+	err         error
+}
+
+func NewRecorder(writer io.Writer) *Recorder {
+	return &Recorder{encoder: json.NewEncoder(writer)}
+}
+
+// Dialer wraps the connections the dialer opens
+func (r *Recorder) Dialer(dialer Dialer) Dialer {
+	return &recordingDialer{dialer: dialer, recorder: r}
+}
+
+// Wrap records what is read from and written to the connection
+func (r *Recorder) Wrap(connection net.Conn) net.Conn {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.connections++
+	return &recordingConn{
+		Conn:     connection,
+		recorder: r,
+		id:       r.connections,
+		client:   NewTrafficStream(ClientToServer, true),
+		server:   NewTrafficStream(ServerToClient, true),
+	}
+}
+
+// Err returns the first error writing the traffic failed with, after which
+// traffic is no longer recorded
+func (r *Recorder) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
+
+func (r *Recorder) write(entries []TrafficEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, entry := range entries {
+		if r.err != nil {
+			return
+		}
+		r.err = r.encoder.Encode(entry)
+	}
+}
+
+type recordingDialer struct {
+	dialer   Dialer
+	recorder *Recorder
+}
+
+func (r *recordingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	connection, err := r.dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return r.recorder.Wrap(connection), nil
+}
+
+type recordingConn struct {
+	net.Conn
+	recorder *Recorder
+	id       int
+	// mutex guards the streams, as the client stream needs to know how the
+	// server answered the handshake
+	mutex  sync.Mutex
+	client *TrafficStream
+	server *TrafficStream
+}
+
+func (r *recordingConn) Read(buffer []byte) (int, error) {
+	n, err := r.Conn.Read(buffer)
+	if n > 0 {
+		r.record(r.server, buffer[:n])
+	}
+	return n, err
+}
+
+func (r *recordingConn) Write(buffer []byte) (int, error) {
+	n, err := r.Conn.Write(buffer)
+	if n > 0 {
+		r.record(r.client, buffer[:n])
+	}
+	return n, err
+}
+
+func (r *recordingConn) record(stream *TrafficStream, data []byte) {
+	r.mutex.Lock()
+	entries := stream.Feed(data, r.server.manifest)
+	r.mutex.Unlock()
+	now := time.Now()
+	for i := range entries {
+		entries[i].Time = now
+		entries[i].Connection = r.id
+	}
+	r.recorder.write(entries)
+}
+
+type trafficStage int
+
+const (
+	handshakeStage trafficStage = iota
+	manifestStage
+	messageStage
+)
+
+// TrafficStream splits one direction of the traffic into handshake steps and
+// messages
+type TrafficStream struct {
+	direction string
+	stage     trafficStage
+	pending   []byte
+	// consumed counts the bytes of the stream split so far
+	consumed int
+	// message holds the chunks of the message being reassembled, which
+	// starts at messageOffset
+	message       []byte
+	messageOffset int
+	// manifest tells whether the server answered the handshake with a
+	// manifest, which the client answers with its choice
+	manifest bool
+}
+
+// NewTrafficStream splits the traffic of the direction, starting with the
+// handshake unless the traffic starts past it
+func NewTrafficStream(direction string, handshake bool) *TrafficStream {
+	result := &TrafficStream{direction: direction}
+	if !handshake {
+		result.stage = messageStage
+	}
+	return result
+}
+
+// Manifest tells whether the server answered the handshake with a manifest,
+// for server streams
+func (t *TrafficStream) Manifest() bool {
+	return t.manifest
+}
+
+// Pending returns the number of bytes fed that do not complete an entry yet
+func (t *TrafficStream) Pending() int {
+	if len(t.message) > 0 {
+		return t.consumed - t.messageOffset + len(t.pending)
+	}
+	return len(t.pending)
+}
+
+// Feed returns the entries completed by the data, serverManifest telling
+// whether the server answered with a manifest
+func (t *TrafficStream) Feed(data []byte, serverManifest bool) []TrafficEntry {
+	t.pending = append(t.pending, data...)
+	var result []TrafficEntry
+	for {
+		entry, ok := t.next(serverManifest)
+		if !ok {
+			return result
+		}
+		entry.Direction = t.direction
+		result = append(result, entry)
+	}
+}
+
+func (t *TrafficStream) next(serverManifest bool) (TrafficEntry, bool) {
+	switch t.stage {
+	case handshakeStage:
+		size := 20
+		if t.direction == ServerToClient {
+			size = 4
+		}
+		if len(t.pending) < size {
+			return TrafficEntry{}, false
+		}
+		offset := t.consumed
+		raw := t.consume(size)
+		// the client answers a manifest with its choice, which the client
+		// stream finds out once the server stream read the manifest
+		t.stage = manifestStage
+		if t.direction == ServerToClient {
+			t.manifest = bytes.Equal(raw, manifestV1.toByteArray())
+			if !t.manifest {
+				t.stage = messageStage
+			}
+		}
+		return handshakeEntry(offset, raw, t.describeHandshake(raw)), true
+	case manifestStage:
+		return t.nextManifest(serverManifest)
+	default:
+		return t.nextMessage()
+	}
+}
+
+func (t *TrafficStream) describeHandshake(raw []byte) string {
+	if t.direction == ClientToServer {
+		versions := make([]string, 0, maxProposedVersions)
+		for i := 4; i < len(raw); i += 4 {
+			proposal := raw[i : i+4]
+			switch {
+			case bytes.Equal(proposal, manifestV1.toByteArray()):
+				versions = append(versions, "manifest v1")
+			case proposal[2] != 0 || proposal[3] != 0:
+				versions = append(versions, describeVersionRange(proposal))
+			}
+		}
+		return fmt.Sprintf("HANDSHAKE %X %v", raw[:4], versions)
+	}
+	if t.manifest {
+		return "MANIFEST v1"
+	}
+	return fmt.Sprintf("VERSION %s", NewVersion(raw[3], raw[2]))
+}
+
+// nextManifest reads the versions and capabilities the server lists, or the
+// version and capabilities the client picks
+func (t *TrafficStream) nextManifest(serverManifest bool) (TrafficEntry, bool) {
+	if t.direction == ServerToClient {
+		count, n := binary.Uvarint(t.pending)
+		offset := t.consumed
+		if n > 0 && count > maxManifestVersions {
+			raw := t.consume(len(t.pending))
+			t.stage = messageStage
+			entry := handshakeEntry(offset, raw, fmt.Sprintf("<malformed: manifest of %d versions>", count))
+			entry.Malformed = true
+			return entry, true
+		}
+		if n <= 0 || len(t.pending) < n+4*int(count) {
+			return TrafficEntry{}, false
+		}
+		capabilities, m := binary.Uvarint(t.pending[n+4*int(count):])
+		if m <= 0 {
+			return TrafficEntry{}, false
+		}
+		versions := make([]string, count)
+		for i := range versions {
+			versions[i] = describeVersionRange(t.pending[n+4*i : n+4*i+4])
+		}
+		raw := t.consume(n + 4*int(count) + m)
+		t.stage = messageStage
+		return handshakeEntry(offset, raw, fmt.Sprintf("MANIFEST %v capabilities %d", versions, capabilities)), true
+	}
+	if len(t.pending) == 0 {
+		return TrafficEntry{}, false
+	}
+	if !serverManifest {
+		t.stage = messageStage
+		return t.nextMessage()
+	}
+	if len(t.pending) < 5 {
+		return TrafficEntry{}, false
+	}
+	capabilities, n := binary.Uvarint(t.pending[4:])
+	if n <= 0 {
+		return TrafficEntry{}, false
+	}
+	offset := t.consumed
+	raw := t.consume(4 + n)
+	t.stage = messageStage
+	return handshakeEntry(offset, raw, fmt.Sprintf("VERSION %s capabilities %d", NewVersion(raw[3], raw[2]), capabilities)), true
+}
+
+func (t *TrafficStream) nextMessage() (TrafficEntry, bool) {
+	for len(t.pending) >= 2 {
+		size := int(packstream.Endianness.Uint16(t.pending))
+		if len(t.pending) < 2+size {
+			return TrafficEntry{}, false
+		}
+		if size > 0 && len(t.message) == 0 {
+			t.messageOffset = t.consumed
+		}
+		chunk := t.consume(2 + size)[2:]
+		if size > 0 {
+			t.message = append(t.message, chunk...)
+			continue
+		}
+		if len(t.message) == 0 {
+			// NOOP chunks keep connections alive and carry no message
+			continue
+		}
+		description, raw, malformed := describeMessage(t.message)
+		t.message = nil
+		return TrafficEntry{Kind: MessageEntry, Offset: t.messageOffset, Message: description, Bytes: hex.EncodeToString(raw), Malformed: malformed}, true
+	}
+	return TrafficEntry{}, false
+}
+
+// consume removes the first bytes of the pending data and returns them
+func (t *TrafficStream) consume(size int) []byte {
+	result := make([]byte, size)
+	copy(result, t.pending)
+	t.pending = t.pending[size:]
+	t.consumed += size
+	return result
+}
+
+func handshakeEntry(offset int, raw []byte, description string) TrafficEntry {
+	return TrafficEntry{Kind: HandshakeEntry, Offset: offset, Message: description, Bytes: hex.EncodeToString(raw)}
+}
+
+func describeVersionRange(raw []byte) string {
+	if raw[1] == 0 {
+		return NewVersion(raw[3], raw[2]).String()
+	}
+	lowest := byte(0)
+	if raw[1] < raw[2] {
+		lowest = raw[2] - raw[1]
+	}
+	return fmt.Sprintf("%s-%s", NewVersion(raw[3], raw[2]), NewVersion(raw[3], lowest))
+}
+
+// DescribeMessage formats the unchunked message like the traffic entries, with
+// its credentials redacted
+func DescribeMessage(raw []byte) string {
+	description, _, _ := describeMessage(raw)
+	return description
+}
+
+// describeMessage formats the message, whose bytes are packed again when
+// credentials are redacted, and tells whether it is malformed
+func describeMessage(raw []byte) (string, []byte, bool) {
+	value, _, err := packstream.UnpackValue(raw)
+	if err != nil {
+		return fmt.Sprintf("<malformed: %v>", err), raw, true
+	}
+	message, ok := value.(*packstream.Structure)
+	if !ok {
+		return fmt.Sprintf("<not a message: %s>", value), raw, true
+	}
+	if redacted := RedactCredentials(message); redacted != message {
+		return FormatMessage(redacted), redacted.Pack(), false
+	}
+	return FormatMessage(message), raw, false
+}
@@ -0,0 +1,156 @@
+package bolt_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/fbiville/go-usain-go/pkg/internal/bolt"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	. "github.com/onsi/gomega"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("records handshake and decoded messages", func(t *testing.T) {
+		recording := &bytes.Buffer{}
+		recorder := bolt.NewRecorder(recording)
+		connector, server := recordedConnector(recorder)
+		defer connector.Close()
+		go func() {
+			_, err := io.ReadFull(server, make([]byte, 20))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = server.Write([]byte{0, 0, 4, 4})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readMessage(server).Name()).To(Equal("PULL"))
+			agent := packstream.String("Neo4j/4.4.0")
+			success := &packstream.Structure{TagByte: 0x70, Fields: []packstream.Value{
+				packstream.NewOrderedDictionary("server", &agent),
+			}}
+			chunker := &bolt.Chunker{Connection: server}
+			Expect(chunker.WriteChunked(success.Pack())).To(Succeed())
+		}()
+		Expect(connector.ShakeHands(bolt.NewVersionRange(4, 4, 2))).To(Succeed())
+		Expect(connector.SendPull(1000)).To(Succeed())
+		_, err := connector.ReceiveSuccess()
+		Expect(err).NotTo(HaveOccurred())
+
+		entries, err := bolt.ReadTraffic(recording)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(recorder.Err()).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(4))
+		descriptions := make([]string, len(entries))
+		for i, entry := range entries {
+			Expect(entry.Connection).To(Equal(1))
+			Expect(entry.Time).NotTo(BeZero())
+			descriptions[i] = entry.Direction + ": " + entry.Message
+		}
+		Expect(descriptions).To(Equal([]string{
+			"C: HANDSHAKE 6060B017 [manifest v1 4.4-4.2]",
+			"S: VERSION 4.4",
+			"C: PULL {n: 1000}",
+			`S: SUCCESS {server: "Neo4j/4.4.0"}`,
+		}))
+		Expect(entries[2].Bytes).To(Equal("b13fa1816ec903e8"))
+		Expect(entries[2].Offset).To(Equal(20))
+		Expect(entries[3].Offset).To(Equal(4))
+	})
+
+	t.Run("redacts credentials", func(t *testing.T) {
+		recording := &bytes.Buffer{}
+		client, server := net.Pipe()
+		defer server.Close()
+		go func() {
+			_, _ = io.Copy(io.Discard, server)
+		}()
+		connection := bolt.NewRecorder(recording).Wrap(client)
+		defer connection.Close()
+		scheme, principal, credentials := packstream.String("basic"), packstream.String("neo4j"), packstream.String("s3cr3t")
+		hello := &packstream.Structure{TagByte: 0x01, Fields: []packstream.Value{
+			packstream.NewOrderedDictionary("scheme", &scheme, "principal", &principal, "credentials", &credentials),
+		}}
+		_, err := connection.Write([]byte{0x60, 0x60, 0xB0, 0x17, 0, 0, 4, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+		Expect(err).NotTo(HaveOccurred())
+		Expect((&bolt.Chunker{Connection: connection}).WriteChunked(hello.Pack())).To(Succeed())
+
+		entries, err := bolt.ReadTraffic(recording)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[1].Message).To(Equal(`HELLO {scheme: "basic", principal: "neo4j", credentials: "******"}`))
+		raw, err := entries[1].RawBytes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(raw)).NotTo(ContainSubstring("s3cr3t"))
+	})
+
+	t.Run("records the handshake manifest", func(t *testing.T) {
+		recording := &bytes.Buffer{}
+		connector, server := recordedConnector(bolt.NewRecorder(recording))
+		defer connector.Close()
+		answered := inBackground(func() error {
+			if _, err := io.ReadFull(server, make([]byte, 20)); err != nil {
+				return err
+			}
+			if _, err := server.Write([]byte{0, 0, 1, 0xFF, 2, 0, 4, 4, 5, 0, 0, 4, 4, 0}); err != nil {
+				return err
+			}
+			_, err := io.ReadFull(server, make([]byte, 5))
+			return err
+		})
+		Expect(connector.ShakeHands(bolt.NewVersion(5, 2))).To(Succeed())
+		Expect(<-answered).To(Succeed())
+		Expect(connector.SendLogoff()).To(Succeed())
+
+		Eventually(func() []string {
+			entries, err := bolt.ReadTraffic(bytes.NewReader(recording.Bytes()))
+			Expect(err).NotTo(HaveOccurred())
+			result := make([]string, len(entries))
+			for i, entry := range entries {
+				result[i] = entry.Direction + ": " + entry.Message
+			}
+			return result
+		}).Should(Equal([]string{
+			"C: HANDSHAKE 6060B017 [manifest v1 5.2]",
+			"S: MANIFEST v1",
+			"S: MANIFEST [5.4-5.0 4.4] capabilities 0",
+			"C: VERSION 5.2 capabilities 0",
+			"C: LOGOFF",
+		}))
+	})
+}
+
+func TestTrafficStream(t *testing.T) {
+	RegisterTestingT(t)
+
+	stream := bolt.NewTrafficStream(bolt.ServerToClient, false)
+
+	entries := stream.Feed([]byte{0, 0, 0, 2, 0xB0, 0x7E, 0, 0, 0, 3, 0xB1, 0x70}, false)
+
+	Expect(entries).To(HaveLen(1))
+	Expect(entries[0].Message).To(Equal("IGNORED"))
+	Expect(entries[0].Offset).To(Equal(2))
+	Expect(stream.Pending()).To(Equal(4))
+	entries = stream.Feed([]byte{0xA0, 0, 0}, false)
+	Expect(entries).To(HaveLen(1))
+	Expect(entries[0].Message).To(Equal("SUCCESS {}"))
+	Expect(entries[0].Offset).To(Equal(8))
+	Expect(stream.Pending()).To(Equal(0))
+}
+
+func recordedConnector(recorder *bolt.Recorder) (*bolt.Connector, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		server, err := listener.Accept()
+		Expect(err).NotTo(HaveOccurred())
+		accepted <- server
+	}()
+	connector, err := bolt.DialConnector(context.Background(), recorder.Dialer(&net.Dialer{}), listener.Addr().String(), bolt.DefaultCapabilities)
+	Expect(err).NotTo(HaveOccurred())
+	return connector, <-accepted
+}
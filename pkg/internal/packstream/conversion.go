@@ -0,0 +1,74 @@
+package packstream
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// ValueOf converts Go values to PackStream values: nil, booleans, integers,
+// floats, strings, time.Time, slices, maps keyed by strings, whose entries
+// are sorted by key, and values that already are PackStream values
+func ValueOf(value interface{}) (Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return NilInstance(), nil
+	case Value:
+		return v, nil
+	case bool:
+		return Boolean(v), nil
+	case string:
+		result := String(v)
+		return &result, nil
+	case time.Time:
+		return &DateTime{Time: v}, nil
+	}
+	reflected := reflect.ValueOf(value)
+	switch reflected.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Integer(reflected.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		unsigned := reflected.Uint()
+		if unsigned > 1<<63-1 {
+			return nil, fmt.Errorf("%d overflows 64-bit signed integers", unsigned)
+		}
+		return Integer(unsigned), nil
+	case reflect.Float32, reflect.Float64:
+		return Float(reflected.Float()), nil
+	case reflect.Ptr:
+		if reflected.IsNil() {
+			return NilInstance(), nil
+		}
+		return ValueOf(reflected.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		result := make(List, reflected.Len())
+		for i := range result {
+			element, err := ValueOf(reflected.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			result[i] = element
+		}
+		return &result, nil
+	case reflect.Map:
+		if reflected.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("expected map keys to be strings, got %s", reflected.Type().Key())
+		}
+		// sorting the keys keeps the packed bytes stable
+		keys := reflected.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		result := &OrderedDictionary{Entries: make([]DictionaryEntry, 0, len(keys))}
+		for _, key := range keys {
+			entry, err := ValueOf(reflected.MapIndex(key).Interface())
+			if err != nil {
+				return nil, err
+			}
+			result.Set(key.String(), entry)
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("unsupported value %v of type %T", value, value)
+}
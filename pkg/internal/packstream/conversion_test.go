@@ -0,0 +1,50 @@
+package packstream_test
+
+import (
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	. "github.com/onsi/gomega"
+	"math"
+	"testing"
+)
+
+func TestValueOf(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("converts Go values", func(t *testing.T) {
+		name := packstream.String("Jane")
+
+		value, err := packstream.ValueOf(map[string]interface{}{
+			"name":    "Jane",
+			"age":     uint8(42),
+			"score":   1.5,
+			"active":  true,
+			"tags":    []int{1, 2},
+			"manager": nil,
+		})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value).To(Equal(packstream.NewOrderedDictionary(
+			"active", packstream.Boolean(true),
+			"age", packstream.Integer(42),
+			"manager", packstream.NilInstance(),
+			"name", &name,
+			"score", packstream.Float(1.5),
+			"tags", &packstream.List{packstream.Integer(1), packstream.Integer(2)},
+		)))
+	})
+
+	t.Run("keeps PackStream values", func(t *testing.T) {
+		Expect(packstream.ValueOf(packstream.Integer(42))).To(Equal(packstream.Integer(42)))
+	})
+
+	t.Run("rejects unsupported values", func(t *testing.T) {
+		_, err := packstream.ValueOf(map[int]string{1: "one"})
+		Expect(err).To(MatchError("expected map keys to be strings, got int"))
+
+		_, err = packstream.ValueOf(uint64(math.MaxUint64))
+		Expect(err).To(MatchError("18446744073709551615 overflows 64-bit signed integers"))
+
+		_, err = packstream.ValueOf(struct{}{})
+		Expect(err).To(MatchError("unsupported value {} of type struct {}"))
+	})
+}
@@ -0,0 +1,17 @@
+package packstream
+
+import "fmt"
+
+// ProtocolError reports data that does not follow the protocol or exceeds
+// the configured limits
+type ProtocolError struct {
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("protocol error: %s", e.Message)
+}
+
+func newProtocolError(format string, args ...interface{}) *ProtocolError {
+	return &ProtocolError{Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,458 @@
+package packstream
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	nodeTag                byte = 0x4E
+	relationshipTag        byte = 0x52
+	unboundRelationshipTag byte = 0x72
+	legacyDateTimeTag      byte = 0x46
+	// legacyDateTimeZoneIdTag is also the tag of the ROUTE message, which
+	// is never hydrated
+	legacyDateTimeZoneIdTag byte = 0x66
+	dateTimeTag             byte = 0x49
+	dateTimeZoneIdTag       byte = 0x69
+)
+
+// Hydrator turns raw structures into typed values (and back), following the
+// structure layouts of a given Bolt protocol version
+type Hydrator struct {
+	elementIds  bool
+	utcDateTime bool
+	// registries hold user-defined structures, the first registry defining
+	// a tag wins
+	registries []*StructureRegistry
+}
+
+// NewHydrator creates a hydrator for the protocol version, which also
+// hydrates the given structures registered for this version or for all
+// versions, structures may be nil
+func NewHydrator(major, minor byte, structures *Structures) *Hydrator {
+	return &Hydrator{
+		elementIds:  major >= 5,
+		utcDateTime: major >= 5,
+		registries:  structures.registries(ProtocolVersion{Major: major, Minor: minor}),
+	}
+}
+
+func (h *Hydrator) Hydrate(value Value) (Value, error) {
+	switch v := value.(type) {
+	case *List:
+		for i, element := range *v {
+			hydrated, err := h.Hydrate(element)
+			if err != nil {
+				return nil, err
+			}
+			(*v)[i] = hydrated
+		}
+	case *OrderedDictionary:
+		for i, entry := range v.Entries {
+			hydrated, err := h.Hydrate(entry.Value)
+			if err != nil {
+				return nil, err
+			}
+			v.Entries[i].Value = hydrated
+		}
+	case *Structure:
+		for i, field := range v.Fields {
+			hydrated, err := h.Hydrate(field)
+			if err != nil {
+				return nil, err
+			}
+			v.Fields[i] = hydrated
+		}
+		return h.hydrateStructure(v)
+	}
+	return value, nil
+}
+
+// Dehydrate converts typed values back to the structure layout expected by
+// the protocol version. Other values are returned as is.
+func (h *Hydrator) Dehydrate(value Value) Value {
+	switch v := value.(type) {
+	case *List:
+		result := make(List, len(*v))
+		for i, element := range *v {
+			result[i] = h.Dehydrate(element)
+		}
+		return &result
+	case *Dictionary:
+		result := make(Dictionary, len(*v))
+		for key, values := range *v {
+			dehydrated := make([]Value, len(values))
+			for i, element := range values {
+				dehydrated[i] = h.Dehydrate(element)
+			}
+			result[key] = dehydrated
+		}
+		return &result
+	case *OrderedDictionary:
+		result := OrderedDictionary{Entries: make([]DictionaryEntry, len(v.Entries))}
+		for i, entry := range v.Entries {
+			result.Entries[i] = DictionaryEntry{Key: entry.Key, Value: h.Dehydrate(entry.Value)}
+		}
+		return &result
+	case *DateTime:
+		return v.toStructure(h.utcDateTime)
+	}
+	for _, registry := range h.registries {
+		if result, known := registry.dehydrate(value); known {
+			return result
+		}
+	}
+	return value
+}
+
+func (h *Hydrator) hydrateStructure(structure *Structure) (Value, error) {
+	switch structure.TagByte {
+	case nodeTag:
+		return h.hydrateNode(structure)
+	case relationshipTag:
+		return h.hydrateRelationship(structure)
+	case unboundRelationshipTag:
+		return h.hydrateUnboundRelationship(structure)
+	case legacyDateTimeTag, legacyDateTimeZoneIdTag:
+		if h.utcDateTime {
+			return structure, nil
+		}
+		return hydrateDateTime(structure, false)
+	case dateTimeTag, dateTimeZoneIdTag:
+		if !h.utcDateTime {
+			return structure, nil
+		}
+		return hydrateDateTime(structure, true)
+	}
+	for _, registry := range h.registries {
+		if result, registered, err := registry.hydrate(structure); registered {
+			return result, err
+		}
+	}
+	return structure, nil
+}
+
+func (h *Hydrator) hydrateNode(structure *Structure) (Value, error) {
+	fields, err := h.checkFieldCount(structure, 3, 4)
+	if err != nil {
+		return nil, err
+	}
+	result := &Node{}
+	if result.Id, err = integerField(structure, fields[0]); err != nil {
+		return nil, err
+	}
+	if result.Labels, err = stringListField(structure, fields[1]); err != nil {
+		return nil, err
+	}
+	if result.Properties, err = dictionaryField(structure, fields[2]); err != nil {
+		return nil, err
+	}
+	if h.elementIds {
+		if result.ElementId, err = stringField(structure, fields[3]); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (h *Hydrator) hydrateRelationship(structure *Structure) (Value, error) {
+	fields, err := h.checkFieldCount(structure, 5, 8)
+	if err != nil {
+		return nil, err
+	}
+	result := &Relationship{}
+	if result.Id, err = integerField(structure, fields[0]); err != nil {
+		return nil, err
+	}
+	if result.StartId, err = integerField(structure, fields[1]); err != nil {
+		return nil, err
+	}
+	if result.EndId, err = integerField(structure, fields[2]); err != nil {
+		return nil, err
+	}
+	if result.Type, err = stringField(structure, fields[3]); err != nil {
+		return nil, err
+	}
+	if result.Properties, err = dictionaryField(structure, fields[4]); err != nil {
+		return nil, err
+	}
+	if h.elementIds {
+		if result.ElementId, err = stringField(structure, fields[5]); err != nil {
+			return nil, err
+		}
+		if result.StartElementId, err = stringField(structure, fields[6]); err != nil {
+			return nil, err
+		}
+		if result.EndElementId, err = stringField(structure, fields[7]); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (h *Hydrator) hydrateUnboundRelationship(structure *Structure) (Value, error) {
+	fields, err := h.checkFieldCount(structure, 3, 4)
+	if err != nil {
+		return nil, err
+	}
+	result := &UnboundRelationship{}
+	if result.Id, err = integerField(structure, fields[0]); err != nil {
+		return nil, err
+	}
+	if result.Type, err = stringField(structure, fields[1]); err != nil {
+		return nil, err
+	}
+	if result.Properties, err = dictionaryField(structure, fields[2]); err != nil {
+		return nil, err
+	}
+	if h.elementIds {
+		if result.ElementId, err = stringField(structure, fields[3]); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (h *Hydrator) checkFieldCount(structure *Structure, legacyCount, elementIdCount int) ([]Value, error) {
+	expected := legacyCount
+	if h.elementIds {
+		expected = elementIdCount
+	}
+	if len(structure.Fields) != expected {
+		return nil, fmt.Errorf("expected %s to have %d fields, got %d", structure.Name(), expected, len(structure.Fields))
+	}
+	return structure.Fields, nil
+}
+
+func hydrateDateTime(structure *Structure, utc bool) (Value, error) {
+	if len(structure.Fields) != 3 {
+		return nil, fmt.Errorf("expected %s to have 3 fields, got %d", structure.Name(), len(structure.Fields))
+	}
+	seconds, err := integerField(structure, structure.Fields[0])
+	if err != nil {
+		return nil, err
+	}
+	nanoseconds, err := integerField(structure, structure.Fields[1])
+	if err != nil {
+		return nil, err
+	}
+	if structure.TagByte == legacyDateTimeTag || structure.TagByte == dateTimeTag {
+		offset, err := integerField(structure, structure.Fields[2])
+		if err != nil {
+			return nil, err
+		}
+		if !utc {
+			seconds -= offset
+		}
+		zone := time.FixedZone("", int(offset))
+		return &DateTime{Time: time.Unix(seconds, nanoseconds).In(zone)}, nil
+	}
+	zoneId, err := stringField(structure, structure.Fields[2])
+	if err != nil {
+		return nil, err
+	}
+	location, err := time.LoadLocation(zoneId)
+	if err != nil {
+		return nil, fmt.Errorf("could not load time zone %q: %w", zoneId, err)
+	}
+	if utc {
+		return &DateTime{Time: time.Unix(seconds, nanoseconds).In(location), ZoneId: zoneId}, nil
+	}
+	wallClock := time.Unix(seconds, nanoseconds).UTC()
+	return &DateTime{
+		Time: time.Date(wallClock.Year(), wallClock.Month(), wallClock.Day(),
+			wallClock.Hour(), wallClock.Minute(), wallClock.Second(), wallClock.Nanosecond(),
+			location),
+		ZoneId: zoneId,
+	}, nil
+}
+
+func integerField(structure *Structure, field Value) (int64, error) {
+	result, casted := field.(Integer)
+	if !casted {
+		return 0, fmt.Errorf("expected integer field in %s, got %v", structure.Name(), field)
+	}
+	return int64(result), nil
+}
+
+func stringField(structure *Structure, field Value) (string, error) {
+	result, casted := field.(*String)
+	if !casted {
+		return "", fmt.Errorf("expected string field in %s, got %v", structure.Name(), field)
+	}
+	return string(*result), nil
+}
+
+func stringListField(structure *Structure, field Value) ([]string, error) {
+	list, casted := field.(*List)
+	if !casted {
+		return nil, fmt.Errorf("expected list field in %s, got %v", structure.Name(), field)
+	}
+	result := make([]string, len(*list))
+	for i, element := range *list {
+		str, err := stringField(structure, element)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = str
+	}
+	return result, nil
+}
+
+func dictionaryField(structure *Structure, field Value) (*OrderedDictionary, error) {
+	result, casted := field.(*OrderedDictionary)
+	if !casted {
+		return nil, fmt.Errorf("expected dictionary field in %s, got %v", structure.Name(), field)
+	}
+	return result, nil
+}
+
+type Node struct {
+	Id         int64
+	ElementId  string
+	Labels     []string
+	Properties *OrderedDictionary
+}
+
+func (n *Node) Pack() []byte {
+	labels := make(List, len(n.Labels))
+	for i, label := range n.Labels {
+		labelValue := String(label)
+		labels[i] = &labelValue
+	}
+	fields := []Value{Integer(n.Id), &labels, n.properties()}
+	if n.ElementId != "" {
+		elementId := String(n.ElementId)
+		fields = append(fields, &elementId)
+	}
+	structure := Structure{TagByte: nodeTag, Fields: fields}
+	return structure.Pack()
+}
+
+func (n *Node) String() string {
+	builder := strings.Builder{}
+	builder.WriteString("(")
+	for _, label := range n.Labels {
+		builder.WriteString(":")
+		builder.WriteString(label)
+	}
+	builder.WriteString(" ")
+	builder.WriteString(n.properties().String())
+	builder.WriteString(")")
+	return builder.String()
+}
+
+func (n *Node) properties() *OrderedDictionary {
+	if n.Properties == nil {
+		return &OrderedDictionary{}
+	}
+	return n.Properties
+}
+
+type Relationship struct {
+	Id             int64
+	ElementId      string
+	StartId        int64
+	StartElementId string
+	EndId          int64
+	EndElementId   string
+	Type           string
+	Properties     *OrderedDictionary
+}
+
+func (r *Relationship) Pack() []byte {
+	relType := String(r.Type)
+	fields := []Value{Integer(r.Id), Integer(r.StartId), Integer(r.EndId), &relType, r.properties()}
+	if r.ElementId != "" {
+		elementId := String(r.ElementId)
+		startElementId := String(r.StartElementId)
+		endElementId := String(r.EndElementId)
+		fields = append(fields, &elementId, &startElementId, &endElementId)
+	}
+	structure := Structure{TagByte: relationshipTag, Fields: fields}
+	return structure.Pack()
+}
+
+func (r *Relationship) String() string {
+	return fmt.Sprintf("[:%s %s]", r.Type, r.properties().String())
+}
+
+func (r *Relationship) properties() *OrderedDictionary {
+	if r.Properties == nil {
+		return &OrderedDictionary{}
+	}
+	return r.Properties
+}
+
+type UnboundRelationship struct {
+	Id         int64
+	ElementId  string
+	Type       string
+	Properties *OrderedDictionary
+}
+
+func (r *UnboundRelationship) Pack() []byte {
+	relType := String(r.Type)
+	fields := []Value{Integer(r.Id), &relType, r.properties()}
+	if r.ElementId != "" {
+		elementId := String(r.ElementId)
+		fields = append(fields, &elementId)
+	}
+	structure := Structure{TagByte: unboundRelationshipTag, Fields: fields}
+	return structure.Pack()
+}
+
+func (r *UnboundRelationship) String() string {
+	return fmt.Sprintf("[:%s %s]", r.Type, r.properties().String())
+}
+
+func (r *UnboundRelationship) properties() *OrderedDictionary {
+	if r.Properties == nil {
+		return &OrderedDictionary{}
+	}
+	return r.Properties
+}
+
+// DateTime is a point in time with either a fixed offset or, when ZoneId is
+// set, a named time zone
+type DateTime struct {
+	Time   time.Time
+	ZoneId string
+}
+
+// Pack encodes the date time with the UTC-based layout of Bolt 5+, see
+// Hydrator.Dehydrate for the legacy layout
+func (d *DateTime) Pack() []byte {
+	return d.toStructure(true).Pack()
+}
+
+func (d *DateTime) String() string {
+	if d.ZoneId != "" {
+		return fmt.Sprintf("%s[%s]", d.Time.Format(time.RFC3339Nano), d.ZoneId)
+	}
+	return d.Time.Format(time.RFC3339Nano)
+}
+
+func (d *DateTime) toStructure(utc bool) *Structure {
+	seconds := d.Time.Unix()
+	_, offset := d.Time.Zone()
+	if !utc {
+		seconds += int64(offset)
+	}
+	nanoseconds := Integer(d.Time.Nanosecond())
+	if d.ZoneId != "" {
+		zoneId := String(d.ZoneId)
+		tag := legacyDateTimeZoneIdTag
+		if utc {
+			tag = dateTimeZoneIdTag
+		}
+		return &Structure{TagByte: tag, Fields: []Value{Integer(seconds), nanoseconds, &zoneId}}
+	}
+	tag := legacyDateTimeTag
+	if utc {
+		tag = dateTimeTag
+	}
+	return &Structure{TagByte: tag, Fields: []Value{Integer(seconds), nanoseconds, Integer(offset)}}
+}
@@ -0,0 +1,128 @@
+package packstream_test
+
+import (
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	. "github.com/onsi/gomega"
+	"testing"
+	"time"
+)
+
+func TestHydrateNode(t *testing.T) {
+	RegisterTestingT(t)
+
+	label := packstream.String("Person")
+	name := packstream.String("Jane")
+	elementId := packstream.String("4:abc:1")
+	properties := packstream.NewOrderedDictionary("name", &name)
+
+	t.Run("without element ID before Bolt 5", func(t *testing.T) {
+		structure := &packstream.Structure{
+			TagByte: 0x4E,
+			Fields:  []packstream.Value{packstream.Integer(1), &packstream.List{&label}, properties},
+		}
+
+		node, err := packstream.NewHydrator(4, 4, nil).Hydrate(structure)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(node).To(Equal(&packstream.Node{
+			Id:         1,
+			Labels:     []string{"Person"},
+			Properties: properties,
+		}))
+	})
+
+	t.Run("with element ID since Bolt 5", func(t *testing.T) {
+		structure := &packstream.Structure{
+			TagByte: 0x4E,
+			Fields:  []packstream.Value{packstream.Integer(1), &packstream.List{&label}, properties, &elementId},
+		}
+
+		node, err := packstream.NewHydrator(5, 0, nil).Hydrate(structure)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(node).To(Equal(&packstream.Node{
+			Id:         1,
+			ElementId:  "4:abc:1",
+			Labels:     []string{"Person"},
+			Properties: properties,
+		}))
+	})
+
+	t.Run("with missing element ID since Bolt 5", func(t *testing.T) {
+		structure := &packstream.Structure{
+			TagByte: 0x4E,
+			Fields:  []packstream.Value{packstream.Integer(1), &packstream.List{&label}, properties},
+		}
+
+		_, err := packstream.NewHydrator(5, 0, nil).Hydrate(structure)
+
+		Expect(err).To(MatchError("expected NODE to have 4 fields, got 3"))
+	})
+}
+
+func TestHydrateRelationship(t *testing.T) {
+	RegisterTestingT(t)
+
+	relType := packstream.String("KNOWS")
+	elementId := packstream.String("5:abc:3")
+	startElementId := packstream.String("4:abc:1")
+	endElementId := packstream.String("4:abc:2")
+	structure := &packstream.Structure{
+		TagByte: 0x52,
+		Fields: []packstream.Value{
+			packstream.Integer(3), packstream.Integer(1), packstream.Integer(2), &relType, packstream.NewOrderedDictionary(),
+			&elementId, &startElementId, &endElementId,
+		},
+	}
+
+	relationship, err := packstream.NewHydrator(5, 4, nil).Hydrate(&packstream.List{structure})
+
+	Expect(err).NotTo(HaveOccurred())
+	Expect(relationship).To(Equal(&packstream.List{&packstream.Relationship{
+		Id:             3,
+		ElementId:      "5:abc:3",
+		StartId:        1,
+		StartElementId: "4:abc:1",
+		EndId:          2,
+		EndElementId:   "4:abc:2",
+		Type:           "KNOWS",
+		Properties:     packstream.NewOrderedDictionary(),
+	}}))
+}
+
+func TestHydrateDateTime(t *testing.T) {
+	RegisterTestingT(t)
+
+	zone := time.FixedZone("", 2*60*60)
+	expected := &packstream.DateTime{Time: time.Date(2021, 3, 4, 12, 0, 0, 42, zone)}
+	utcSeconds := expected.Time.Unix()
+	localSeconds := utcSeconds + 2*60*60
+
+	t.Run("with local seconds before Bolt 5", func(t *testing.T) {
+		structure := &packstream.Structure{
+			TagByte: 0x46,
+			Fields:  []packstream.Value{packstream.Integer(localSeconds), packstream.Integer(42), packstream.Integer(7200)},
+		}
+		hydrator := packstream.NewHydrator(4, 4, nil)
+
+		dateTime, err := hydrator.Hydrate(structure)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dateTime.(*packstream.DateTime).Time.Equal(expected.Time)).To(BeTrue())
+		Expect(hydrator.Dehydrate(dateTime)).To(Equal(structure))
+	})
+
+	t.Run("with UTC seconds since Bolt 5", func(t *testing.T) {
+		structure := &packstream.Structure{
+			TagByte: 0x49,
+			Fields:  []packstream.Value{packstream.Integer(utcSeconds), packstream.Integer(42), packstream.Integer(7200)},
+		}
+		hydrator := packstream.NewHydrator(5, 0, nil)
+
+		dateTime, err := hydrator.Hydrate(structure)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dateTime.(*packstream.DateTime).Time.Equal(expected.Time)).To(BeTrue())
+		Expect(hydrator.Dehydrate(dateTime)).To(Equal(structure))
+	})
+}
@@ -0,0 +1,127 @@
+package packstream
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DuplicateKeyPolicy decides what decoding does with keys occurring more than
+// once in a dictionary
+type DuplicateKeyPolicy int
+
+const (
+	// DuplicateKeysLastWins keeps the last value of the key, at the position
+	// of its first occurrence
+	DuplicateKeysLastWins DuplicateKeyPolicy = iota
+	// DuplicateKeysError fails decoding with a ProtocolError
+	DuplicateKeysError
+	// DuplicateKeysCollect keeps every occurrence of the key, see
+	// OrderedDictionary.GetAll
+	DuplicateKeysCollect
+)
+
+type DictionaryEntry struct {
+	Key   string
+	Value Value
+}
+
+// OrderedDictionary is the dictionary decoding produces, which keeps the
+// entries in wire order
+type OrderedDictionary struct {
+	Entries []DictionaryEntry
+}
+
+// NewOrderedDictionary creates a dictionary from alternating keys and values
+func NewOrderedDictionary(keyValuePairs ...interface{}) *OrderedDictionary {
+	result := &OrderedDictionary{Entries: make([]DictionaryEntry, 0, len(keyValuePairs)/2)}
+	for i := 0; i+1 < len(keyValuePairs); i += 2 {
+		result.Set(keyValuePairs[i].(string), keyValuePairs[i+1].(Value))
+	}
+	return result
+}
+
+func (d *OrderedDictionary) Pack() []byte {
+	result := sizeHeader(len(d.Entries), 0xA0, 0xD8)
+	for _, entry := range d.Entries {
+		key := String(entry.Key)
+		result = append(result, key.Pack()...)
+		result = append(result, entry.Value.Pack()...)
+	}
+	return result
+}
+
+func (d *OrderedDictionary) String() string {
+	str := strings.Builder{}
+	str.WriteString("{")
+	for _, entry := range d.Entries {
+		str.WriteString(fmt.Sprintf("\t%q: %s,", entry.Key, entry.Value.String()))
+	}
+	str.WriteString("}")
+	return str.String()
+}
+
+// Len returns the number of entries, duplicate keys included
+func (d *OrderedDictionary) Len() int {
+	return len(d.Entries)
+}
+
+// Get returns the last value associated to the key, or nil if there is none
+func (d *OrderedDictionary) Get(key string) Value {
+	if index := d.lastIndex(key); index >= 0 {
+		return d.Entries[index].Value
+	}
+	return nil
+}
+
+// GetAll returns the values associated to the key, in wire order, which are
+// several only when duplicate keys are collected
+func (d *OrderedDictionary) GetAll(key string) []Value {
+	var result []Value
+	for _, entry := range d.Entries {
+		if entry.Key == key {
+			result = append(result, entry.Value)
+		}
+	}
+	return result
+}
+
+// Set replaces the last value associated to the key, or adds the entry at
+// the end
+func (d *OrderedDictionary) Set(key string, value Value) {
+	if index := d.lastIndex(key); index >= 0 {
+		d.Entries[index].Value = value
+		return
+	}
+	d.Entries = append(d.Entries, DictionaryEntry{Key: key, Value: value})
+}
+
+// Keys returns the distinct keys, in the order of their first occurrence
+func (d *OrderedDictionary) Keys() []string {
+	result := make([]string, 0, len(d.Entries))
+	seen := make(map[string]struct{}, len(d.Entries))
+	for _, entry := range d.Entries {
+		if _, found := seen[entry.Key]; !found {
+			seen[entry.Key] = struct{}{}
+			result = append(result, entry.Key)
+		}
+	}
+	return result
+}
+
+// AsMap returns the dictionary as a map holding the last value of each key
+func (d *OrderedDictionary) AsMap() map[string]Value {
+	result := make(map[string]Value, len(d.Entries))
+	for _, entry := range d.Entries {
+		result[entry.Key] = entry.Value
+	}
+	return result
+}
+
+func (d *OrderedDictionary) lastIndex(key string) int {
+	for i := len(d.Entries) - 1; i >= 0; i-- {
+		if d.Entries[i].Key == key {
+			return i
+		}
+	}
+	return -1
+}
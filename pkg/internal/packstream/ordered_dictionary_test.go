@@ -0,0 +1,45 @@
+package packstream_test
+
+import (
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestOrderedDictionary(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("packs entries in insertion order", func(t *testing.T) {
+		dictionary := packstream.NewOrderedDictionary("b", packstream.Integer(1), "a", packstream.Integer(2))
+
+		Expect(dictionary.Pack()).To(Equal([]byte{0xA2, 0x81, 'b', 0x01, 0x81, 'a', 0x02}))
+		Expect(dictionary.Keys()).To(Equal([]string{"b", "a"}))
+	})
+
+	t.Run("replaces the value of existing keys", func(t *testing.T) {
+		dictionary := packstream.NewOrderedDictionary("b", packstream.Integer(1), "a", packstream.Integer(2))
+
+		dictionary.Set("b", packstream.Integer(3))
+
+		Expect(dictionary.Keys()).To(Equal([]string{"b", "a"}))
+		Expect(dictionary.Get("b")).To(Equal(packstream.Integer(3)))
+		Expect(dictionary.Get("c")).To(BeNil())
+	})
+
+	t.Run("exposes duplicate keys", func(t *testing.T) {
+		dictionary := &packstream.OrderedDictionary{Entries: []packstream.DictionaryEntry{
+			{Key: "k", Value: packstream.Integer(1)},
+			{Key: "other", Value: packstream.Integer(2)},
+			{Key: "k", Value: packstream.Integer(3)},
+		}}
+
+		Expect(dictionary.Len()).To(Equal(3))
+		Expect(dictionary.Keys()).To(Equal([]string{"k", "other"}))
+		Expect(dictionary.Get("k")).To(Equal(packstream.Integer(3)))
+		Expect(dictionary.GetAll("k")).To(Equal([]packstream.Value{packstream.Integer(1), packstream.Integer(3)}))
+		Expect(dictionary.AsMap()).To(Equal(map[string]packstream.Value{
+			"k":     packstream.Integer(3),
+			"other": packstream.Integer(2),
+		}))
+	})
+}
@@ -2,7 +2,6 @@ package packstream
 
 import (
 	"fmt"
-	"github.com/fbiville/go-usain-go/pkg/internal/slices"
 	"math"
 	"sort"
 	"strings"
@@ -68,13 +67,37 @@ func (i Integer) String() string {
 	return fmt.Sprintf("%d", i)
 }
 
+type Boolean bool
+
+func (b Boolean) Pack() []byte {
+	if b {
+		return []byte{0xC3}
+	}
+	return []byte{0xC2}
+}
+
+func (b Boolean) String() string {
+	return fmt.Sprintf("%t", bool(b))
+}
+
+type Float float64
+
+func (f Float) Pack() []byte {
+	result := make([]byte, 9)
+	result[0] = 0xC1
+	Endianness.PutUint64(result[1:], math.Float64bits(float64(f)))
+	return result
+}
+
+func (f Float) String() string {
+	return fmt.Sprintf("%v", float64(f))
+}
+
 type String string
 
 func (s *String) Pack() []byte {
-	str := string(*s)
-	marker := 0x80 + byte(len(str))
-	stringBytes := []byte(str)
-	return append([]byte{marker}, stringBytes...)
+	stringBytes := []byte(*s)
+	return append(sizeHeader(len(stringBytes), 0x80, 0xD0), stringBytes...)
 }
 
 func (s *String) String() string {
@@ -84,12 +107,11 @@ func (s *String) String() string {
 type List []Value
 
 func (l *List) Pack() []byte {
-	marker := byte(0x90 + len(*l)) // FIXME: does not support max value range
-	var result []byte
+	result := sizeHeader(len(*l), 0x90, 0xD4)
 	for _, value := range *l {
 		result = append(result, value.Pack()...)
 	}
-	return slices.PrependByte(marker, result)
+	return result
 }
 
 func (l *List) String() string {
@@ -109,8 +131,7 @@ func (l *List) String() string {
 type Dictionary map[string][]Value
 
 func (d *Dictionary) Pack() []byte {
-	var result []byte
-	marker := 0xA0 + d.Length()
+	result := sizeHeader(d.Length(), 0xA0, 0xD8)
 	dictionary := d.asMap()
 	keys := d.sortedKeys(dictionary)
 	for _, keyName := range keys {
@@ -126,7 +147,7 @@ func (d *Dictionary) Pack() []byte {
 			}
 		}
 	}
-	return slices.PrependByte(byte(marker), result)
+	return result
 }
 
 func (d *Dictionary) Length() int {
@@ -156,6 +177,15 @@ func (d *Dictionary) String() string {
 	return str.String()
 }
 
+// Get returns the first value associated to the key, or nil if there is none
+func (d *Dictionary) Get(key string) Value {
+	values := d.asMap()[key]
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
 func (d *Dictionary) asMap() map[string][]Value {
 	return *d
 }
@@ -169,6 +199,28 @@ func (d *Dictionary) sortedKeys(dictionary map[string][]Value) []string {
 	return keys
 }
 
+// sizeHeader encodes the marker and size of strings, lists and dictionaries:
+// sizes up to 15 fit in the tiny marker, larger ones follow the 8-bit size
+// marker (or the next 2 markers for 16-bit and 32-bit sizes)
+func sizeHeader(size int, tinyMarker, marker8 byte) []byte {
+	switch {
+	case size <= 0x0F:
+		return []byte{tinyMarker + byte(size)}
+	case size <= math.MaxUint8:
+		return []byte{marker8, byte(size)}
+	case size <= math.MaxUint16:
+		result := make([]byte, 3)
+		result[0] = marker8 + 1
+		Endianness.PutUint16(result[1:], uint16(size))
+		return result
+	default:
+		result := make([]byte, 5)
+		result[0] = marker8 + 2
+		Endianness.PutUint32(result[1:], uint32(size))
+		return result
+	}
+}
+
 type Structure struct {
 	TagByte byte
 	Fields  []Value
@@ -186,12 +238,12 @@ func (s *Structure) Pack() []byte {
 }
 
 func (s *Structure) Name() string {
-	return structureNames()[s.TagByte]
+	return structureNames[s.TagByte]
 }
 
 func (s *Structure) String() string {
 	name := fmt.Sprintf("<%d>", s.TagByte)
-	if friendlyName, found := structureNames()[s.TagByte]; found {
+	if friendlyName, found := structureNames[s.TagByte]; found {
 		name = friendlyName
 	}
 	result := strings.Builder{}
@@ -204,33 +256,36 @@ func (s *Structure) String() string {
 	return result.String()
 }
 
-func structureNames() map[byte]string {
-	return map[byte]string{
-		0x4E: "NODE",
-		0x52: "RELATIONSHIP",
-		0x72: "UNBOUND_RELATIONSHIP",
-		0x50: "PATH",
-		0x44: "DATE",
-		0x54: "TIME",
-		0x74: "LOCALTIME",
-		0x46: "DATETIME",
-		0x66: "DATETIME_ZONE_ID",
-		0x64: "LOCAL_DATETIME",
-		0x45: "DURATION",
-		0x58: "POINT_2D",
-		0x59: "POINT_3D",
-		0x01: "HELLO",
-		0x02: "GOODBYE",
-		0x0F: "RESET",
-		0x10: "RUN",
-		0x2F: "DISCARD",
-		0x3F: "PULL",
-		0x11: "BEGIN",
-		0x12: "COMMIT",
-		0x13: "ROLLBACK",
-		0x70: "SUCCESS",
-		0x7E: "IGNORED",
-		0x7F: "FAILURE",
-		0x71: "RECORD",
-	}
+// structureNames names the structures the protocol defines
+var structureNames = map[byte]string{
+	0x4E: "NODE",
+	0x52: "RELATIONSHIP",
+	0x72: "UNBOUND_RELATIONSHIP",
+	0x50: "PATH",
+	0x44: "DATE",
+	0x54: "TIME",
+	0x74: "LOCALTIME",
+	0x46: "DATETIME",
+	0x66: "DATETIME_ZONE_ID",
+	0x49: "DATETIME_UTC",
+	0x69: "DATETIME_ZONE_ID_UTC",
+	0x64: "LOCAL_DATETIME",
+	0x45: "DURATION",
+	0x58: "POINT_2D",
+	0x59: "POINT_3D",
+	0x01: "HELLO",
+	0x02: "GOODBYE",
+	0x6A: "LOGON",
+	0x6B: "LOGOFF",
+	0x0F: "RESET",
+	0x10: "RUN",
+	0x2F: "DISCARD",
+	0x3F: "PULL",
+	0x11: "BEGIN",
+	0x12: "COMMIT",
+	0x13: "ROLLBACK",
+	0x70: "SUCCESS",
+	0x7E: "IGNORED",
+	0x7F: "FAILURE",
+	0x71: "RECORD",
 }
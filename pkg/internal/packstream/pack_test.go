@@ -1,11 +1,13 @@
 package packstream_test
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
 	"github.com/fbiville/go-usain-go/pkg/internal/slices"
 	. "github.com/onsi/gomega"
 	"math"
+	"strings"
 	"testing"
 )
 
@@ -51,6 +53,20 @@ func TestPackInteger(t *testing.T) {
 	}
 }
 
+func TestPackBoolean(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(packstream.Boolean(false).Pack()).To(Equal([]byte{0xC2}))
+	Expect(packstream.Boolean(true).Pack()).To(Equal([]byte{0xC3}))
+}
+
+func TestPackFloat(t *testing.T) {
+	RegisterTestingT(t)
+
+	Expect(packstream.Float(1.1).Pack()).To(Equal(decodeHexa("C13FF199999999999A")))
+	Expect(packstream.Float(-1.1).Pack()).To(Equal(decodeHexa("C1BFF199999999999A")))
+}
+
 func TestPackString(t *testing.T) {
 	RegisterTestingT(t)
 
@@ -61,6 +77,8 @@ func TestPackString(t *testing.T) {
 		{"", []byte{0x80}},
 		{"A", []byte{0x81, 0x41}},
 		{"plsfitin15bytes", slices.PrependByte(0x8F, bytesOf("plsfitin15bytes"))},
+		{"sixteen bytes!!!", append([]byte{0xD0, 0x10}, bytesOf("sixteen bytes!!!")...)},
+		{strings.Repeat("a", 256), append([]byte{0xD1, 0x01, 0x00}, bytesOf(strings.Repeat("a", 256))...)},
 	}
 
 	for _, testCase := range testCases {
@@ -86,12 +104,14 @@ func TestPackList(t *testing.T) {
 		{[]packstream.Value{}, []byte{0x90}},
 		{[]packstream.Value{&value1}, []byte{0x91, 0x81, 0x41}},
 		{[]packstream.Value{&value2, &value1}, []byte{0x92, 0x01, 0x81, 0x41}},
+		{integers(16), append([]byte{0xD4, 0x10}, bytes.Repeat([]byte{0x01}, 16)...)},
+		{integers(256), append([]byte{0xD5, 0x01, 0x00}, bytes.Repeat([]byte{0x01}, 256)...)},
 	}
 
 	for _, testCase := range testCases {
 		input := testCase.input
 		result := testCase.result
-		t.Run(fmt.Sprintf("%q string should be packed", input), func(t *testing.T) {
+		t.Run(fmt.Sprintf("list of size %d should be packed", len(input)), func(t *testing.T) {
 			list := packstream.List(input)
 			Expect(list.Pack()).To(Equal(result))
 		})
@@ -109,6 +129,7 @@ func TestPackDictionary(t *testing.T) {
 		{dictionary(), []byte{0xA0}},
 		{dictionary("one", &value), []byte{0xA1, 0x83, 0x6F, 0x6E, 0x65, 0x84, 0x65, 0x69, 0x6E, 0x73}},
 		{nilValueDictionary("one"), []byte{0xA1, 0x83, 0x6F, 0x6E, 0x65, 0xC0}},
+		{sizedDictionary(16), append([]byte{0xD8, 0x10}, sizedDictionaryEntries(16)...)},
 	}
 
 	for _, testCase := range testCases {
@@ -167,6 +188,31 @@ func nilValueDictionary(key string) *packstream.Dictionary {
 	return &result
 }
 
+func integers(count int) []packstream.Value {
+	result := make([]packstream.Value, count)
+	for i := range result {
+		result[i] = packstream.Integer(1)
+	}
+	return result
+}
+
+// sizedDictionary maps keys "a", "b", ... to 1
+func sizedDictionary(size int) *packstream.Dictionary {
+	result := packstream.Dictionary{}
+	for i := 0; i < size; i++ {
+		result[string(rune('a'+i))] = []packstream.Value{packstream.Integer(1)}
+	}
+	return &result
+}
+
+func sizedDictionaryEntries(size int) []byte {
+	var result []byte
+	for i := 0; i < size; i++ {
+		result = append(result, 0x81, byte('a'+i), 0x01)
+	}
+	return result
+}
+
 func bytesOf(s string) []byte {
 	return []byte(s)
 }
@@ -0,0 +1,148 @@
+package packstream
+
+import (
+	"fmt"
+	"sync"
+)
+
+// HydrateFunc turns a raw structure into a typed value
+type HydrateFunc func(*Structure) (Value, error)
+
+// DehydrateFunc turns a typed value back into its structure, and reports
+// whether it knows about the value
+type DehydrateFunc func(Value) (*Structure, bool)
+
+// ProtocolVersion identifies the Bolt protocol version a registry applies to
+type ProtocolVersion struct {
+	Major byte
+	Minor byte
+}
+
+// StructureRegistry holds user-defined structures, on top of the structures
+// the protocol defines
+type StructureRegistry struct {
+	mutex      sync.RWMutex
+	structures map[byte]registeredStructure
+	// order keeps the registration order, in which dehydrate functions are
+	// tried
+	order []byte
+}
+
+type registeredStructure struct {
+	name      string
+	hydrate   HydrateFunc
+	dehydrate DehydrateFunc
+}
+
+func NewStructureRegistry() *StructureRegistry {
+	return &StructureRegistry{structures: make(map[byte]registeredStructure)}
+}
+
+// RegisterStructure registers the structure of the tag, which must be neither
+// defined by the protocol nor already registered. dehydrate may be nil for
+// structures that are only ever received.
+func (r *StructureRegistry) RegisterStructure(tag byte, name string, hydrate HydrateFunc, dehydrate DehydrateFunc) error {
+	if hydrate == nil {
+		return fmt.Errorf("structure %s (tag %X) needs a hydrate function", name, tag)
+	}
+	if builtInName, found := structureNames[tag]; found {
+		return fmt.Errorf("tag %X is already used by the %s structure of the protocol", tag, builtInName)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if existing, found := r.structures[tag]; found {
+		return fmt.Errorf("tag %X is already registered for the %s structure", tag, existing.name)
+	}
+	r.structures[tag] = registeredStructure{name: name, hydrate: hydrate, dehydrate: dehydrate}
+	r.order = append(r.order, tag)
+	return nil
+}
+
+// Name returns the name the structure of the tag was registered with
+func (r *StructureRegistry) Name(tag byte) (string, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	structure, found := r.structures[tag]
+	return structure.name, found
+}
+
+func (r *StructureRegistry) hydrate(structure *Structure) (Value, bool, error) {
+	r.mutex.RLock()
+	registered, found := r.structures[structure.TagByte]
+	r.mutex.RUnlock()
+	if !found {
+		return nil, false, nil
+	}
+	result, err := registered.hydrate(structure)
+	if err != nil {
+		return nil, true, fmt.Errorf("could not hydrate %s: %w", registered.name, err)
+	}
+	return result, true, nil
+}
+
+func (r *StructureRegistry) dehydrate(value Value) (*Structure, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, tag := range r.order {
+		dehydrate := r.structures[tag].dehydrate
+		if dehydrate == nil {
+			continue
+		}
+		if result, known := dehydrate(value); known {
+			return result, true
+		}
+	}
+	return nil, false
+}
+
+// Structures holds the user-defined structures of a driver, registered for
+// all protocol versions or for specific ones
+type Structures struct {
+	mutex     sync.Mutex
+	shared    *StructureRegistry
+	byVersion map[ProtocolVersion]*StructureRegistry
+}
+
+func NewStructures() *Structures {
+	return &Structures{
+		shared:    NewStructureRegistry(),
+		byVersion: make(map[ProtocolVersion]*StructureRegistry),
+	}
+}
+
+// Register registers the structure for the given protocol versions, or for
+// all of them when none is given. Structures registered for a version take
+// precedence over the ones registered for all versions.
+func (s *Structures) Register(tag byte, name string, hydrate HydrateFunc, dehydrate DehydrateFunc, versions ...ProtocolVersion) error {
+	if len(versions) == 0 {
+		return s.shared.RegisterStructure(tag, name, hydrate, dehydrate)
+	}
+	for _, version := range versions {
+		if err := s.Version(version).RegisterStructure(tag, name, hydrate, dehydrate); err != nil {
+			return fmt.Errorf("could not register structure for protocol version %d.%d: %w", version.Major, version.Minor, err)
+		}
+	}
+	return nil
+}
+
+// Version returns the registry of the structures specific to the protocol
+// version
+func (s *Structures) Version(version ProtocolVersion) *StructureRegistry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	registry, found := s.byVersion[version]
+	if !found {
+		registry = NewStructureRegistry()
+		s.byVersion[version] = registry
+	}
+	return registry
+}
+
+// registries returns the registries applying to the protocol version, by
+// order of precedence
+func (s *Structures) registries(version ProtocolVersion) []*StructureRegistry {
+	if s == nil {
+		return nil
+	}
+	return []*StructureRegistry{s.Version(version), s.shared}
+}
@@ -0,0 +1,128 @@
+package packstream_test
+
+import (
+	"errors"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+// vector stands for a user-defined structure
+type vector []float64
+
+func (v vector) Pack() []byte {
+	structure, _ := dehydrateVector(v)
+	return structure.Pack()
+}
+
+func (v vector) String() string {
+	return "vector"
+}
+
+const vectorTag byte = 0x56
+
+func hydrateVector(structure *packstream.Structure) (packstream.Value, error) {
+	result := vector{}
+	for _, field := range structure.Fields {
+		component, casted := field.(packstream.Float)
+		if !casted {
+			return nil, errors.New("expected float components")
+		}
+		result = append(result, float64(component))
+	}
+	return result, nil
+}
+
+func dehydrateVector(value packstream.Value) (*packstream.Structure, bool) {
+	v, casted := value.(vector)
+	if !casted {
+		return nil, false
+	}
+	result := &packstream.Structure{TagByte: vectorTag}
+	for _, component := range v {
+		result.Fields = append(result.Fields, packstream.Float(component))
+	}
+	return result, true
+}
+
+func TestStructureRegistry(t *testing.T) {
+	RegisterTestingT(t)
+
+	version := packstream.ProtocolVersion{Major: 6, Minor: 0}
+	structures := packstream.NewStructures()
+	Expect(structures.Register(vectorTag, "VECTOR", hydrateVector, dehydrateVector, version)).To(Succeed())
+	Expect(structures.Register(0x57, "WRAPPED", func(structure *packstream.Structure) (packstream.Value, error) {
+		return structure.Fields[0], nil
+	}, nil)).To(Succeed())
+
+	t.Run("hydrates registered structures of the protocol version", func(t *testing.T) {
+		raw := &packstream.List{&packstream.Structure{TagByte: vectorTag, Fields: []packstream.Value{packstream.Float(1), packstream.Float(2)}}}
+
+		value, err := packstream.NewHydrator(6, 0, structures).Hydrate(raw)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value).To(Equal(&packstream.List{vector{1, 2}}))
+	})
+
+	t.Run("leaves structures registered for other protocol versions as is", func(t *testing.T) {
+		raw := &packstream.Structure{TagByte: vectorTag, Fields: []packstream.Value{packstream.Float(1)}}
+
+		value, err := packstream.NewHydrator(5, 4, structures).Hydrate(raw)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value).To(Equal(raw))
+	})
+
+	t.Run("surfaces hydration errors", func(t *testing.T) {
+		raw := &packstream.Structure{TagByte: vectorTag, Fields: []packstream.Value{packstream.Integer(1)}}
+
+		_, err := packstream.NewHydrator(6, 0, structures).Hydrate(raw)
+
+		Expect(err).To(MatchError("could not hydrate VECTOR: expected float components"))
+	})
+
+	t.Run("dehydrates registered values", func(t *testing.T) {
+		value := packstream.NewHydrator(6, 0, structures).Dehydrate(&packstream.List{vector{3}})
+
+		Expect(value).To(Equal(&packstream.List{&packstream.Structure{TagByte: vectorTag, Fields: []packstream.Value{packstream.Float(3)}}}))
+	})
+
+	t.Run("hydrates structures registered for all protocol versions", func(t *testing.T) {
+		for _, hydrator := range []*packstream.Hydrator{packstream.NewHydrator(4, 4, structures), packstream.NewHydrator(6, 0, structures)} {
+			value, err := hydrator.Hydrate(&packstream.Structure{TagByte: 0x57, Fields: []packstream.Value{packstream.Integer(42)}})
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(value).To(Equal(packstream.Integer(42)))
+		}
+	})
+
+	t.Run("rejects tags defined by the protocol", func(t *testing.T) {
+		err := structures.Register(0x4E, "MY_NODE", hydrateVector, nil, version)
+
+		Expect(err).To(MatchError("could not register structure for protocol version 6.0: tag 4E is already used by the NODE structure of the protocol"))
+	})
+
+	t.Run("rejects tags registered twice", func(t *testing.T) {
+		err := structures.Version(version).RegisterStructure(vectorTag, "OTHER_VECTOR", hydrateVector, nil)
+
+		Expect(err).To(MatchError("tag 56 is already registered for the VECTOR structure"))
+	})
+
+	t.Run("leaves structures registered with other drivers as is", func(t *testing.T) {
+		raw := &packstream.Structure{TagByte: vectorTag, Fields: []packstream.Value{packstream.Float(1)}}
+
+		value, err := packstream.NewHydrator(6, 0, packstream.NewStructures()).Hydrate(raw)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(value).To(Equal(raw))
+	})
+
+	t.Run("keeps registries apart", func(t *testing.T) {
+		registry := packstream.NewStructureRegistry()
+
+		Expect(registry.RegisterStructure(vectorTag, "OTHER_VECTOR", hydrateVector, nil)).To(Succeed())
+		name, found := registry.Name(vectorTag)
+		Expect(found).To(BeTrue())
+		Expect(name).To(Equal("OTHER_VECTOR"))
+	})
+}
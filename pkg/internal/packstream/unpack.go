@@ -3,37 +3,72 @@ package packstream
 import (
 	"encoding/binary"
 	"fmt"
-	"github.com/fbiville/go-usain-go/pkg/internal/slices"
 	"math"
 )
 
 var Endianness = binary.BigEndian
 
+// Limits bound the values the decoder accepts, zero means no limit
+type Limits struct {
+	// MaxCollectionLength bounds the number of entries of lists, dictionaries
+	// and structures
+	MaxCollectionLength int
+	// MaxDepth bounds how deeply lists, dictionaries and structures nest
+	MaxDepth int
+}
+
+// DefaultLimits keeps the nesting depth far from what would exhaust the stack
+var DefaultLimits = Limits{MaxDepth: 128}
+
 type mapperFunc func([]byte) (Value, int, error)
 
+// Decoder unpacks values within limits, failing with a ProtocolError when a
+// value exceeds them
+type Decoder struct {
+	Limits        Limits
+	DuplicateKeys DuplicateKeyPolicy
+}
+
 func UnpackValue(bytes []byte) (Value, int, error) {
+	return Decoder{Limits: DefaultLimits}.Unpack(bytes)
+}
+
+func (d Decoder) Unpack(bytes []byte) (Value, int, error) {
+	return (&decoder{limits: d.Limits, duplicateKeys: d.DuplicateKeys}).unpack(bytes)
+}
+
+// decoder tracks the current nesting depth while unpacking a value
+type decoder struct {
+	limits        Limits
+	duplicateKeys DuplicateKeyPolicy
+	depth         int
+}
+
+func (d *decoder) unpack(bytes []byte) (Value, int, error) {
 	if len(bytes) == 0 {
 		return nil, 0, fmt.Errorf("data to unpack must be at 1 byte long")
 	}
-	return mapper(bytes[0])(bytes)
+	return d.mapper(bytes[0])(bytes)
 }
 
-func mapper(marker byte) mapperFunc {
+func (d *decoder) mapper(marker byte) mapperFunc {
 	switch {
 	case marker <= 0x7F || 0xC8 <= marker && marker <= 0xCB || 0xF0 <= marker:
 		return unpackInteger
 	case 0x80 <= marker && marker <= 0x8F || 0xD0 <= marker && marker <= 0xD2:
 		return unpackString
-	case 0x90 <= marker && marker <= 0x9F: // FIXME support large lists
-		return unpackList
-	case 0xA0 <= marker && marker <= 0xAF: // FIXME support large dictionaries
-		return unpackDictionary
-	case 0xB0 <= marker && marker <= 0xBF: // FIXME support large structures
-		return unpackStructure
+	case 0x90 <= marker && marker <= 0x9F || 0xD4 <= marker && marker <= 0xD6:
+		return d.unpackList
+	case 0xA0 <= marker && marker <= 0xAF || 0xD8 <= marker && marker <= 0xDA:
+		return d.unpackDictionary
+	case 0xB0 <= marker && marker <= 0xBF:
+		return d.unpackStructure
 	case 0xC0 == marker:
 		return unpackNil
-	// FIXME support booleans
-	// FIXME support float
+	case 0xC2 == marker || 0xC3 == marker:
+		return unpackBoolean
+	case 0xC1 == marker:
+		return unpackFloat
 	default:
 		return unsupportedMarkerFunc()
 	}
@@ -45,50 +80,89 @@ func unsupportedMarkerFunc() mapperFunc {
 	}
 }
 
-func unpackStructure(bytes []byte) (Value, int, error) {
-	fieldCount := bytes[0] - 0xB0
+// enter checks the limits before unpacking the entries of a collection, the
+// returned function must be called once they are unpacked
+func (d *decoder) enter(kind string, length int) (func(), error) {
+	if d.limits.MaxCollectionLength > 0 && length > d.limits.MaxCollectionLength {
+		return nil, newProtocolError("%s of %d entries exceeds the maximum of %d", kind, length, d.limits.MaxCollectionLength)
+	}
+	if d.limits.MaxDepth > 0 && d.depth >= d.limits.MaxDepth {
+		return nil, newProtocolError("%s nested deeper than the maximum of %d", kind, d.limits.MaxDepth)
+	}
+	d.depth++
+	return func() { d.depth-- }, nil
+}
+
+func (d *decoder) unpackStructure(bytes []byte) (Value, int, error) {
+	fieldCount := int(bytes[0] - 0xB0)
+	if len(bytes) < 2 {
+		return nil, len(bytes), newProtocolError("expected tag byte after structure marker")
+	}
+	leave, err := d.enter("structure", fieldCount)
+	if err != nil {
+		return nil, 1, err
+	}
+	defer leave()
 	result := Structure{TagByte: bytes[1]}
 	readBytes := 2
-	if fieldCount == 0 {
-		return &result, readBytes, nil
-	}
 	bytes = bytes[readBytes:]
-	for {
-		if len(bytes) == 0 {
-			break
-		}
-		value, n, err := UnpackValue(bytes)
+	for i := 0; i < fieldCount; i++ {
+		value, n, err := d.unpack(bytes)
+		readBytes += n
 		if err != nil {
-			return nil, readBytes, err
+			return nil, readBytes, fmt.Errorf("could not read structure field number %d: %w", i+1, err)
 		}
 		bytes = bytes[n:]
-		readBytes += n
 		result.Fields = append(result.Fields, value)
 	}
 	return &result, readBytes, nil
 }
 
-func unpackDictionary(bytes []byte) (Value, int, error) {
-	entryCount := bytes[0] - 0xA0
-	readByteCount := 1
-	payload := bytes[1:]
-	entries := make(map[string][]Value, entryCount)
-	for i := byte(0); i < entryCount; i++ {
+func (d *decoder) unpackDictionary(bytes []byte) (Value, int, error) {
+	readByteCount, entryCount, err := readContainerSize(bytes, 0xA0, 0xD8)
+	if err != nil {
+		return nil, readByteCount, err
+	}
+	// every entry takes at least two bytes
+	if entryCount > (len(bytes)-readByteCount)/2 {
+		return nil, len(bytes), newProtocolError("dictionary of %d entries exceeds the %d remaining bytes", entryCount, len(bytes)-readByteCount)
+	}
+	leave, err := d.enter("dictionary", entryCount)
+	if err != nil {
+		return nil, readByteCount, err
+	}
+	defer leave()
+	payload := bytes[readByteCount:]
+	result := OrderedDictionary{Entries: make([]DictionaryEntry, 0, entryCount)}
+	positions := make(map[string]int, entryCount)
+	for i := 0; i < entryCount; i++ {
+		if len(payload) == 0 || !isStringMarker(payload[0]) {
+			return nil, readByteCount, newProtocolError("expected dictionary key number %d to be a string", i+1)
+		}
 		rawKey, n, err := unpackString(payload)
 		if err != nil {
 			return nil, readByteCount, err
 		}
 		readByteCount += n
 		payload = payload[n:]
-		value, n, err := UnpackValue(payload)
+		value, n, err := d.unpack(payload)
 		if err != nil {
 			return nil, readByteCount, err
 		}
 		readByteCount += n
 		payload = payload[n:]
-		entries[string(*(rawKey.(*String)))] = []Value{value} // FIXME: should not overwrite previous value
+		key := string(*(rawKey.(*String)))
+		position, duplicate := positions[key]
+		switch {
+		case !duplicate || d.duplicateKeys == DuplicateKeysCollect:
+			positions[key] = len(result.Entries)
+			result.Entries = append(result.Entries, DictionaryEntry{Key: key, Value: value})
+		case d.duplicateKeys == DuplicateKeysError:
+			return nil, readByteCount, newProtocolError("duplicate dictionary key %q", key)
+		default:
+			result.Entries[position].Value = value
+		}
 	}
-	result := Dictionary(entries)
 	return &result, readByteCount, nil
 }
 
@@ -102,6 +176,9 @@ func unpackNil(bytes []byte) (Value, int, error) {
 
 func unpackInteger(bytes []byte) (Value, int, error) {
 	marker := bytes[0]
+	if size := integerSize(marker); len(bytes) < size {
+		return nil, len(bytes), newProtocolError("expected %d bytes after integer marker %X, got %d", size-1, marker, len(bytes)-1)
+	}
 	switch marker {
 	case 0xC8:
 		return Integer(tinyInt(bytes[1:2])), 2, nil
@@ -116,36 +193,57 @@ func unpackInteger(bytes []byte) (Value, int, error) {
 	}
 }
 
-func unpackString(payload []byte) (Value, int, error) {
-	offset, size := readStringSize(payload)
-	if size == 0 {
-		result := String("")
-		return &result, offset, nil
+// integerSize returns the number of bytes of the integer, marker included
+func integerSize(marker byte) int {
+	switch marker {
+	case 0xC8:
+		return 2
+	case 0xC9:
+		return 3
+	case 0xCA:
+		return 5
+	case 0xCB:
+		return 9
+	default:
+		return 1
 	}
-	end := offset + int(size) // FIXME
-	result := String(payload[offset :end])
-	return &result, end, nil
 }
 
-func readStringSize(payload []byte) (int, uint32) {
-	marker := payload[0]
-	if marker <= 0x8F {
-		return 1, uint32(marker - 0x80)
+func isStringMarker(marker byte) bool {
+	return 0x80 <= marker && marker <= 0x8F || 0xD0 <= marker && marker <= 0xD2
+}
+
+func unpackString(payload []byte) (Value, int, error) {
+	offset, size, err := readContainerSize(payload, 0x80, 0xD0)
+	if err != nil {
+		return nil, offset, err
+	}
+	end := offset + size
+	if size > len(payload)-offset {
+		return nil, len(payload), newProtocolError("string of %d bytes exceeds the %d remaining bytes", size, len(payload)-offset)
 	}
-	offset := 1 + int(math.Pow(2, float64(marker-0XD0)))
-	rawSize := slices.PadLeft(payload[1:offset], 0, 4)
-	size := Endianness.Uint32(rawSize)
-	return offset, size
+	result := String(payload[offset:end])
+	return &result, end, nil
 }
 
-func unpackList(bytes []byte) (Value, int, error) {
+func (d *decoder) unpackList(bytes []byte) (Value, int, error) {
 	var result List
-	readByteCount := 1
-	marker := bytes[0]
-	count := marker - 0x90
-	bytes = bytes[1:]
-	for i := byte(0); i < count; i++ {
-		value, n, err := UnpackValue(bytes)
+	readByteCount, count, err := readContainerSize(bytes, 0x90, 0xD4)
+	if err != nil {
+		return nil, readByteCount, err
+	}
+	// every entry takes at least a byte
+	if count > len(bytes)-readByteCount {
+		return nil, len(bytes), newProtocolError("list of %d entries exceeds the %d remaining bytes", count, len(bytes)-readByteCount)
+	}
+	leave, err := d.enter("list", count)
+	if err != nil {
+		return nil, readByteCount, err
+	}
+	defer leave()
+	bytes = bytes[readByteCount:]
+	for i := 0; i < count; i++ {
+		value, n, err := d.unpack(bytes)
 		readByteCount += n
 		if err != nil {
 			return nil, readByteCount, fmt.Errorf("could not read list entry number %d: %w", i+1, err)
@@ -156,6 +254,40 @@ func unpackList(bytes []byte) (Value, int, error) {
 	return &result, readByteCount, nil
 }
 
+// readContainerSize decodes the size of strings, lists and dictionaries,
+// which is either part of the tiny marker or follows one of the 3 sized
+// markers
+func readContainerSize(bytes []byte, tinyMarker, marker8 byte) (int, int, error) {
+	marker := bytes[0]
+	if tinyMarker <= marker && marker <= tinyMarker+0x0F {
+		return 1, int(marker - tinyMarker), nil
+	}
+	sizeLength := 1 << (marker - marker8)
+	if len(bytes) < 1+sizeLength {
+		return len(bytes), 0, newProtocolError("expected %d size bytes after marker %X, got %d", sizeLength, marker, len(bytes)-1)
+	}
+	rawSize := bytes[1 : 1+sizeLength]
+	switch sizeLength {
+	case 1:
+		return 2, int(rawSize[0]), nil
+	case 2:
+		return 3, int(Endianness.Uint16(rawSize)), nil
+	default:
+		return 5, int(Endianness.Uint32(rawSize)), nil
+	}
+}
+
+func unpackBoolean(bytes []byte) (Value, int, error) {
+	return Boolean(bytes[0] == 0xC3), 1, nil
+}
+
+func unpackFloat(bytes []byte) (Value, int, error) {
+	if len(bytes) < 9 {
+		return nil, len(bytes), newProtocolError("expected 8 bytes after float marker, got %d", len(bytes)-1)
+	}
+	return Float(math.Float64frombits(Endianness.Uint64(bytes[1:9]))), 9, nil
+}
+
 func intSixtyFour(bytes []byte) int64 {
 	return int64(Endianness.Uint64(bytes))
 }
@@ -1,7 +1,9 @@
 package packstream_test
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
 	. "github.com/onsi/gomega"
@@ -73,6 +75,29 @@ func TestUnpackInteger(t *testing.T) {
 	}
 }
 
+func TestUnpackBoolean(t *testing.T) {
+	RegisterTestingT(t)
+
+	falseValue, n, err := packstream.UnpackValue([]byte{0xC2})
+	Expect(err).NotTo(HaveOccurred())
+	Expect(n).To(Equal(1))
+	Expect(falseValue).To(Equal(packstream.Boolean(false)))
+	trueValue, n, err := packstream.UnpackValue([]byte{0xC3})
+	Expect(err).NotTo(HaveOccurred())
+	Expect(n).To(Equal(1))
+	Expect(trueValue).To(Equal(packstream.Boolean(true)))
+}
+
+func TestUnpackFloat(t *testing.T) {
+	RegisterTestingT(t)
+
+	value, n, err := packstream.UnpackValue(decodeHexa("C1BFF199999999999A"))
+
+	Expect(err).NotTo(HaveOccurred())
+	Expect(n).To(Equal(9))
+	Expect(value).To(Equal(packstream.Float(-1.1)))
+}
+
 func TestUnpackInvalidValue(t *testing.T) {
 	RegisterTestingT(t)
 
@@ -129,6 +154,9 @@ func TestUnpackList(t *testing.T) {
 	}{
 		{"90", nil},
 		{"92_81_41_C0", packstream.List([]packstream.Value{&value, packstream.NilInstance()})},
+		{"D4_02_81_41_C0", packstream.List([]packstream.Value{&value, packstream.NilInstance()})},
+		{"D5_00_02_81_41_C0", packstream.List([]packstream.Value{&value, packstream.NilInstance()})},
+		{"D6_00_00_00_02_81_41_C0", packstream.List([]packstream.Value{&value, packstream.NilInstance()})},
 	}
 
 	for _, testCase := range testCases {
@@ -153,11 +181,14 @@ func TestUnpackDictionary(t *testing.T) {
 	stringValue := packstream.String("A")
 	testCases := []struct {
 		input  []byte
-		result *packstream.Dictionary
+		result *packstream.OrderedDictionary
 	}{
-		{[]byte{0xA0}, dictionary()},
-		{[]byte{0xA1, 0x81, byte('A'), 0x81, byte('A')}, dictionary("A", &stringValue)},
-		{[]byte{0xA1, 0x81, byte('A'), 0xCA, 0, 0, 0, 0x2A}, dictionary("A", packstream.Integer(42))},
+		{[]byte{0xA0}, packstream.NewOrderedDictionary()},
+		{[]byte{0xA1, 0x81, byte('A'), 0x81, byte('A')}, packstream.NewOrderedDictionary("A", &stringValue)},
+		{[]byte{0xA1, 0x81, byte('A'), 0xCA, 0, 0, 0, 0x2A}, packstream.NewOrderedDictionary("A", packstream.Integer(42))},
+		{[]byte{0xD8, 0x01, 0x81, byte('A'), 0x81, byte('A')}, packstream.NewOrderedDictionary("A", &stringValue)},
+		{[]byte{0xD9, 0x00, 0x01, 0x81, byte('A'), 0x81, byte('A')}, packstream.NewOrderedDictionary("A", &stringValue)},
+		{[]byte{0xDA, 0x00, 0x00, 0x00, 0x01, 0x81, byte('A'), 0x81, byte('A')}, packstream.NewOrderedDictionary("A", &stringValue)},
 	}
 
 	for i, testCase := range testCases {
@@ -173,6 +204,46 @@ func TestUnpackDictionary(t *testing.T) {
 	}
 }
 
+func TestUnpackDictionaryOrder(t *testing.T) {
+	RegisterTestingT(t)
+
+	dictionary, _, err := packstream.UnpackValue([]byte{0xA3, 0x81, 'c', 1, 0x81, 'a', 2, 0x81, 'b', 3})
+
+	Expect(err).NotTo(HaveOccurred())
+	Expect(dictionary.(*packstream.OrderedDictionary).Keys()).To(Equal([]string{"c", "a", "b"}))
+}
+
+func TestUnpackDuplicateDictionaryKeys(t *testing.T) {
+	RegisterTestingT(t)
+
+	input := []byte{0xA3, 0x81, 'k', 1, 0x81, 'o', 2, 0x81, 'k', 3}
+
+	t.Run("last value wins by default", func(t *testing.T) {
+		dictionary, n, err := packstream.UnpackValue(input)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(len(input)))
+		Expect(dictionary).To(Equal(packstream.NewOrderedDictionary("k", packstream.Integer(3), "o", packstream.Integer(2))))
+	})
+
+	t.Run("duplicates are rejected", func(t *testing.T) {
+		_, _, err := packstream.Decoder{DuplicateKeys: packstream.DuplicateKeysError}.Unpack(input)
+
+		Expect(err).To(Equal(&packstream.ProtocolError{Message: `duplicate dictionary key "k"`}))
+	})
+
+	t.Run("duplicates are collected", func(t *testing.T) {
+		dictionary, _, err := packstream.Decoder{DuplicateKeys: packstream.DuplicateKeysCollect}.Unpack(input)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dictionary).To(Equal(&packstream.OrderedDictionary{Entries: []packstream.DictionaryEntry{
+			{Key: "k", Value: packstream.Integer(1)},
+			{Key: "o", Value: packstream.Integer(2)},
+			{Key: "k", Value: packstream.Integer(3)},
+		}}))
+	})
+}
+
 func TestUnpackStructure(t *testing.T) {
 	RegisterTestingT(t)
 
@@ -184,11 +255,11 @@ func TestUnpackStructure(t *testing.T) {
 		{[]byte{0xB0, 0x7E}, &packstream.Structure{TagByte: 0x7E}},
 		{[]byte{0xB1, 0x70, 0xA1, 0x81, byte('K'), 0x81, byte('V')}, &packstream.Structure{
 			TagByte: 0x70,
-			Fields:  []packstream.Value{dictionary("K", &value)}}},
+			Fields:  []packstream.Value{packstream.NewOrderedDictionary("K", &value)}}},
 		{[]byte{0xB2, 0x70, 0xA1, 0x81, byte('K'), 0x81, byte('V'), 0x7F}, &packstream.Structure{
 			TagByte: 0x70,
 			Fields:  []packstream.Value{
-				dictionary("K", &value),
+				packstream.NewOrderedDictionary("K", &value),
 				packstream.Integer(127),
 			}}},
 	}
@@ -206,6 +277,122 @@ func TestUnpackStructure(t *testing.T) {
 	}
 }
 
+func TestUnpackLimits(t *testing.T) {
+	RegisterTestingT(t)
+
+	testCases := []struct {
+		name   string
+		input  []byte
+		limits packstream.Limits
+		error  string
+	}{
+		{"long list", []byte{0x93, 1, 2, 3}, packstream.Limits{MaxCollectionLength: 2}, "list of 3 entries exceeds the maximum of 2"},
+		{"long dictionary", []byte{0xA3, 0x81, 'a', 1, 0x81, 'b', 2, 0x81, 'c', 3}, packstream.Limits{MaxCollectionLength: 2}, "dictionary of 3 entries exceeds the maximum of 2"},
+		{"long structure", []byte{0xB3, 0x4E, 1, 2, 3}, packstream.Limits{MaxCollectionLength: 2}, "structure of 3 entries exceeds the maximum of 2"},
+		{"deep list", []byte{0x91, 0x91, 0x91, 0x90}, packstream.Limits{MaxDepth: 3}, "list nested deeper than the maximum of 3"},
+		{"deep dictionary", []byte{0x91, 0xA1, 0x81, 'a', 0xA0}, packstream.Limits{MaxDepth: 2}, "dictionary nested deeper than the maximum of 2"},
+		{"deeply nested lists", append(bytes.Repeat([]byte{0x91}, 128), 0x90), packstream.DefaultLimits, "list nested deeper than the maximum of 128"},
+		{"string longer than data", []byte{0xD2, 0xFF, 0xFF, 0xFF, 0xFF, 'a'}, packstream.Limits{}, "string of 4294967295 bytes exceeds the 1 remaining bytes"},
+	}
+
+	for _, testCase := range testCases {
+		input := testCase.input
+		limits := testCase.limits
+		expectedError := testCase.error
+		t.Run(fmt.Sprintf("%s should be rejected", testCase.name), func(t *testing.T) {
+			_, _, err := packstream.Decoder{Limits: limits}.Unpack(input)
+
+			var protocolError *packstream.ProtocolError
+			Expect(errors.As(err, &protocolError)).To(BeTrue(), "expected protocol error, got %v", err)
+			Expect(protocolError.Message).To(Equal(expectedError))
+		})
+	}
+
+	t.Run("values within limits should be unpacked", func(t *testing.T) {
+		_, _, err := packstream.Decoder{Limits: packstream.Limits{MaxCollectionLength: 2, MaxDepth: 3}}.Unpack([]byte{0x92, 0x91, 0x90, 1})
+
+		Expect(err).NotTo(HaveOccurred())
+	})
+}
+
+func TestUnpackMalformedValue(t *testing.T) {
+	RegisterTestingT(t)
+
+	testCases := []struct {
+		name  string
+		input string
+		error string
+	}{
+		{"truncated int_8", "C8", "expected 1 bytes after integer marker C8, got 0"},
+		{"truncated int_16", "C9_00", "expected 2 bytes after integer marker C9, got 1"},
+		{"truncated int_32", "CA_00_00_00", "expected 4 bytes after integer marker CA, got 3"},
+		{"truncated int_64", "CB_00_00_00_00_00_00_00", "expected 8 bytes after integer marker CB, got 7"},
+		{"truncated float", "C1_00", "expected 8 bytes after float marker, got 1"},
+		{"truncated string size", "D1_00", "expected 2 size bytes after marker D1, got 1"},
+		{"truncated string", "83_41_41", "string of 3 bytes exceeds the 2 remaining bytes"},
+		{"truncated list", "D6_FF_FF_FF_FF_01", "list of 4294967295 entries exceeds the 1 remaining bytes"},
+		{"truncated dictionary", "A2_81_41_01", "dictionary of 2 entries exceeds the 3 remaining bytes"},
+		{"non-string dictionary key", "A1_01_01", "expected dictionary key number 1 to be a string"},
+		{"structure without tag", "B1", "expected tag byte after structure marker"},
+		{"structure with missing fields", "B2_4E_01", "data to unpack must be at 1 byte long"},
+	}
+
+	for _, testCase := range testCases {
+		input := decodeHexa(sanitize(testCase.input))
+		expectedError := testCase.error
+		t.Run(fmt.Sprintf("%s should be rejected", testCase.name), func(t *testing.T) {
+			_, _, err := packstream.UnpackValue(input)
+
+			Expect(err).To(MatchError(ContainSubstring(expectedError)))
+		})
+	}
+}
+
+func TestUnpackStructureFieldCount(t *testing.T) {
+	RegisterTestingT(t)
+
+	structure, n, err := packstream.UnpackValue([]byte{0xB1, 0x4E, 0x01, 0x02})
+
+	Expect(err).NotTo(HaveOccurred())
+	Expect(n).To(Equal(3), "should only read the declared field")
+	Expect(structure.(*packstream.Structure).Fields).To(Equal([]packstream.Value{packstream.Integer(1)}))
+}
+
+func FuzzUnpackValue(f *testing.F) {
+	seeds := []string{
+		"C0", "C2", "C3", "C1_3F_F1_99_99_99_99_99_9A",
+		"F0", "7F", "C8_80", "C9_7F_FF", "CA_80_00_00_00", "CB_7F_FF_FF_FF_FF_FF_FF_FF",
+		"80", "81_41", "D0_01_41", "D1_00_01_41", "D2_00_00_00_01_41",
+		"90", "92_81_41_C0", "D4_02_81_41_C0", "D5_00_02_81_41_C0", "D6_00_00_00_02_81_41_C0",
+		"A0", "A1_81_41_81_41", "A1_81_41_CA_00_00_00_2A", "D8_01_81_41_81_41",
+		"D9_00_01_81_41_81_41", "DA_00_00_00_01_81_41_81_41",
+		"B0_70", "B2_70_A1_81_4B_81_56_7F",
+		"B3_4E_01_91_81_4C_A0",
+		"B5_52_01_02_03_81_54_A0",
+		"B3_49_00_00_00",
+	}
+	for _, seed := range seeds {
+		input, err := hex.DecodeString(sanitize(seed))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(input)
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		value, n, err := packstream.UnpackValue(input)
+		if err != nil {
+			return
+		}
+		if n <= 0 || n > len(input) {
+			t.Fatalf("read %d bytes out of %d", n, len(input))
+		}
+		for _, hydrator := range []*packstream.Hydrator{packstream.NewHydrator(4, 4, nil), packstream.NewHydrator(5, 0, nil)} {
+			_, _ = hydrator.Hydrate(value)
+		}
+	})
+}
+
 func sanitize(input string) string {
 	return strings.ReplaceAll(input, "_", "")
 }
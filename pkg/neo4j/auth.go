@@ -0,0 +1,14 @@
+package neo4j
+
+// AuthToken holds the credentials sent to the server when connecting
+type AuthToken struct {
+	username string
+	password string
+}
+
+func BasicAuth(username, password string) AuthToken {
+	return AuthToken{
+		username: username,
+		password: password,
+	}
+}
@@ -0,0 +1,112 @@
+package neo4j
+
+import (
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/internal/bolt"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	"io"
+	"net"
+	"time"
+)
+
+type Config struct {
+	// CloseTimeout bounds how long Driver.Close waits for open sessions to
+	// be closed, before forcibly closing their connections
+	CloseTimeout time.Duration
+	// LoadBalancingStrategy selects the server a query is sent to, when
+	// several servers can serve it
+	LoadBalancingStrategy LoadBalancingStrategy
+	// AddressResolver, when set, expands the addresses of the URI hosts into
+	// the addresses of the servers to connect to
+	AddressResolver ServerAddressResolver
+	// Dialer opens the connections to servers, such as through a proxy or
+	// over a Unix domain socket, and defaults to plain TCP connections
+	Dialer Dialer
+	// MaxMessageSize bounds the size in bytes of the messages received from
+	// servers, zero means no limit
+	MaxMessageSize int
+	// MaxCollectionLength bounds the number of entries of received lists,
+	// maps and structures, zero means no limit
+	MaxCollectionLength int
+	// MaxNestingDepth bounds how deeply received lists, maps and structures
+	// nest, zero means no limit
+	MaxNestingDepth int
+	// Log receives the driver events, none are logged when nil
+	Log Logger
+	// BoltLogger receives every message exchanged with servers, none are
+	// logged when nil
+	BoltLogger BoltLogger
+	// Metrics receives measurements of the connection pool and of queries,
+	// they are discarded when nil
+	Metrics Metrics
+	// Tracer starts spans around sessions, transactions, queries and
+	// connection acquisitions, none are started when nil
+	Tracer Tracer
+	// DuplicateKeys decides what happens to keys occurring more than once in
+	// received maps, the last value wins by default
+	DuplicateKeys DuplicateKeyPolicy
+	// Structures are the user-defined structures received values are
+	// hydrated into, and parameters dehydrated from, on top of the ones the
+	// protocol defines
+	Structures *Structures
+}
+
+// DuplicateKeyPolicy decides what happens to keys occurring more than once in
+// received maps
+type DuplicateKeyPolicy = packstream.DuplicateKeyPolicy
+
+const (
+	// DuplicateKeysLastWins keeps the last value of the key
+	DuplicateKeysLastWins = packstream.DuplicateKeysLastWins
+	// DuplicateKeysError fails with a ProtocolError
+	DuplicateKeysError = packstream.DuplicateKeysError
+	// DuplicateKeysCollect keeps every value of the key, available through
+	// GetAll
+	DuplicateKeysCollect = packstream.DuplicateKeysCollect
+)
+
+// Dialer is asked for a tcp connection to the host:port address of a server,
+// the connection context carries the connection timeout, if any
+type Dialer = bolt.Dialer
+
+// TrafficRecorder records the handshakes and decoded messages of the
+// connections its dialer opens, one JSON entry per line, in the format
+// bolttest.NewReplayServer replays
+type TrafficRecorder = bolt.Recorder
+
+// NewTrafficRecorder records traffic to the writer, to be set up with
+// config.Dialer = recorder.Dialer(config.Dialer)
+func NewTrafficRecorder(writer io.Writer) *TrafficRecorder {
+	return bolt.NewRecorder(writer)
+}
+
+// ServerAddressResolver returns the addresses, as host:port, that the given
+// address stands for. Addresses without port default to port 7687.
+type ServerAddressResolver func(address string) []string
+
+// resolve expands the addresses with the resolver, if any
+func resolve(resolver ServerAddressResolver, addresses []string) ([]string, error) {
+	if resolver == nil {
+		return addresses, nil
+	}
+	var result []string
+	for _, address := range addresses {
+		for _, resolvedAddress := range resolver(address) {
+			result = append(result, bolt.ParseAddress(resolvedAddress))
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("could not resolve any server from %v", addresses)
+	}
+	return result, nil
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		CloseTimeout:          5 * time.Second,
+		LoadBalancingStrategy: LeastConnectedStrategy(),
+		Dialer:                &net.Dialer{},
+		MaxMessageSize:        bolt.DefaultMaxMessageSize,
+		MaxNestingDepth:       packstream.DefaultLimits.MaxDepth,
+	}
+}
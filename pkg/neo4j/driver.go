@@ -1,16 +1,55 @@
 package neo4j
 
 import (
+	"context"
+	"fmt"
 	"github.com/fbiville/go-usain-go/pkg/internal/bolt"
 	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Driver struct {
-	connector    *bolt.Connector
+	address        string
+	routingContext map[string]string
+	config         *Config
+	pool           *pool
+	provider       connectionProvider
+	mutex          sync.Mutex
+	closed         bool
+	// openSessions counts the sessions not closed yet, sessionsClosed is
+	// closed once the driver is closed and none of them are left
+	openSessions   int
+	sessionsClosed chan struct{}
 }
 
+// Close waits for open sessions to be closed, within the configured
+// timeout, before closing all connections
 func (d *Driver) Close() error {
-	return d.connector.Close()
+	d.mutex.Lock()
+	if d.closed {
+		d.mutex.Unlock()
+		return nil
+	}
+	d.closed = true
+	d.sessionsClosed = make(chan struct{})
+	if d.openSessions == 0 {
+		close(d.sessionsClosed)
+	}
+	sessionsClosed := d.sessionsClosed
+	d.mutex.Unlock()
+	d.config.Log.Infof("driver", d.address, "closing")
+
+	timeout := time.NewTimer(d.config.CloseTimeout)
+	defer timeout.Stop()
+	select {
+	case <-sessionsClosed:
+	case <-timeout.C:
+	}
+	d.pool.close()
+	return nil
 }
 
 type AccessMode byte
@@ -24,45 +63,284 @@ func (a AccessMode) String() string {
 	return [...]string{"r", "w"}[a]
 }
 
-func NewDriver(host, username, password string) (connectionId *Driver, err error) {
-	connector, err := bolt.NewConnector(host)
+func (a AccessMode) role() string {
+	return [...]string{"reader", "writer"}[a]
+}
+
+// NewDriver connects to the server at the given URI. bolt:// URIs target
+// servers directly, while neo4j:// URIs target a cluster, where queries are
+// routed to the appropriate cluster member.
+// Several comma-separated hosts can be given, such as in
+// bolt://host1:7687,host2:7687. Direct drivers then spread reads over all of
+// them and send writes to the first one, while routing drivers use them as
+// initial routers.
+// Hosts are expanded with Config.AddressResolver when set, which routing
+// drivers call again whenever they fall back to their initial routers.
+func NewDriver(target, username, password string, configurers ...func(*Config)) (*Driver, error) {
+	config := defaultConfig()
+	for _, configurer := range configurers {
+		configurer(config)
+	}
+	if config.Log == nil {
+		config.Log = voidLogger{}
+	}
+	if config.Metrics == nil {
+		config.Metrics = NoopMetrics{}
+	}
+	if config.Tracer == nil {
+		config.Tracer = NoopTracer{}
+	}
+	uri, addresses, err := parseTarget(target)
 	if err != nil {
 		return nil, err
 	}
-	err = connector.ShakeHands(bolt.NewVersion(4, 2))
+	var routingContext map[string]string
+	switch uri.Scheme {
+	case "bolt":
+	case "neo4j":
+		// the server is told about the address as given, before resolution
+		routingContext = map[string]string{"address": addresses[0]}
+		for key, values := range uri.Query() {
+			routingContext[key] = values[0]
+		}
+	default:
+		return nil, fmt.Errorf("unsupported URI scheme %q, expected bolt or neo4j", uri.Scheme)
+	}
+	seedAddresses := addresses
+	addresses, err = resolve(config.AddressResolver, addresses)
 	if err != nil {
 		return nil, err
 	}
-	err = connector.SendHello(username, password)
+	token := BasicAuth(username, password)
+	connectionPool := newPool(func(ctx context.Context, address string) (*bolt.Connector, error) {
+		return connect(ctx, config, address, token, routingContext)
+	}, config.Log, config.Metrics)
+	// eagerly open a first connection to fail fast on unreachable servers
+	var connector *bolt.Connector
+	for _, candidate := range addresses {
+		connector, err = connectionPool.acquire(context.Background(), candidate)
+		if err == nil {
+			break
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
-	_, err = connector.ReceiveSuccess()
+	address := connector.Address()
+	connectionPool.release(connector, nil)
+	var provider connectionProvider = &directProvider{
+		pool:      connectionPool,
+		balancer:  &balancer{pool: connectionPool, strategy: config.LoadBalancingStrategy},
+		addresses: addresses,
+	}
+	if routingContext != nil {
+		provider = newRouter(connectionPool, config.LoadBalancingStrategy, seedAddresses, config.AddressResolver, routingContext, config.Log)
+	}
+	return &Driver{
+		address:        address,
+		routingContext: routingContext,
+		config:         config,
+		pool:           connectionPool,
+		provider:       provider,
+	}, nil
+}
+
+// parseTarget parses the URI, of which the authority may list several
+// comma-separated hosts, and returns the addresses of these hosts
+func parseTarget(target string) (*url.URL, []string, error) {
+	schemeEnd := strings.Index(target, "://")
+	if schemeEnd < 0 {
+		uri, err := url.Parse(target)
+		return uri, []string{bolt.ParseAddress(target)}, err
+	}
+	authorityStart := schemeEnd + len("://")
+	authorityEnd := len(target)
+	if index := strings.IndexAny(target[authorityStart:], "/?#"); index >= 0 {
+		authorityEnd = authorityStart + index
+	}
+	hosts := strings.Split(target[authorityStart:authorityEnd], ",")
+	scheme := target[:authorityStart]
+	uri, err := url.Parse(scheme + hosts[0] + target[authorityEnd:])
+	if err != nil {
+		return nil, nil, err
+	}
+	addresses := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if host == "" {
+			return nil, nil, fmt.Errorf("empty host in URI %q", target)
+		}
+		addresses = append(addresses, bolt.ParseAddress(scheme+host))
+	}
+	return uri, addresses, nil
+}
+
+type ServerInfo struct {
+	Address         string
+	Agent           string
+	ProtocolVersion string
+	ConnectionID    string
+}
+
+// VerifyConnectivity opens a new connection and completes HELLO
+func (d *Driver) VerifyConnectivity(ctx context.Context) error {
+	_, err := d.GetServerInfo(ctx)
+	return err
+}
+
+// GetServerInfo opens a new connection and returns the information the
+// server sent back in response to HELLO
+func (d *Driver) GetServerInfo(ctx context.Context) (*ServerInfo, error) {
+	connector, err := d.pool.acquireNew(ctx, d.address)
 	if err != nil {
 		return nil, err
 	}
-	return &Driver{
-		connector:    connector,
+	defer d.pool.release(connector, nil)
+	info := serverInfo(connector)
+	return &info, nil
+}
+
+func serverInfo(connector *bolt.Connector) ServerInfo {
+	return ServerInfo{
+		Address:         connector.Address(),
+		Agent:           connector.ServerAgent(),
+		ProtocolVersion: connector.Version().String(),
+		ConnectionID:    connector.ConnectionId(),
+	}
+}
+
+// VerifyAuthentication checks the given credentials on a dedicated
+// connection, without running any query, and closes it right after
+func (d *Driver) VerifyAuthentication(ctx context.Context, token AuthToken) error {
+	d.mutex.Lock()
+	closed := d.closed
+	d.mutex.Unlock()
+	if closed {
+		return ErrDriverClosed
+	}
+	connector, err := connect(ctx, d.config, d.address, token, d.routingContext)
+	if err != nil {
+		return err
+	}
+	d.pool.discard(connector, true)
+	return nil
+}
+
+func (d *Driver) NewSession(config SessionConfig) (*Session, error) {
+	return d.NewSessionContext(context.Background(), config)
+}
+
+// NewSessionContext opens a session, whose span is started from the context
+// and ended when the session is closed
+func (d *Driver) NewSessionContext(ctx context.Context, config SessionConfig) (*Session, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.closed {
+		return nil, ErrDriverClosed
+	}
+	d.openSessions++
+	ctx, span := d.config.Tracer.StartSpan(ctx, SessionOperation, SpanAttributes{Database: config.DatabaseName})
+	return &Session{
+		driver: d,
+		config: config,
+		ctx:    ctx,
+		span:   span,
 	}, nil
 }
 
 func (d *Driver) Run(query string, accessMode AccessMode) (*packstream.List, error) {
-	connector := d.connector
-	err := connector.SendRun(query, accessMode.String())
+	session, err := d.NewSession(SessionConfig{AccessMode: accessMode})
 	if err != nil {
 		return nil, err
 	}
-	_, err = connector.ReceiveSuccess()
+	defer session.Close()
+	result, err := session.Run(query)
 	if err != nil {
 		return nil, err
 	}
-	record, err := connector.ReceiveRecord()
+	var record *packstream.List
+	if result.Next() {
+		values := packstream.List(result.Record().Values)
+		record = &values
+	}
+	if _, err := result.Consume(); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (d *Driver) sessionClosed() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.openSessions--
+	if d.closed && d.openSessions == 0 {
+		close(d.sessionsClosed)
+	}
+}
+
+func connect(ctx context.Context, config *Config, address string, token AuthToken, routingContext map[string]string) (*bolt.Connector, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	config.Log.Debugf("driver", address, "connecting")
+	connector, err := bolt.DialConnector(ctx, config.Dialer, address, bolt.DefaultCapabilities)
 	if err != nil {
+		config.Log.Warnf("driver", address, "could not connect: %v", err)
+		config.Metrics.ConnectionFailed(address, err)
 		return nil, err
 	}
-	_, err = connector.ReceiveSuccess()
+	connector.SetMessageLogger(config.BoltLogger)
+	connector.SetByteCounter(&byteCounter{metrics: config.Metrics, address: connector.Address()})
+	connector.SetLimits(config.MaxMessageSize, packstream.Limits{
+		MaxCollectionLength: config.MaxCollectionLength,
+		MaxDepth:            config.MaxNestingDepth,
+	})
+	connector.SetDuplicateKeyPolicy(config.DuplicateKeys)
+	connector.SetStructures(config.Structures)
+	if deadline, found := ctx.Deadline(); found {
+		err = connector.SetDeadline(deadline)
+	}
+	if err == nil {
+		err = authenticate(connector, token.username, token.password, routingContext)
+	}
+	if err == nil {
+		err = connector.SetDeadline(time.Time{})
+	}
 	if err != nil {
+		config.Log.Warnf("driver", address, "could not connect: %v", err)
+		config.Metrics.ConnectionFailed(address, err)
+		_ = connector.Close()
 		return nil, err
 	}
-	return record, nil
+	config.Metrics.ConnectionCreated(connector.Address())
+	config.Log.Infof("driver", connector.LogContext(), "connected with protocol version %s to %s", connector.Version(), connector.ServerAgent())
+	return connector, nil
+}
+
+func authenticate(connector *bolt.Connector, username, password string, routingContext map[string]string) error {
+	err := connector.ShakeHands(
+		bolt.NewVersionRange(5, 4, 4),
+		bolt.NewVersionRange(4, 4, 2),
+	)
+	if err != nil {
+		return err
+	}
+	err = connector.SendHello(username, password, routingContext)
+	if err != nil {
+		return err
+	}
+	err = connector.ReceiveHelloSuccess()
+	if err != nil {
+		return err
+	}
+	if connector.SupportsLogon() {
+		err = connector.SendLogon(username, password)
+		if err != nil {
+			return err
+		}
+		_, err = connector.ReceiveSuccess()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
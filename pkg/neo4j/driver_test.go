@@ -2,13 +2,16 @@ package neo4j_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/bolttest"
 	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
 	"github.com/fbiville/go-usain-go/pkg/neo4j"
 	. "github.com/onsi/gomega"
-	"github.com/testcontainers/testcontainers-go"
-	"github.com/testcontainers/testcontainers-go/wait"
+	"net"
+	"sync"
 	"testing"
+	"time"
 )
 
 const username = "neo4j"
@@ -17,17 +20,13 @@ const password = "v3rys3cur3"
 func TestDriver(t *testing.T) {
 	RegisterTestingT(t)
 
-	ctx := context.Background()
-	container, err := startContainer(ctx, username, password)
-	Expect(err).NotTo(HaveOccurred(), "container should start")
-	defer func() {
-		Expect(container.Terminate(ctx)).
-			NotTo(HaveOccurred(), "container should shut down")
-	}()
-	port, err := container.MappedPort(ctx, "7687")
-	Expect(err).NotTo(HaveOccurred(), "container should return mapped port")
-	address := fmt.Sprintf("bolt://localhost:%d", port.Int())
-	session, err := neo4j.NewDriver(address, username, password)
+	server := bolttest.NewServer(t,
+		bolttest.Expect("HELLO", bolttest.Success(map[string]interface{}{"server": "Neo4j/4.4.0"})).
+			WithField(0, map[string]interface{}{"scheme": "basic", "principal": username, "credentials": password, "user_agent": "Go-usain/0.0.1"}),
+		bolttest.ExpectRun("RETURN 42", bolttest.Success(map[string]interface{}{"fields": []string{"42"}})),
+		bolttest.Expect("PULL", bolttest.Record(42), bolttest.Success(nil)),
+	)
+	session, err := neo4j.NewDriver(server.URI(), username, password)
 	defer func() {
 		Expect(session.Close()).To(Succeed())
 	}()
@@ -44,19 +43,364 @@ func TestDriver(t *testing.T) {
 	})
 }
 
-func startContainer(ctx context.Context, username, password string) (testcontainers.Container, error) {
-	request := testcontainers.ContainerRequest{
-		Image:        "neo4j:4.2",
-		ExposedPorts: []string{"7687/tcp"},
-		Env: map[string]string{
-			"NEO4J_AUTH":                  fmt.Sprintf("%s/%s", username, password),
-			"NEO4J_dbms_logs_debug_level": "DEBUG",
-		},
-		WaitingFor:  wait.ForLog("Bolt enabled"),
-		ReaperImage: "testcontainers/ryuk",
+func TestParameters(t *testing.T) {
+	RegisterTestingT(t)
+
+	at := time.Date(2024, 3, 1, 12, 30, 0, 500, time.FixedZone("", 3600))
+	// Bolt 4.4 date times hold the local seconds rather than the UTC ones
+	legacyDateTime := &neo4j.Structure{TagByte: 0x46, Fields: []neo4j.Value{neo4j.Integer(at.Unix() + 3600), neo4j.Integer(500), neo4j.Integer(3600)}}
+
+	t.Run("sends date times with the layout of the protocol version", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.ExpectRun("RETURN $at", bolttest.Success(map[string]interface{}{"fields": []string{"$at"}})).
+				WithField(1, map[string]interface{}{"at": legacyDateTime}),
+			bolttest.Expect("PULL", bolttest.Success(nil)),
+		)
+		driver, err := neo4j.NewDriver(server.URI(), username, password)
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		session, err := driver.NewSession(neo4j.SessionConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		defer session.Close()
+
+		result, err := session.RunContext(context.Background(), "RETURN $at", map[string]interface{}{"at": at})
+
+		Expect(err).NotTo(HaveOccurred())
+		_, err = result.Consume()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	t.Run("sends date times with the UTC layout of Bolt 5", func(t *testing.T) {
+		server := bolttest.NewServerWithVersion(t, 5, 0,
+			bolttest.ExpectHello("Neo4j/5.0.0"),
+			bolttest.ExpectRun("RETURN $at", bolttest.Success(map[string]interface{}{"fields": []string{"$at"}})).
+				WithField(1, map[string]interface{}{"at": &neo4j.Structure{TagByte: 0x49, Fields: []neo4j.Value{neo4j.Integer(at.Unix()), neo4j.Integer(500), neo4j.Integer(3600)}}}),
+			bolttest.Expect("PULL", bolttest.Success(nil)),
+		)
+		driver, err := neo4j.NewDriver(server.URI(), username, password)
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		session, err := driver.NewSession(neo4j.SessionConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		defer session.Close()
+
+		result, err := session.RunContext(context.Background(), "RETURN $at", map[string]interface{}{"at": at})
+
+		Expect(err).NotTo(HaveOccurred())
+		_, err = result.Consume()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	t.Run("sends date times of transactions with the layout of the protocol version", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.Expect("BEGIN", bolttest.Success(nil)),
+			bolttest.ExpectRun("RETURN $at", bolttest.Success(map[string]interface{}{"fields": []string{"$at"}})).
+				WithField(1, map[string]interface{}{"at": []interface{}{legacyDateTime}}),
+			bolttest.Expect("PULL", bolttest.Success(nil)),
+			bolttest.Expect("COMMIT", bolttest.Success(nil)),
+		)
+		driver, err := neo4j.NewDriver(server.URI(), username, password)
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		session, err := driver.NewSession(neo4j.SessionConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		defer session.Close()
+		transaction, err := session.BeginTransaction(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = transaction.Run(context.Background(), "RETURN $at", map[string]interface{}{"at": []time.Time{at}})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(transaction.Commit(context.Background())).To(Succeed())
+	})
+
+	t.Run("rolls back transactions of queries with invalid parameters", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.Expect("BEGIN", bolttest.Success(nil)),
+			bolttest.Expect("ROLLBACK", bolttest.Success(nil)),
+			bolttest.Expect("BEGIN", bolttest.Success(nil)),
+			bolttest.Expect("COMMIT", bolttest.Success(nil)),
+		)
+		driver, err := neo4j.NewDriver(server.URI(), username, password)
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		session, err := driver.NewSession(neo4j.SessionConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		defer session.Close()
+		transaction, err := session.BeginTransaction(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = transaction.Run(context.Background(), "RETURN $c", map[string]interface{}{"c": make(chan int)})
+
+		Expect(err).To(HaveOccurred())
+		transaction, err = session.BeginTransaction(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(transaction.Commit(context.Background())).To(Succeed())
+		Expect(server.Received()).To(Equal([]string{"HELLO", "BEGIN", "ROLLBACK", "BEGIN", "COMMIT"}))
+	})
+}
+
+func TestConnectionReuse(t *testing.T) {
+	RegisterTestingT(t)
+	failedQuery := []bolttest.Step{
+		bolttest.ExpectRun("RETURN x", bolttest.Failure("Neo.ClientError.Statement.SyntaxError", "invalid query")),
+		bolttest.Expect("PULL", bolttest.Ignored()),
 	}
-	return testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
-		ContainerRequest: request,
-		Started:          true,
+
+	t.Run("resets connections after server failures", func(t *testing.T) {
+		server := bolttest.NewServer(t, steps(
+			[]bolttest.Step{hello("bolt-1")},
+			failedQuery,
+			[]bolttest.Step{bolttest.Expect("RESET", bolttest.Success(nil))},
+			expectQuery("RETURN 42", 42),
+		)...)
+		driver, err := neo4j.NewDriver(server.URI(), username, password)
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+
+		_, err = driver.Run("RETURN x", neo4j.ReadAccessMode)
+		Expect(err).To(MatchError(ContainSubstring("invalid query")))
+		result, err := driver.Run("RETURN 42", neo4j.ReadAccessMode)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(&neo4j.List{neo4j.Integer(42)}))
+		Expect(server.Received()).To(Equal([]string{"HELLO", "RUN", "PULL", "RESET", "RUN", "PULL"}))
+	})
+
+	t.Run("discards connections that cannot be reset", func(t *testing.T) {
+		server := bolttest.NewSequenceServer(t,
+			steps(
+				[]bolttest.Step{hello("bolt-1")},
+				failedQuery,
+				[]bolttest.Step{bolttest.Expect("RESET", bolttest.Failure("Neo.TransientError.General.DatabaseUnavailable", "unavailable"))},
+			),
+			steps([]bolttest.Step{hello("bolt-2")}, expectQuery("RETURN 42", 42)),
+		)
+		driver, err := neo4j.NewDriver(server.URI(), username, password)
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+
+		_, err = driver.Run("RETURN x", neo4j.ReadAccessMode)
+		Expect(err).To(MatchError(ContainSubstring("invalid query")))
+		_, err = driver.Run("RETURN 42", neo4j.ReadAccessMode)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(server.Received()).To(Equal([]string{"HELLO", "RUN", "PULL", "RESET", "HELLO", "RUN", "PULL"}))
+	})
+}
+
+func TestDriverClose(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("says goodbye to idle connections", func(t *testing.T) {
+		server := bolttest.NewServer(t, bolttest.ExpectHello("Neo4j/4.4.0"))
+		driver, err := neo4j.NewDriver(server.URI(), username, password)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(driver.Close()).To(Succeed())
+
+		Eventually(server.Received).Should(Equal([]string{"HELLO", "GOODBYE"}))
+	})
+
+	t.Run("fails fast once closed", func(t *testing.T) {
+		server := bolttest.NewServer(t, bolttest.ExpectHello("Neo4j/4.4.0"))
+		driver, err := neo4j.NewDriver(server.URI(), username, password)
+		Expect(err).NotTo(HaveOccurred())
+		session, err := driver.NewSession(neo4j.SessionConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(session.Close()).To(Succeed())
+		Expect(driver.Close()).To(Succeed())
+
+		_, err = driver.NewSession(neo4j.SessionConfig{})
+		Expect(err).To(MatchError(neo4j.ErrDriverClosed))
+		_, err = driver.Run("RETURN 42", neo4j.ReadAccessMode)
+		Expect(err).To(MatchError(neo4j.ErrDriverClosed))
+		_, err = session.Run("RETURN 42")
+		Expect(err).To(MatchError(neo4j.ErrSessionClosed))
+		Expect(driver.Close()).To(Succeed())
 	})
+
+	t.Run("waits for open sessions to be closed", func(t *testing.T) {
+		server := bolttest.NewServer(t, bolttest.ExpectHello("Neo4j/4.4.0"))
+		driver, err := neo4j.NewDriver(server.URI(), username, password, func(config *neo4j.Config) {
+			config.CloseTimeout = time.Minute
+		})
+		Expect(err).NotTo(HaveOccurred())
+		session, err := driver.NewSession(neo4j.SessionConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		closed := make(chan struct{})
+		go func() {
+			Expect(driver.Close()).To(Succeed())
+			close(closed)
+		}()
+
+		Consistently(closed, 100*time.Millisecond).ShouldNot(BeClosed())
+		Expect(session.Close()).To(Succeed())
+		Eventually(closed).Should(BeClosed())
+	})
+
+	t.Run("stops waiting for open sessions after the timeout", func(t *testing.T) {
+		server := bolttest.NewServer(t, bolttest.ExpectHello("Neo4j/4.4.0"))
+		driver, err := neo4j.NewDriver(server.URI(), username, password, func(config *neo4j.Config) {
+			config.CloseTimeout = 10 * time.Millisecond
+		})
+		Expect(err).NotTo(HaveOccurred())
+		session, err := driver.NewSession(neo4j.SessionConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		defer session.Close()
+
+		Expect(driver.Close()).To(Succeed())
+
+		_, err = session.Run("RETURN 42")
+		Expect(err).To(MatchError(neo4j.ErrDriverClosed))
+	})
+
+	t.Run("closes sessions closed concurrently only once", func(t *testing.T) {
+		server := bolttest.NewServer(t, bolttest.ExpectHello("Neo4j/4.4.0"))
+		driver, err := neo4j.NewDriver(server.URI(), username, password, func(config *neo4j.Config) {
+			config.CloseTimeout = time.Minute
+		})
+		Expect(err).NotTo(HaveOccurred())
+		session, err := driver.NewSession(neo4j.SessionConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		var closing sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			closing.Add(1)
+			go func() {
+				defer closing.Done()
+				Expect(session.Close()).To(Succeed())
+			}()
+		}
+		closing.Wait()
+
+		Expect(driver.Close()).To(Succeed())
+	})
+}
+
+func TestServerInfo(t *testing.T) {
+	RegisterTestingT(t)
+
+	server := bolttest.NewSequenceServer(t,
+		[]bolttest.Step{hello("bolt-1")},
+		[]bolttest.Step{hello("bolt-2")},
+		[]bolttest.Step{hello("bolt-3")},
+		[]bolttest.Step{hello("bolt-4").
+			WithField(0, map[string]interface{}{"scheme": "basic", "principal": username, "credentials": password, "user_agent": "Go-usain/0.0.1"})},
+		[]bolttest.Step{bolttest.Expect("HELLO", bolttest.Failure("Neo.ClientError.Security.Unauthorized", "invalid credentials"))},
+	)
+	driver, err := neo4j.NewDriver(server.URI(), username, password)
+	Expect(err).NotTo(HaveOccurred())
+	defer driver.Close()
+
+	t.Run("verifies connectivity", func(t *testing.T) {
+		Expect(driver.VerifyConnectivity(context.Background())).To(Succeed())
+	})
+
+	t.Run("gets server info from a new connection", func(t *testing.T) {
+		info, err := driver.GetServerInfo(context.Background())
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Address).To(Equal(server.Address()))
+		Expect(info.Agent).To(Equal("Neo4j/4.4.0"))
+		Expect(info.ProtocolVersion).To(Equal("4.4"))
+		Expect(info.ConnectionID).To(Equal("bolt-3"))
+	})
+
+	t.Run("fails to verify connectivity with a cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		Expect(driver.VerifyConnectivity(ctx)).To(MatchError(context.Canceled))
+	})
+
+	t.Run("verifies valid credentials", func(t *testing.T) {
+		err := driver.VerifyAuthentication(context.Background(), neo4j.BasicAuth(username, password))
+
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	t.Run("verifies invalid credentials", func(t *testing.T) {
+		err := driver.VerifyAuthentication(context.Background(), neo4j.BasicAuth(username, "nope"))
+
+		var neo4jError *neo4j.Neo4jError
+		Expect(errors.As(err, &neo4jError)).To(BeTrue())
+		Expect(neo4jError.Code).To(Equal("Neo.ClientError.Security.Unauthorized"))
+	})
+}
+
+func TestDialer(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("dials through the configured dialer", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.ExpectRun("RETURN 42", bolttest.Success(map[string]interface{}{"fields": []string{"42"}})),
+			bolttest.Expect("PULL", bolttest.Record(42), bolttest.Success(nil)),
+		)
+		dialer := &redirectingDialer{address: server.Address()}
+		driver, err := neo4j.NewDriver("bolt://db.internal:7688", username, password, func(config *neo4j.Config) {
+			config.Dialer = dialer
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+
+		result, err := driver.Run("RETURN 42", neo4j.ReadAccessMode)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(&packstream.List{packstream.Integer(42)}))
+		Expect(dialer.dialed).To(Equal([]string{"tcp db.internal:7688"}))
+	})
+
+	t.Run("surfaces dial failures", func(t *testing.T) {
+		_, err := neo4j.NewDriver("bolt://db.internal:7688", username, password, func(config *neo4j.Config) {
+			config.Dialer = &redirectingDialer{}
+		})
+
+		Expect(err).To(MatchError("no route to db.internal:7688"))
+	})
+}
+
+// redirectingDialer connects to the stub server at the address, regardless
+// of the dialed address, the way a proxy would
+type redirectingDialer struct {
+	address string
+	dialed  []string
+}
+
+func (r *redirectingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	r.dialed = append(r.dialed, network+" "+address)
+	if r.address == "" {
+		return nil, fmt.Errorf("no route to %s", address)
+	}
+	return (&net.Dialer{}).DialContext(ctx, network, r.address)
+}
+
+// hello expects HELLO and answers it with the connection ID
+func hello(connectionId string) bolttest.Step {
+	return bolttest.Expect("HELLO", bolttest.Success(map[string]interface{}{"server": "Neo4j/4.4.0", "connection_id": connectionId}))
+}
+
+// expectQuery expects the query to run and answers it with a record of the
+// values, if any
+func expectQuery(query string, values ...interface{}) []bolttest.Step {
+	var responses []bolttest.Response
+	if len(values) > 0 {
+		responses = append(responses, bolttest.Record(values...))
+	}
+	return []bolttest.Step{
+		bolttest.ExpectRun(query, bolttest.Success(map[string]interface{}{"fields": []string{"x"}})),
+		bolttest.Expect("PULL", append(responses, bolttest.Success(nil))...),
+	}
+}
+
+// steps joins the steps into a single script
+func steps(parts ...[]bolttest.Step) []bolttest.Step {
+	var result []bolttest.Step
+	for _, part := range parts {
+		result = append(result, part...)
+	}
+	return result
 }
@@ -0,0 +1,27 @@
+package neo4j
+
+import (
+	"errors"
+	"github.com/fbiville/go-usain-go/pkg/internal/bolt"
+)
+
+// ErrDriverClosed is returned by any operation attempted after Driver.Close
+var ErrDriverClosed = errors.New("driver is closed")
+
+// ErrSessionClosed is returned by any operation attempted after Session.Close
+var ErrSessionClosed = errors.New("session is closed")
+
+// Neo4jError is the error reported by the server when a request fails
+type Neo4jError = bolt.ServerError
+
+// ProtocolError is returned when a server sends a message that does not
+// follow the protocol or exceeds the configured limits
+type ProtocolError = bolt.ProtocolError
+
+// ErrTransactionClosed is returned by any operation attempted on a
+// transaction after it was committed, rolled back or failed
+var ErrTransactionClosed = errors.New("transaction is closed")
+
+// ErrTransactionOpen is returned when queries are run or a transaction is
+// begun on a session while one of its transactions is still open
+var ErrTransactionOpen = errors.New("session has an open transaction")
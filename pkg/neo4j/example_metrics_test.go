@@ -0,0 +1,66 @@
+package neo4j_test
+
+import (
+	"expvar"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	"log"
+	"time"
+)
+
+// expvarMetrics exports some of the driver measurements with expvar, served
+// on /debug/vars. Adapters for other metrics stacks follow the same shape:
+// embed neo4j.NoopMetrics and override the measurements to export.
+type expvarMetrics struct {
+	neo4j.NoopMetrics
+	connections *expvar.Map
+	queries     *expvar.Map
+	// queryMillis sums the query durations, to be divided by the number of
+	// queries for an average
+	queryMillis *expvar.Map
+	inUse       *expvar.Map
+}
+
+func newExpvarMetrics() *expvarMetrics {
+	return &expvarMetrics{
+		connections: expvar.NewMap("neo4j_connections"),
+		queries:     expvar.NewMap("neo4j_queries"),
+		queryMillis: expvar.NewMap("neo4j_query_milliseconds"),
+		inUse:       expvar.NewMap("neo4j_connections_in_use"),
+	}
+}
+
+func (e *expvarMetrics) ConnectionCreated(string) {
+	e.connections.Add("created", 1)
+}
+
+func (e *expvarMetrics) ConnectionFailed(string, error) {
+	e.connections.Add("failed", 1)
+}
+
+func (e *expvarMetrics) PoolGauges(address string, inUse, _ int) {
+	gauge := new(expvar.Int)
+	gauge.Set(int64(inUse))
+	e.inUse.Set(address, gauge)
+}
+
+func (e *expvarMetrics) QueryCompleted(_, database string, duration time.Duration, _ int, err error) {
+	outcome := "succeeded"
+	if err != nil {
+		outcome = "failed"
+	}
+	e.queries.Add(outcome, 1)
+	e.queryMillis.Add(database, duration.Milliseconds())
+}
+
+func Example_metrics() {
+	driver, err := neo4j.NewDriver("bolt://localhost:7687", "neo4j", "s3cr3t", func(config *neo4j.Config) {
+		config.Metrics = newExpvarMetrics()
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer driver.Close()
+	if _, err := driver.Run("RETURN 42", neo4j.ReadAccessMode); err != nil {
+		log.Fatal(err)
+	}
+}
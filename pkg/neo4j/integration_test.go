@@ -0,0 +1,106 @@
+//go:build integration
+
+package neo4j_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	. "github.com/onsi/gomega"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"testing"
+)
+
+// TestDriverIntegration runs against real Neo4j servers started in
+// containers, with go test -tags integration, a 4.4 one and a 5 one so that
+// both protocol families are covered
+func TestDriverIntegration(t *testing.T) {
+	RegisterTestingT(t)
+
+	for _, server := range []struct {
+		image string
+		// env sets settings valid for the version only, Neo4j 5 refusing to
+		// start with unknown ones such as dbms.logs.debug.level
+		env        map[string]string
+		protocol   string
+		elementIds bool
+	}{
+		{
+			image:    "neo4j:4.4",
+			env:      map[string]string{"NEO4J_dbms_logs_debug_level": "DEBUG"},
+			protocol: "4.4",
+		},
+		{
+			image:      "neo4j:5",
+			protocol:   "5.4",
+			elementIds: true,
+		},
+	} {
+		t.Run(server.image, func(t *testing.T) {
+			ctx := context.Background()
+			container, err := startContainer(ctx, server.image, server.env, username, password)
+			Expect(err).NotTo(HaveOccurred(), "container should start")
+			defer func() {
+				Expect(container.Terminate(ctx)).
+					NotTo(HaveOccurred(), "container should shut down")
+			}()
+			port, err := container.MappedPort(ctx, "7687")
+			Expect(err).NotTo(HaveOccurred(), "container should return mapped port")
+			address := fmt.Sprintf("bolt://localhost:%d", port.Int())
+			driver, err := neo4j.NewDriver(address, username, password)
+			Expect(err).NotTo(HaveOccurred(), "driver should connect")
+			defer func() {
+				Expect(driver.Close()).To(Succeed())
+			}()
+
+			t.Run("negotiates the protocol version", func(t *testing.T) {
+				info, err := driver.GetServerInfo(ctx)
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.ProtocolVersion).To(Equal(server.protocol))
+			})
+
+			t.Run("run simple query", func(t *testing.T) {
+				result, err := driver.Run("RETURN 42", neo4j.ReadAccessMode)
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal(&packstream.List{packstream.Integer(42)}))
+			})
+
+			t.Run("hydrates nodes", func(t *testing.T) {
+				result, err := driver.Run("CREATE (n:Person {name: 'Alice'}) RETURN n", neo4j.WriteAccessMode)
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(*result).To(HaveLen(1))
+				node, ok := (*result)[0].(*neo4j.Node)
+				Expect(ok).To(BeTrue(), "the value should be a node")
+				Expect(node.Labels).To(Equal([]string{"Person"}))
+				if server.elementIds {
+					Expect(node.ElementId).NotTo(BeEmpty())
+				} else {
+					Expect(node.ElementId).To(BeEmpty())
+				}
+			})
+		})
+	}
+}
+
+func startContainer(ctx context.Context, image string, env map[string]string, username, password string) (testcontainers.Container, error) {
+	settings := map[string]string{"NEO4J_AUTH": fmt.Sprintf("%s/%s", username, password)}
+	for name, value := range env {
+		settings[name] = value
+	}
+	request := testcontainers.ContainerRequest{
+		Image:        image,
+		ExposedPorts: []string{"7687/tcp"},
+		Env:          settings,
+		WaitingFor:   wait.ForLog("Bolt enabled"),
+		ReaperImage:  "testcontainers/ryuk",
+	}
+	return testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: request,
+		Started:          true,
+	})
+}
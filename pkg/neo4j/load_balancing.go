@@ -0,0 +1,99 @@
+package neo4j
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/internal/bolt"
+	"sync"
+)
+
+// ServerLoad describes a server a query can be sent to
+type ServerLoad struct {
+	Address string
+	// InUseConnections counts the connections to the server currently
+	// used by sessions
+	InUseConnections int
+}
+
+// LoadBalancingStrategy selects the server a query is sent to, among servers
+// able to serve it
+type LoadBalancingStrategy interface {
+	// Select returns the address of one of the candidates, candidates are
+	// never empty
+	Select(candidates []ServerLoad) string
+}
+
+// LeastConnectedStrategy selects the server with the fewest connections in
+// use, servers with the same number of connections in use are selected in
+// a round-robin fashion
+func LeastConnectedStrategy() LoadBalancingStrategy {
+	return &leastConnectedStrategy{}
+}
+
+type leastConnectedStrategy struct {
+	mutex  sync.Mutex
+	offset int
+}
+
+func (l *leastConnectedStrategy) Select(candidates []ServerLoad) string {
+	l.mutex.Lock()
+	start := l.offset % len(candidates)
+	l.offset++
+	l.mutex.Unlock()
+
+	selected := candidates[start]
+	for i := 1; i < len(candidates); i++ {
+		candidate := candidates[(start+i)%len(candidates)]
+		if candidate.InUseConnections < selected.InUseConnections {
+			selected = candidate
+		}
+	}
+	return selected.Address
+}
+
+// balancer acquires connections to the servers selected by the strategy
+type balancer struct {
+	pool     *pool
+	strategy LoadBalancingStrategy
+}
+
+// acquire tries the candidates in the order the strategy selects them, until
+// one of them accepts a connection, and reports the ones that do not. It
+// fails when the strategy selects a server that is not a candidate.
+func (b *balancer) acquire(ctx context.Context, candidates []string, unreachable func(address string)) (*bolt.Connector, error) {
+	var lastErr error
+	for len(candidates) > 0 {
+		loads := make([]ServerLoad, len(candidates))
+		for i, candidate := range candidates {
+			loads[i] = ServerLoad{Address: candidate, InUseConnections: b.pool.inUseCount(candidate)}
+		}
+		address := b.strategy.Select(loads)
+		if !contains(candidates, address) {
+			return nil, fmt.Errorf("load balancing strategy selected %q, which is not one of the candidates %v", address, candidates)
+		}
+		connector, err := b.pool.acquire(ctx, address)
+		if err == nil {
+			return connector, nil
+		}
+		if errors.Is(err, ErrDriverClosed) {
+			return nil, err
+		}
+		lastErr = err
+		unreachable(address)
+		candidates = without(candidates, address)
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no server available")
+	}
+	return nil, lastErr
+}
+
+func contains(addresses []string, address string) bool {
+	for _, candidate := range addresses {
+		if candidate == address {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package neo4j_test
+
+import (
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/bolttest"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	. "github.com/onsi/gomega"
+	"strings"
+	"testing"
+)
+
+func TestLoadBalancing(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("selects least connected server", func(t *testing.T) {
+		strategy := neo4j.LeastConnectedStrategy()
+
+		for i := 0; i < 3; i++ {
+			selected := strategy.Select([]neo4j.ServerLoad{
+				{Address: "a:7687", InUseConnections: 2},
+				{Address: "b:7687", InUseConnections: 0},
+				{Address: "c:7687", InUseConnections: 1},
+			})
+
+			Expect(selected).To(Equal("b:7687"))
+		}
+	})
+
+	t.Run("breaks ties in round-robin fashion", func(t *testing.T) {
+		strategy := neo4j.LeastConnectedStrategy()
+		candidates := []neo4j.ServerLoad{
+			{Address: "a:7687", InUseConnections: 1},
+			{Address: "b:7687", InUseConnections: 1},
+			{Address: "c:7687", InUseConnections: 1},
+		}
+
+		var selected []string
+		for i := 0; i < 4; i++ {
+			selected = append(selected, strategy.Select(candidates))
+		}
+
+		Expect(selected).To(Equal([]string{"a:7687", "b:7687", "c:7687", "a:7687"}))
+	})
+
+	t.Run("spreads reads over direct servers", func(t *testing.T) {
+		first := bolttest.NewServer(t, steps(
+			[]bolttest.Step{bolttest.ExpectHello("Neo4j/4.4.0")},
+			expectQuery("RETURN 42", 42),
+			expectQuery("RETURN 42", 42),
+			expectQuery("CREATE ()"),
+		)...)
+		second := bolttest.NewServer(t, steps(
+			[]bolttest.Step{bolttest.ExpectHello("Neo4j/4.4.0")},
+			expectQuery("RETURN 42", 42),
+			expectQuery("RETURN 42", 42),
+		)...)
+		driver, err := neo4j.NewDriver(multiHostUri(first, second), username, password)
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+
+		for i := 0; i < 4; i++ {
+			_, err = driver.Run("RETURN 42", neo4j.ReadAccessMode)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		_, err = driver.Run("CREATE ()", neo4j.WriteAccessMode)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(first.Received()).To(Equal([]string{"HELLO", "RUN", "PULL", "RUN", "PULL", "RUN", "PULL"}))
+		Expect(second.Received()).To(Equal([]string{"HELLO", "RUN", "PULL", "RUN", "PULL"}))
+	})
+
+	t.Run("falls back to other direct servers", func(t *testing.T) {
+		first := bolttest.NewServer(t, steps(
+			[]bolttest.Step{bolttest.ExpectHello("Neo4j/4.4.0")},
+			expectQuery("RETURN 42", 42),
+			expectQuery("RETURN 42", 42),
+		)...)
+		second := bolttest.NewServer(t)
+		driver, err := neo4j.NewDriver(multiHostUri(first, second), username, password)
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		second.Close()
+
+		for i := 0; i < 2; i++ {
+			_, err = driver.Run("RETURN 42", neo4j.ReadAccessMode)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		Expect(first.Received()).To(Equal([]string{"HELLO", "RUN", "PULL", "RUN", "PULL"}))
+	})
+
+	t.Run("uses the configured strategy", func(t *testing.T) {
+		first := bolttest.NewServer(t, bolttest.ExpectHello("Neo4j/4.4.0"))
+		second := bolttest.NewServer(t, steps(
+			[]bolttest.Step{bolttest.ExpectHello("Neo4j/4.4.0")},
+			expectQuery("RETURN 42", 42),
+		)...)
+		driver, err := neo4j.NewDriver(multiHostUri(first, second), username, password, func(config *neo4j.Config) {
+			config.LoadBalancingStrategy = lastServerStrategy{}
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+
+		_, err = driver.Run("RETURN 42", neo4j.ReadAccessMode)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second.Received()).To(Equal([]string{"HELLO", "RUN", "PULL"}))
+	})
+
+	t.Run("fails when the strategy selects an unknown server", func(t *testing.T) {
+		server := bolttest.NewServer(t, bolttest.ExpectHello("Neo4j/4.4.0"))
+		driver, err := neo4j.NewDriver(multiHostUri(server), username, password, func(config *neo4j.Config) {
+			config.LoadBalancingStrategy = unknownServerStrategy{}
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+
+		_, err = driver.Run("RETURN 42", neo4j.ReadAccessMode)
+
+		Expect(err).To(MatchError(fmt.Sprintf(`load balancing strategy selected "unknown:7687", which is not one of the candidates [%s]`, server.Address())))
+	})
+
+	t.Run("rejects empty hosts", func(t *testing.T) {
+		_, err := neo4j.NewDriver("bolt://localhost,", username, password)
+
+		Expect(err).To(MatchError(`empty host in URI "bolt://localhost,"`))
+	})
+}
+
+type lastServerStrategy struct{}
+
+func (lastServerStrategy) Select(candidates []neo4j.ServerLoad) string {
+	return candidates[len(candidates)-1].Address
+}
+
+type unknownServerStrategy struct{}
+
+func (unknownServerStrategy) Select([]neo4j.ServerLoad) string {
+	return "unknown:7687"
+}
+
+func multiHostUri(servers ...*bolttest.Server) string {
+	addresses := make([]string, len(servers))
+	for i, server := range servers {
+		addresses[i] = server.Address()
+	}
+	return fmt.Sprintf("bolt://%s", strings.Join(addresses, ","))
+}
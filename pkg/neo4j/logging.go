@@ -0,0 +1,113 @@
+package neo4j
+
+import (
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/internal/bolt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Logger receives the driver events, such as connections being opened and
+// routing tables being updated. name is the component logging the event,
+// such as pool or router, and id what the event is about, such as a server
+// address. Methods may be called concurrently.
+type Logger interface {
+	Errorf(name, id string, msg string, args ...interface{})
+	Warnf(name, id string, msg string, args ...interface{})
+	Infof(name, id string, msg string, args ...interface{})
+	Debugf(name, id string, msg string, args ...interface{})
+}
+
+// BoltLogger receives every message sent to and received from servers,
+// formatted on a single line with credentials redacted, such as
+// RUN "RETURN 42" {} {mode: "r"}
+type BoltLogger = bolt.MessageLogger
+
+type LogLevel int
+
+const (
+	ErrorLevel LogLevel = iota
+	WarningLevel
+	InfoLevel
+	DebugLevel
+)
+
+func (l LogLevel) String() string {
+	return [...]string{"ERROR", "WARN", "INFO", "DEBUG"}[l]
+}
+
+// NewLogger writes the events up to the level, one per line
+func NewLogger(writer io.Writer, level LogLevel) Logger {
+	return &writerLogger{output: &logOutput{writer: writer}, level: level}
+}
+
+// NewBoltLogger writes the messages one per line, prefixed with C: for the
+// ones sent to servers and S: for the ones received from servers
+func NewBoltLogger(writer io.Writer) BoltLogger {
+	return &writerBoltLogger{output: &logOutput{writer: writer}}
+}
+
+// logOutput writes lines, which concurrent writes do not interleave
+type logOutput struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func (l *logOutput) println(level, name, id, message string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	timestamp := time.Now().Format("2006-01-02 15:04:05.000")
+	_, _ = fmt.Fprintf(l.writer, "%s %5s [%s %s] %s\n", timestamp, level, name, id, message)
+}
+
+type writerLogger struct {
+	output *logOutput
+	level  LogLevel
+}
+
+func (w *writerLogger) Errorf(name, id string, msg string, args ...interface{}) {
+	w.log(ErrorLevel, name, id, msg, args)
+}
+
+func (w *writerLogger) Warnf(name, id string, msg string, args ...interface{}) {
+	w.log(WarningLevel, name, id, msg, args)
+}
+
+func (w *writerLogger) Infof(name, id string, msg string, args ...interface{}) {
+	w.log(InfoLevel, name, id, msg, args)
+}
+
+func (w *writerLogger) Debugf(name, id string, msg string, args ...interface{}) {
+	w.log(DebugLevel, name, id, msg, args)
+}
+
+func (w *writerLogger) log(level LogLevel, name, id string, msg string, args []interface{}) {
+	if level > w.level {
+		return
+	}
+	w.output.println(level.String(), name, id, fmt.Sprintf(msg, args...))
+}
+
+type writerBoltLogger struct {
+	output *logOutput
+}
+
+func (w *writerBoltLogger) LogClientMessage(connection string, message string) {
+	w.output.println("BOLT", "connection", connection, "C: "+message)
+}
+
+func (w *writerBoltLogger) LogServerMessage(connection string, message string) {
+	w.output.println("BOLT", "connection", connection, "S: "+message)
+}
+
+// voidLogger discards events, when no logger is configured
+type voidLogger struct{}
+
+func (voidLogger) Errorf(string, string, string, ...interface{}) {}
+
+func (voidLogger) Warnf(string, string, string, ...interface{}) {}
+
+func (voidLogger) Infof(string, string, string, ...interface{}) {}
+
+func (voidLogger) Debugf(string, string, string, ...interface{}) {}
@@ -0,0 +1,62 @@
+package neo4j_test
+
+import (
+	"bytes"
+	"github.com/fbiville/go-usain-go/pkg/bolttest"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	. "github.com/onsi/gomega"
+	"strings"
+	"testing"
+)
+
+func TestLogging(t *testing.T) {
+	RegisterTestingT(t)
+
+	script := []bolttest.Step{
+		bolttest.Expect("HELLO", bolttest.Success(map[string]interface{}{"server": "Neo4j/4.4.0", "connection_id": "bolt-12"})),
+		bolttest.ExpectRun("RETURN 42", bolttest.Success(map[string]interface{}{"fields": []string{"42"}})),
+		bolttest.Expect("PULL", bolttest.Record(42), bolttest.Success(nil)),
+	}
+
+	t.Run("logs Bolt messages without credentials", func(t *testing.T) {
+		server := bolttest.NewServer(t, script...)
+		output := &bytes.Buffer{}
+		driver, err := neo4j.NewDriver(server.URI(), username, password, func(config *neo4j.Config) {
+			config.BoltLogger = neo4j.NewBoltLogger(output)
+		})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = driver.Run("RETURN 42", neo4j.ReadAccessMode)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(driver.Close()).To(Succeed())
+
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		Expect(lines).To(HaveLen(8))
+		Expect(lines[0]).To(ContainSubstring("BOLT [connection %s] C: HELLO {", server.Address()))
+		Expect(lines[0]).To(ContainSubstring(`credentials: "******"`))
+		Expect(output.String()).NotTo(ContainSubstring(password))
+		Expect(lines[1]).To(HaveSuffix(`[connection %s] S: SUCCESS {connection_id: "bolt-12", server: "Neo4j/4.4.0"}`, server.Address()))
+		Expect(lines[2]).To(ContainSubstring(`[connection bolt-12@%s] C: RUN "RETURN 42" {} {`, server.Address()))
+		Expect(lines[2]).To(ContainSubstring(`mode: "r"`))
+		Expect(lines[3]).To(HaveSuffix("C: PULL {n: 1000}"))
+		Expect(lines[4]).To(HaveSuffix(`S: SUCCESS {fields: ["42"]}`))
+		Expect(lines[5]).To(HaveSuffix("S: RECORD [42]"))
+		Expect(lines[6]).To(HaveSuffix("S: SUCCESS {}"))
+		Expect(lines[7]).To(HaveSuffix("C: GOODBYE"))
+	})
+
+	t.Run("logs driver events up to the level", func(t *testing.T) {
+		server := bolttest.NewServer(t, script...)
+		output := &bytes.Buffer{}
+		driver, err := neo4j.NewDriver(server.URI(), username, password, func(config *neo4j.Config) {
+			config.Log = neo4j.NewLogger(output, neo4j.InfoLevel)
+		})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = driver.Run("RETURN 42", neo4j.ReadAccessMode)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(driver.Close()).To(Succeed())
+
+		Expect(output.String()).To(ContainSubstring(" INFO [driver bolt-12@%s] connected with protocol version 4.4 to Neo4j/4.4.0\n", server.Address()))
+		Expect(output.String()).To(ContainSubstring(" INFO [driver %s] closing\n", server.Address()))
+		Expect(output.String()).NotTo(ContainSubstring("DEBUG"))
+	})
+}
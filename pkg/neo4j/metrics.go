@@ -0,0 +1,80 @@
+package neo4j
+
+import "time"
+
+// Metrics receives measurements of the connection pool and of queries, to be
+// exported to a metrics stack. address is the host:port of the server the
+// measurement is about. Methods may be called concurrently and are expected
+// to return fast, as they are called on the query path.
+type Metrics interface {
+	// ConnectionCreated is called once a connection is authenticated
+	ConnectionCreated(address string)
+	// ConnectionClosed is called once a created connection is closed
+	ConnectionClosed(address string)
+	// ConnectionFailed is called when a connection cannot be created
+	ConnectionFailed(address string, err error)
+	// ConnectionAcquired reports how long acquiring a connection took,
+	// including the time to create it when no idle connection was available
+	ConnectionAcquired(address string, wait time.Duration)
+	// PoolGauges reports the connections currently in use and idle, whenever
+	// these numbers change
+	PoolGauges(address string, inUse, idle int)
+	// QueryCompleted reports how long a query took, until all its records
+	// were received, and how many records were streamed. err is nil when the
+	// query succeeded.
+	QueryCompleted(address, database string, duration time.Duration, records int, err error)
+	// BytesSent and BytesReceived count the bytes of the messages exchanged
+	// with the server, chunk headers included
+	BytesSent(address string, n int)
+	BytesReceived(address string, n int)
+}
+
+// NoopMetrics discards measurements, it is the default and can be embedded
+// by adapters only interested in some of the measurements
+type NoopMetrics struct{}
+
+func (NoopMetrics) ConnectionCreated(string) {}
+
+func (NoopMetrics) ConnectionClosed(string) {}
+
+func (NoopMetrics) ConnectionFailed(string, error) {}
+
+func (NoopMetrics) ConnectionAcquired(string, time.Duration) {}
+
+func (NoopMetrics) PoolGauges(string, int, int) {}
+
+func (NoopMetrics) QueryCompleted(string, string, time.Duration, int, error) {}
+
+func (NoopMetrics) BytesSent(string, int) {}
+
+func (NoopMetrics) BytesReceived(string, int) {}
+
+// byteCounter reports the bytes a connection exchanges
+type byteCounter struct {
+	metrics Metrics
+	address string
+}
+
+func (b *byteCounter) BytesSent(n int) {
+	b.metrics.BytesSent(b.address, n)
+}
+
+func (b *byteCounter) BytesReceived(n int) {
+	b.metrics.BytesReceived(b.address, n)
+}
+
+// queryMeasure measures a query until its result is consumed, and ends its
+// span
+type queryMeasure struct {
+	metrics  Metrics
+	span     Span
+	address  string
+	database string
+	started  time.Time
+	records  int
+}
+
+func (q *queryMeasure) completed(err error) {
+	q.metrics.QueryCompleted(q.address, q.database, time.Since(q.started), q.records, err)
+	q.span.End(err)
+}
@@ -0,0 +1,228 @@
+package neo4j_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/bolttest"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	. "github.com/onsi/gomega"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("measures connections and queries", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.ExpectRun("RETURN 42", bolttest.Success(map[string]interface{}{"fields": []string{"42"}})),
+			bolttest.Expect("PULL", bolttest.Record(42), bolttest.Success(nil)),
+		)
+		metrics := newRecordingMetrics()
+		driver, err := neo4j.NewDriver(server.URI(), username, password, func(config *neo4j.Config) {
+			config.Metrics = metrics
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = driver.Run("RETURN 42", neo4j.ReadAccessMode)
+
+		Expect(err).NotTo(HaveOccurred())
+		address := server.Address()
+		Expect(metrics.count("created " + address)).To(Equal(1))
+		Expect(metrics.count("acquired " + address)).To(Equal(2))
+		Expect(metrics.count("completed " + address + " records=1 err=<nil>")).To(Equal(1))
+		Expect(metrics.lastGauges(address)).To(Equal([2]int{0, 1}))
+		Expect(metrics.bytesSent(address)).To(BeNumerically(">", 0))
+		Expect(metrics.bytesReceived(address)).To(BeNumerically(">", 0))
+		Expect(driver.Close()).To(Succeed())
+		Expect(metrics.count("closed " + address)).To(Equal(1))
+		Expect(metrics.lastGauges(address)).To(Equal([2]int{0, 0}))
+	})
+
+	t.Run("measures failed queries", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.ExpectRun("RETURN x", bolttest.Failure("Neo.ClientError.Statement.SyntaxError", "invalid query")),
+			bolttest.Expect("PULL", bolttest.Ignored()),
+			bolttest.Expect("RESET", bolttest.Success(nil)),
+		)
+		metrics := newRecordingMetrics()
+		driver, err := neo4j.NewDriver(server.URI(), username, password, func(config *neo4j.Config) {
+			config.Metrics = metrics
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+
+		_, err = driver.Run("RETURN x", neo4j.ReadAccessMode)
+
+		Expect(err).To(HaveOccurred())
+		Expect(metrics.count("completed " + server.Address() + " records=0 err=" + err.Error())).To(Equal(1))
+		// the connection is reset rather than closed
+		Expect(metrics.count("closed " + server.Address())).To(Equal(0))
+	})
+
+	t.Run("measures connection failures", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		address := listener.Addr().String()
+		Expect(listener.Close()).To(Succeed())
+		metrics := newRecordingMetrics()
+
+		_, err = neo4j.NewDriver("bolt://"+address, username, password, func(config *neo4j.Config) {
+			config.Metrics = metrics
+		})
+
+		Expect(err).To(HaveOccurred())
+		Expect(metrics.count("failed " + address)).To(Equal(1))
+	})
+
+	t.Run("reports pool gauges without holding the pool", func(t *testing.T) {
+		server := bolttest.NewServer(t, bolttest.ExpectHello("Neo4j/4.4.0"))
+		metrics := &reentrantMetrics{}
+		driver, err := neo4j.NewDriver(server.URI(), username, password, func(config *neo4j.Config) {
+			config.Metrics = metrics
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		metrics.setDriver(driver)
+		verified := make(chan error, 1)
+
+		go func() {
+			verified <- driver.VerifyConnectivity(context.Background())
+		}()
+
+		Eventually(verified).Should(Receive(BeNil()))
+		metrics.mutex.Lock()
+		defer metrics.mutex.Unlock()
+		Expect(metrics.err).NotTo(HaveOccurred())
+	})
+}
+
+// reentrantMetrics uses the driver once told about pool gauges, which only
+// works when the pool is not locked meanwhile
+type reentrantMetrics struct {
+	neo4j.NoopMetrics
+	mutex  sync.Mutex
+	driver *neo4j.Driver
+	err    error
+}
+
+func (r *reentrantMetrics) setDriver(driver *neo4j.Driver) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.driver = driver
+}
+
+func (r *reentrantMetrics) PoolGauges(string, int, int) {
+	r.mutex.Lock()
+	driver := r.driver
+	r.driver = nil
+	r.mutex.Unlock()
+	if driver == nil {
+		return
+	}
+	err := driver.VerifyConnectivity(context.Background())
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.err = err
+}
+
+// recordingMetrics records measurements as strings, byte counts and pool
+// gauges aside
+type recordingMetrics struct {
+	neo4j.NoopMetrics
+	mutex    sync.Mutex
+	events   []string
+	gauges   map[string][2]int
+	sent     map[string]int
+	received map[string]int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{
+		gauges:   make(map[string][2]int),
+		sent:     make(map[string]int),
+		received: make(map[string]int),
+	}
+}
+
+func (r *recordingMetrics) record(event string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recordingMetrics) count(event string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	result := 0
+	for _, recorded := range r.events {
+		if recorded == event {
+			result++
+		}
+	}
+	return result
+}
+
+func (r *recordingMetrics) ConnectionCreated(address string) {
+	r.record("created " + address)
+}
+
+func (r *recordingMetrics) ConnectionClosed(address string) {
+	r.record("closed " + address)
+}
+
+func (r *recordingMetrics) ConnectionFailed(address string, _ error) {
+	r.record("failed " + address)
+}
+
+func (r *recordingMetrics) ConnectionAcquired(address string, _ time.Duration) {
+	r.record("acquired " + address)
+}
+
+func (r *recordingMetrics) QueryCompleted(address, _ string, _ time.Duration, records int, err error) {
+	errorMessage := "<nil>"
+	if err != nil {
+		errorMessage = err.Error()
+	}
+	r.record(fmt.Sprintf("completed %s records=%d err=%s", address, records, errorMessage))
+}
+
+func (r *recordingMetrics) PoolGauges(address string, inUse, idle int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.gauges[address] = [2]int{inUse, idle}
+}
+
+func (r *recordingMetrics) lastGauges(address string) [2]int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.gauges[address]
+}
+
+func (r *recordingMetrics) BytesSent(address string, n int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sent[address] += n
+}
+
+func (r *recordingMetrics) bytesSent(address string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.sent[address]
+}
+
+func (r *recordingMetrics) BytesReceived(address string, n int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.received[address] += n
+}
+
+func (r *recordingMetrics) bytesReceived(address string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.received[address]
+}
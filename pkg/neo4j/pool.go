@@ -0,0 +1,231 @@
+package neo4j
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/internal/bolt"
+	"sync"
+	"time"
+)
+
+type connectFunc func(ctx context.Context, address string) (*bolt.Connector, error)
+
+// pool keeps idle connections per server address
+type pool struct {
+	mutex   sync.Mutex
+	connect connectFunc
+	idle    map[string][]*bolt.Connector
+	inUse   map[*bolt.Connector]struct{}
+	// inUsePerAddress counts the connections in use per server address
+	inUsePerAddress map[string]int
+	closed          bool
+	log             Logger
+	metrics         Metrics
+}
+
+func newPool(connect connectFunc, log Logger, metrics Metrics) *pool {
+	return &pool{
+		connect:         connect,
+		log:             log,
+		metrics:         metrics,
+		idle:            make(map[string][]*bolt.Connector),
+		inUse:           make(map[*bolt.Connector]struct{}),
+		inUsePerAddress: make(map[string]int),
+	}
+}
+
+func (p *pool) acquire(ctx context.Context, address string) (*bolt.Connector, error) {
+	started := time.Now()
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil, ErrDriverClosed
+	}
+	idle := p.idle[address]
+	if idleCount := len(idle); idleCount > 0 {
+		connector := idle[idleCount-1]
+		p.idle[address] = idle[:idleCount-1]
+		gauges := p.markInUse(connector)
+		p.mutex.Unlock()
+		p.reportGauges(gauges)
+		p.metrics.ConnectionAcquired(address, time.Since(started))
+		return connector, nil
+	}
+	p.mutex.Unlock()
+	return p.open(ctx, address, started)
+}
+
+// acquireNew always opens a new connection, bypassing idle ones
+func (p *pool) acquireNew(ctx context.Context, address string) (*bolt.Connector, error) {
+	return p.open(ctx, address, time.Now())
+}
+
+func (p *pool) open(ctx context.Context, address string, started time.Time) (*bolt.Connector, error) {
+	p.mutex.Lock()
+	closed := p.closed
+	p.mutex.Unlock()
+	if closed {
+		return nil, ErrDriverClosed
+	}
+	connector, err := p.connect(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		p.discard(connector, false)
+		return nil, ErrDriverClosed
+	}
+	gauges := p.markInUse(connector)
+	p.mutex.Unlock()
+	p.reportGauges(gauges)
+	p.metrics.ConnectionAcquired(address, time.Since(started))
+	return connector, nil
+}
+
+// release puts the connection back in the pool, unless it is in an unknown
+// state after a failure or the pool has been closed in the meantime.
+// Connections are reset after server failures, which leave them usable.
+func (p *pool) release(connector *bolt.Connector, failure error) {
+	p.mutex.Lock()
+	_, found := p.inUse[connector]
+	p.mutex.Unlock()
+	if !found {
+		return
+	}
+	// RESET is sent without the mutex, not to block the pool on the network
+	failure = reset(connector, failure)
+	p.mutex.Lock()
+	if _, found := p.inUse[connector]; !found {
+		p.mutex.Unlock()
+		return
+	}
+	delete(p.inUse, connector)
+	address := connector.Address()
+	p.inUsePerAddress[address]--
+	closed := p.closed
+	if failure == nil && !closed {
+		p.idle[address] = append(p.idle[address], connector)
+	}
+	gauges := p.gauges(address)
+	p.mutex.Unlock()
+	p.reportGauges(gauges)
+	if failure != nil {
+		p.log.Debugf("pool", connector.LogContext(), "closing connection after failure: %v", failure)
+		p.discard(connector, false)
+		return
+	}
+	if closed {
+		p.discard(connector, true)
+	}
+}
+
+// inUseCount returns the number of connections to the server currently used
+// by sessions
+func (p *pool) inUseCount(address string) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.inUsePerAddress[address]
+}
+
+// purge closes the idle connections to the server
+func (p *pool) purge(address string) {
+	p.mutex.Lock()
+	idle := p.idle[address]
+	delete(p.idle, address)
+	gauges := p.gauges(address)
+	p.mutex.Unlock()
+	p.reportGauges(gauges)
+	if len(idle) > 0 {
+		p.log.Debugf("pool", address, "closing %d idle connections", len(idle))
+	}
+	for _, connector := range idle {
+		p.discard(connector, false)
+	}
+}
+
+// close says GOODBYE to idle connections and forcibly closes the connections
+// still in use
+func (p *pool) close() {
+	p.mutex.Lock()
+	p.closed = true
+	addresses := make(map[string]struct{}, len(p.idle))
+	var idle, inUse []*bolt.Connector
+	for address, connectors := range p.idle {
+		addresses[address] = struct{}{}
+		idle = append(idle, connectors...)
+	}
+	p.idle = make(map[string][]*bolt.Connector)
+	for connector := range p.inUse {
+		addresses[connector.Address()] = struct{}{}
+		inUse = append(inUse, connector)
+	}
+	p.inUse = make(map[*bolt.Connector]struct{})
+	p.inUsePerAddress = make(map[string]int)
+	p.mutex.Unlock()
+	for address := range addresses {
+		p.reportGauges(poolGauges{address: address})
+	}
+	// GOODBYE is sent without the mutex, not to block the pool on the network
+	for _, connector := range idle {
+		p.discard(connector, true)
+	}
+	for _, connector := range inUse {
+		p.discard(connector, false)
+	}
+}
+
+// markInUse returns the gauges of the server, with the mutex held
+func (p *pool) markInUse(connector *bolt.Connector) poolGauges {
+	p.inUse[connector] = struct{}{}
+	p.inUsePerAddress[connector.Address()]++
+	return p.gauges(connector.Address())
+}
+
+// poolGauges is a snapshot of the connections to a server, taken with the
+// mutex held and reported once it is released, so that metrics callbacks do
+// not block the pool
+type poolGauges struct {
+	address string
+	inUse   int
+	idle    int
+}
+
+// gauges returns the connections to the server, with the mutex held
+func (p *pool) gauges(address string) poolGauges {
+	return poolGauges{address: address, inUse: p.inUsePerAddress[address], idle: len(p.idle[address])}
+}
+
+func (p *pool) reportGauges(gauges poolGauges) {
+	p.metrics.PoolGauges(gauges.address, gauges.inUse, gauges.idle)
+}
+
+// discard closes the connection, saying GOODBYE first when graceful
+func (p *pool) discard(connector *bolt.Connector, graceful bool) {
+	if graceful {
+		closeGracefully(connector)
+	} else {
+		_ = connector.Close()
+	}
+	p.metrics.ConnectionClosed(connector.Address())
+}
+
+// reset recovers the connection from the server failure, and returns the
+// failure leaving it in an unknown state, if any
+func reset(connector *bolt.Connector, failure error) error {
+	var serverError *bolt.ServerError
+	if failure == nil || !errors.As(failure, &serverError) {
+		return failure
+	}
+	if err := connector.Reset(); err != nil {
+		return fmt.Errorf("could not reset connection after %v: %w", failure, err)
+	}
+	return nil
+}
+
+func closeGracefully(connector *bolt.Connector) {
+	_ = connector.SendGoodbye()
+	_ = connector.Close()
+}
@@ -0,0 +1,143 @@
+package neo4j
+
+import (
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/internal/bolt"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+)
+
+type Record struct {
+	Keys   []string
+	Values []packstream.Value
+}
+
+// Get returns the value associated to the key, if any
+func (r *Record) Get(key string) (packstream.Value, bool) {
+	for i, recordKey := range r.Keys {
+		if recordKey == key {
+			return r.Values[i], true
+		}
+	}
+	return nil, false
+}
+
+// FormatValue renders a record value on a single line, the way Cypher
+// literals read, such as {name: "Alice", tags: ["a", "b"]}
+func FormatValue(value packstream.Value) string {
+	return bolt.FormatValue(value)
+}
+
+// Result streams the records of a query. The underlying connection is
+// released as soon as all records have been received.
+type Result struct {
+	connector *bolt.Connector
+	release   func(*bolt.Connector, error)
+	keys      []string
+	record    *Record
+	summary   *ResultSummary
+	err       error
+	measure   *queryMeasure
+}
+
+func newResult(connector *bolt.Connector, release func(*bolt.Connector, error), query string, measure *queryMeasure) (*Result, error) {
+	success, err := connector.ReceiveSuccess()
+	if err != nil {
+		measure.completed(err)
+		release(connector, err)
+		return nil, err
+	}
+	metadata := successMetadata(success)
+	result := &Result{
+		connector: connector,
+		release:   release,
+		measure:   measure,
+		keys:      metadataStrings(metadata, "fields"),
+		summary: &ResultSummary{
+			Query:  query,
+			Server: serverInfo(connector),
+		},
+	}
+	result.summary.ResultAvailableAfter = metadataMilliseconds(metadata, "t_first")
+	return result, nil
+}
+
+func (r *Result) Keys() []string {
+	return r.keys
+}
+
+// Next moves to the next record, it returns false once all records have been
+// received or an error occurred (see Err)
+func (r *Result) Next() bool {
+	r.record = nil
+	if r.connector == nil {
+		return false
+	}
+	for {
+		response, err := r.connector.Receive()
+		if err != nil {
+			r.finish(err)
+			return false
+		}
+		switch response.Name() {
+		case "RECORD":
+			r.measure.records++
+			r.record = &Record{
+				Keys:   r.keys,
+				Values: *response.Fields[0].(*packstream.List),
+			}
+			return true
+		case "SUCCESS":
+			metadata := successMetadata(response)
+			if hasMore, _ := metadata.Get("has_more").(packstream.Boolean); hasMore {
+				if err := r.connector.SendPull(bolt.DefaultFetchSize); err != nil {
+					r.finish(err)
+					return false
+				}
+				continue
+			}
+			r.summary.complete(metadata)
+			r.finish(nil)
+			return false
+		default:
+			r.finish(fmt.Errorf("expected RECORD or SUCCESS but got %v", response))
+			return false
+		}
+	}
+}
+
+// Record returns the current record, after a successful call to Next
+func (r *Result) Record() *Record {
+	return r.record
+}
+
+// Err returns the error that interrupted the record stream, if any
+func (r *Result) Err() error {
+	return r.err
+}
+
+// Consume discards the remaining records and returns the summary sent by
+// the server once all records have been streamed
+func (r *Result) Consume() (*ResultSummary, error) {
+	for r.Next() {
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.summary, nil
+}
+
+func (r *Result) finish(err error) {
+	r.measure.completed(err)
+	r.err = err
+	r.release(r.connector, err)
+	r.connector = nil
+}
+
+func successMetadata(success *packstream.Structure) *packstream.OrderedDictionary {
+	if len(success.Fields) > 0 {
+		if metadata, casted := success.Fields[0].(*packstream.OrderedDictionary); casted {
+			return metadata
+		}
+	}
+	return &packstream.OrderedDictionary{}
+}
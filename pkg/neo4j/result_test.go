@@ -0,0 +1,202 @@
+package neo4j_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/bolttest"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	. "github.com/onsi/gomega"
+	"testing"
+	"time"
+)
+
+func TestResult(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("streams records", func(t *testing.T) {
+		driver := newScriptedDriver(t,
+			bolttest.ExpectRun("UNWIND [1, 2] AS n RETURN n", bolttest.Success(map[string]interface{}{"fields": []string{"n"}})),
+			bolttest.Expect("PULL", bolttest.Record(1), bolttest.Record(2), bolttest.Success(nil)),
+		)
+		session, err := driver.NewSession(neo4j.SessionConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		defer session.Close()
+
+		result, err := session.Run("UNWIND [1, 2] AS n RETURN n")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(result.Keys()).To(Equal([]string{"n"}))
+		var values []packstream.Value
+		for result.Next() {
+			value, found := result.Record().Get("n")
+			Expect(found).To(BeTrue())
+			values = append(values, value)
+		}
+		Expect(result.Err()).NotTo(HaveOccurred())
+		Expect(values).To(Equal([]packstream.Value{packstream.Integer(1), packstream.Integer(2)}))
+	})
+
+	t.Run("consumes summary", func(t *testing.T) {
+		query := "PROFILE CREATE (n), (m) SET n.a = 1, n.b = 2, m.a = 1, m.b = 2 RETURN n"
+		driver := newScriptedDriver(t,
+			bolttest.ExpectRun(query, bolttest.Success(map[string]interface{}{"t_first": 3})),
+			bolttest.Expect("PULL", bolttest.Record(1), bolttest.Success(map[string]interface{}{
+				"t_last":   5,
+				"type":     "rw",
+				"db":       "neo4j",
+				"bookmark": "FB:kcwQ",
+				"stats": map[string]interface{}{
+					"nodes-created":    2,
+					"properties-set":   4,
+					"contains-updates": true,
+				},
+				"notifications": []interface{}{map[string]interface{}{
+					"code":        "Neo.ClientNotification.Statement.CartesianProduct",
+					"title":       "cartesian product",
+					"description": "a cartesian product is built",
+					"severity":    "WARNING",
+					"position":    map[string]interface{}{"offset": 0, "line": 1, "column": 1},
+				}},
+				"profile": map[string]interface{}{
+					"operatorType":      "ProduceResults",
+					"identifiers":       []string{"n"},
+					"args":              map[string]interface{}{"EstimatedRows": 1.0},
+					"dbHits":            0,
+					"rows":              1,
+					"pageCacheHitRatio": 0.5,
+					"children": []interface{}{map[string]interface{}{
+						"operatorType": "Create",
+						"dbHits":       6,
+					}},
+				},
+			})),
+		)
+		session, err := driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.WriteAccessMode})
+		Expect(err).NotTo(HaveOccurred())
+		defer session.Close()
+		result, err := session.Run(query)
+		Expect(err).NotTo(HaveOccurred())
+
+		summary, err := result.Consume()
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(summary.Query).To(HavePrefix("PROFILE CREATE"))
+		Expect(summary.QueryType).To(Equal(neo4j.QueryTypeReadWrite))
+		Expect(summary.ResultAvailableAfter).To(Equal(3 * time.Millisecond))
+		Expect(summary.ResultConsumedAfter).To(Equal(5 * time.Millisecond))
+		Expect(summary.Database).To(Equal("neo4j"))
+		Expect(summary.Bookmark).To(Equal("FB:kcwQ"))
+		Expect(summary.Server.Agent).To(Equal("Neo4j/4.4.0"))
+		Expect(summary.Counters.NodesCreated).To(Equal(int64(2)))
+		Expect(summary.Counters.PropertiesSet).To(Equal(int64(4)))
+		Expect(summary.Counters.ContainsUpdates()).To(BeTrue())
+		Expect(summary.Counters.ContainsSystemUpdates()).To(BeFalse())
+		Expect(summary.Notifications).To(Equal([]neo4j.Notification{{
+			Code:        "Neo.ClientNotification.Statement.CartesianProduct",
+			Title:       "cartesian product",
+			Description: "a cartesian product is built",
+			Severity:    "WARNING",
+			Position:    &neo4j.InputPosition{Offset: 0, Line: 1, Column: 1},
+		}}))
+		Expect(summary.Plan.Operator).To(Equal("ProduceResults"))
+		Expect(summary.Plan.Children[0].Operator).To(Equal("Create"))
+		Expect(summary.Profile.Identifiers).To(Equal([]string{"n"}))
+		Expect(summary.Profile.Arguments).To(Equal(map[string]packstream.Value{"EstimatedRows": packstream.Float(1.0)}))
+		Expect(summary.Profile.Records).To(Equal(int64(1)))
+		Expect(summary.Profile.PageCacheHitRatio).To(Equal(0.5))
+		Expect(summary.Profile.Children[0].DbHits).To(Equal(int64(6)))
+	})
+
+	t.Run("surfaces failures", func(t *testing.T) {
+		driver := newScriptedDriver(t,
+			bolttest.ExpectRun("RETURN 1/0", bolttest.Success(nil)),
+			bolttest.Expect("PULL", bolttest.Failure("Neo.ClientError.Statement.ArithmeticError", "/ by zero")),
+			bolttest.Expect("RESET", bolttest.Success(nil)),
+		)
+		session, err := driver.NewSession(neo4j.SessionConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		defer session.Close()
+		result, err := session.Run("RETURN 1/0")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = result.Consume()
+
+		Expect(err).To(Equal(&neo4j.Neo4jError{Code: "Neo.ClientError.Statement.ArithmeticError", Message: "/ by zero"}))
+	})
+}
+
+func TestResultLimits(t *testing.T) {
+	RegisterTestingT(t)
+
+	server := bolttest.NewServer(t,
+		bolttest.ExpectHello("Neo4j/4.4.0"),
+		bolttest.ExpectRun("RETURN [1, 2, 3]", bolttest.Success(nil)),
+		bolttest.Expect("PULL", bolttest.Record([]int{1, 2, 3}), bolttest.Success(nil)),
+	)
+	driver, err := neo4j.NewDriver(server.URI(), username, password, func(config *neo4j.Config) {
+		config.MaxCollectionLength = 2
+	})
+	Expect(err).NotTo(HaveOccurred())
+	defer driver.Close()
+	session, err := driver.NewSession(neo4j.SessionConfig{})
+	Expect(err).NotTo(HaveOccurred())
+	defer session.Close()
+	result, err := session.Run("RETURN [1, 2, 3]")
+	Expect(err).NotTo(HaveOccurred())
+
+	_, err = result.Consume()
+
+	var protocolError *neo4j.ProtocolError
+	Expect(errors.As(err, &protocolError)).To(BeTrue(), "expected protocol error, got %v", err)
+	Expect(protocolError.Message).To(Equal("list of 3 entries exceeds the maximum of 2"))
+}
+
+// point stands for a user-defined structure
+type point struct {
+	x, y int64
+}
+
+func (p point) Pack() []byte {
+	return (&neo4j.Structure{TagByte: 0x56, Fields: []neo4j.Value{neo4j.Integer(p.x), neo4j.Integer(p.y)}}).Pack()
+}
+
+func (p point) String() string {
+	return fmt.Sprintf("point(%d, %d)", p.x, p.y)
+}
+
+func TestResultStructures(t *testing.T) {
+	RegisterTestingT(t)
+
+	structures := neo4j.NewStructures()
+	Expect(structures.Register(0x56, "POINT", func(structure *neo4j.Structure) (neo4j.Value, error) {
+		return point{x: int64(structure.Fields[0].(neo4j.Integer)), y: int64(structure.Fields[1].(neo4j.Integer))}, nil
+	}, nil)).To(Succeed())
+	server := bolttest.NewServer(t,
+		bolttest.ExpectHello("Neo4j/4.4.0"),
+		bolttest.ExpectRun("RETURN $p", bolttest.Success(map[string]interface{}{"fields": []string{"p"}})),
+		bolttest.Expect("PULL", bolttest.Record(&neo4j.Structure{TagByte: 0x56, Fields: []neo4j.Value{neo4j.Integer(1), neo4j.Integer(2)}}), bolttest.Success(nil)),
+	)
+	driver, err := neo4j.NewDriver(server.URI(), username, password, func(config *neo4j.Config) {
+		config.Structures = structures
+	})
+	Expect(err).NotTo(HaveOccurred())
+	defer driver.Close()
+
+	record, err := driver.Run("RETURN $p", neo4j.ReadAccessMode)
+
+	Expect(err).NotTo(HaveOccurred())
+	Expect(record).To(Equal(&neo4j.List{point{x: 1, y: 2}}))
+}
+
+// newScriptedDriver connects a driver to a server following the script once
+// HELLO is answered, the driver is closed once the test completes
+func newScriptedDriver(t *testing.T, script ...bolttest.Step) *neo4j.Driver {
+	server := bolttest.NewServer(t, append([]bolttest.Step{bolttest.ExpectHello("Neo4j/4.4.0")}, script...)...)
+	driver, err := neo4j.NewDriver(server.URI(), username, password)
+	Expect(err).NotTo(HaveOccurred())
+	t.Cleanup(func() {
+		Expect(driver.Close()).To(Succeed())
+	})
+	return driver
+}
@@ -0,0 +1,333 @@
+package neo4j
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/internal/bolt"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	"sync"
+	"time"
+)
+
+// connectionProvider picks the server a query should be sent to
+type connectionProvider interface {
+	acquire(ctx context.Context, accessMode AccessMode, database string) (*bolt.Connector, error)
+	release(connector *bolt.Connector, failure error)
+}
+
+// directProvider connects to the given servers, spreading reads over all of
+// them and sending writes to the first one
+type directProvider struct {
+	pool      *pool
+	balancer  *balancer
+	addresses []string
+}
+
+func (d *directProvider) acquire(ctx context.Context, accessMode AccessMode, _ string) (*bolt.Connector, error) {
+	if accessMode == WriteAccessMode {
+		return d.pool.acquire(ctx, d.addresses[0])
+	}
+	return d.balancer.acquire(ctx, d.addresses, func(string) {})
+}
+
+func (d *directProvider) release(connector *bolt.Connector, failure error) {
+	d.pool.release(connector, failure)
+}
+
+type routingTable struct {
+	routers   []string
+	readers   []string
+	writers   []string
+	expiresAt time.Time
+}
+
+func (t *routingTable) expired(accessMode AccessMode) bool {
+	if time.Now().After(t.expiresAt) || len(t.routers) == 0 {
+		return true
+	}
+	if accessMode == WriteAccessMode {
+		return len(t.writers) == 0
+	}
+	return len(t.readers) == 0
+}
+
+// servers returns a copy of the servers for the access mode
+func (t *routingTable) servers(accessMode AccessMode) []string {
+	if accessMode == WriteAccessMode {
+		return append([]string(nil), t.writers...)
+	}
+	return append([]string(nil), t.readers...)
+}
+
+func (t *routingTable) forget(address string) {
+	t.routers = without(t.routers, address)
+	t.readers = without(t.readers, address)
+	t.writers = without(t.writers, address)
+}
+
+// router keeps a routing table per database, fetched from the cluster
+// members acting as routers, and sends reads to readers and writes to
+// writers
+type router struct {
+	pool           *pool
+	balancer       *balancer
+	seedRouters    []string
+	resolver       ServerAddressResolver
+	routingContext map[string]string
+	mutex          sync.Mutex
+	tables         map[string]*routingTable
+	// refreshes holds, per database, the channel closed once the routing
+	// table being fetched is available, so that it is fetched only once at
+	// a time and without holding the mutex
+	refreshes map[string]chan struct{}
+	log       Logger
+}
+
+func newRouter(connectionPool *pool, strategy LoadBalancingStrategy, seedRouters []string, resolver ServerAddressResolver, routingContext map[string]string, log Logger) *router {
+	return &router{
+		pool:           connectionPool,
+		balancer:       &balancer{pool: connectionPool, strategy: strategy},
+		seedRouters:    seedRouters,
+		resolver:       resolver,
+		routingContext: routingContext,
+		tables:         make(map[string]*routingTable),
+		refreshes:      make(map[string]chan struct{}),
+		log:            log,
+	}
+}
+
+func (r *router) acquire(ctx context.Context, accessMode AccessMode, database string) (*bolt.Connector, error) {
+	candidates, err := r.servers(ctx, accessMode, database)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := r.balancer.acquire(ctx, candidates, r.forget)
+	if err != nil && !errors.Is(err, ErrDriverClosed) {
+		return nil, fmt.Errorf("could not connect to any %s server of database %q: %w", accessMode.role(), database, err)
+	}
+	return connector, err
+}
+
+// release forgets about servers that failed or that are no longer able to
+// accept writes
+func (r *router) release(connector *bolt.Connector, failure error) {
+	r.pool.release(connector, failure)
+	if failure == nil {
+		return
+	}
+	var serverError *bolt.ServerError
+	if !errors.As(failure, &serverError) {
+		r.forget(connector.Address())
+		return
+	}
+	switch serverError.Code {
+	case "Neo.ClientError.Cluster.NotALeader", "Neo.ClientError.General.ForbiddenOnReadOnlyDatabase":
+		r.forgetWriter(connector.Address())
+	case "Neo.TransientError.General.DatabaseUnavailable":
+		r.forget(connector.Address())
+	}
+}
+
+func (r *router) forget(address string) {
+	r.log.Warnf("router", address, "forgetting unavailable server")
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, table := range r.tables {
+		table.forget(address)
+	}
+	r.pool.purge(address)
+}
+
+func (r *router) forgetWriter(address string) {
+	r.log.Warnf("router", address, "forgetting server no longer accepting writes")
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, table := range r.tables {
+		table.writers = without(table.writers, address)
+	}
+}
+
+// servers returns the servers of the database for the access mode, from its
+// routing table, which is refreshed first when it is expired or lacks servers
+// for the access mode
+func (r *router) servers(ctx context.Context, accessMode AccessMode, database string) ([]string, error) {
+	for {
+		r.mutex.Lock()
+		table, found := r.tables[database]
+		if found && !table.expired(accessMode) {
+			servers := table.servers(accessMode)
+			r.mutex.Unlock()
+			return servers, nil
+		}
+		if refresh, refreshing := r.refreshes[database]; refreshing {
+			r.mutex.Unlock()
+			select {
+			case <-refresh:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		refresh := make(chan struct{})
+		r.refreshes[database] = refresh
+		var routers []string
+		if found {
+			routers = append(routers, table.routers...)
+		}
+		r.mutex.Unlock()
+
+		newTable, err := r.refresh(ctx, routers, database)
+		r.mutex.Lock()
+		delete(r.refreshes, database)
+		close(refresh)
+		if err != nil {
+			r.mutex.Unlock()
+			return nil, err
+		}
+		r.tables[database] = newTable
+		r.log.Infof("router", database, "updated routing table: routers %v, readers %v, writers %v", newTable.routers, newTable.readers, newTable.writers)
+		servers := newTable.servers(accessMode)
+		r.mutex.Unlock()
+		return servers, nil
+	}
+}
+
+// refresh fetches the routing table of the database from the known routers
+func (r *router) refresh(ctx context.Context, routers []string, database string) (*routingTable, error) {
+	// the seed routers are the last resort when all known routers are gone,
+	// they are resolved again as the servers they stand for may have changed
+	seedRouters, err := resolve(r.resolver, r.seedRouters)
+	if err != nil && len(routers) == 0 {
+		return nil, err
+	}
+	return r.fetchTable(ctx, append(routers, seedRouters...), database)
+}
+
+func (r *router) fetchTable(ctx context.Context, routers []string, database string) (*routingTable, error) {
+	var lastErr error
+	visited := make(map[string]struct{}, len(routers))
+	for _, address := range routers {
+		if _, found := visited[address]; found {
+			continue
+		}
+		visited[address] = struct{}{}
+		table, err := r.fetchTableFrom(ctx, address, database)
+		if err == nil {
+			return table, nil
+		}
+		if errors.Is(err, ErrDriverClosed) {
+			return nil, err
+		}
+		r.log.Warnf("router", address, "could not fetch routing table of database %q: %v", database, err)
+		lastErr = err
+	}
+	return nil, fmt.Errorf("could not fetch routing table of database %q: %w", database, lastErr)
+}
+
+func (r *router) fetchTableFrom(ctx context.Context, address string, database string) (*routingTable, error) {
+	connector, err := r.pool.acquire(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	var table *routingTable
+	if connector.SupportsRoute() {
+		table, err = r.route(connector, database)
+	} else {
+		table, err = r.callRoutingProcedure(connector, database)
+	}
+	r.pool.release(connector, err)
+	return table, err
+}
+
+func (r *router) route(connector *bolt.Connector, database string) (*routingTable, error) {
+	if err := connector.SendRoute(r.routingContext, database); err != nil {
+		return nil, err
+	}
+	success, err := connector.ReceiveSuccess()
+	if err != nil {
+		return nil, err
+	}
+	rawTable, casted := successMetadata(success).Get("rt").(*packstream.OrderedDictionary)
+	if !casted {
+		return nil, fmt.Errorf("expected routing table in ROUTE response, got %v", success)
+	}
+	return parseRoutingTable(rawTable.Get("ttl"), rawTable.Get("servers"))
+}
+
+// callRoutingProcedure fetches the routing table on servers predating ROUTE
+func (r *router) callRoutingProcedure(connector *bolt.Connector, database string) (*routingTable, error) {
+	var databaseValue packstream.Value = packstream.NilInstance()
+	if database != "" {
+		name := packstream.String(database)
+		databaseValue = &name
+	}
+	routingContext := packstream.Dictionary{}
+	for key, value := range r.routingContext {
+		stringValue := packstream.String(value)
+		routingContext[key] = []packstream.Value{&stringValue}
+	}
+	parameters := &packstream.Dictionary{
+		"context":  []packstream.Value{&routingContext},
+		"database": []packstream.Value{databaseValue},
+	}
+	query := "CALL dbms.routing.getRoutingTable($context, $database)"
+	if err := connector.SendRun(query, parameters, ReadAccessMode.String(), "system"); err != nil {
+		return nil, err
+	}
+	if _, err := connector.ReceiveSuccess(); err != nil {
+		return nil, err
+	}
+	record, err := connector.ReceiveRecord()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := connector.ReceiveSuccess(); err != nil {
+		return nil, err
+	}
+	if len(*record) < 2 {
+		return nil, fmt.Errorf("expected ttl and servers in routing procedure record, got %v", record)
+	}
+	return parseRoutingTable((*record)[0], (*record)[1])
+}
+
+func parseRoutingTable(rawTtl packstream.Value, rawServers packstream.Value) (*routingTable, error) {
+	ttl, casted := rawTtl.(packstream.Integer)
+	if !casted {
+		return nil, fmt.Errorf("expected routing table ttl to be an integer, got %v", rawTtl)
+	}
+	servers, casted := rawServers.(*packstream.List)
+	if !casted {
+		return nil, fmt.Errorf("expected routing table servers to be a list, got %v", rawServers)
+	}
+	result := &routingTable{expiresAt: time.Now().Add(time.Duration(ttl) * time.Second)}
+	for _, rawServer := range *servers {
+		server, casted := rawServer.(*packstream.OrderedDictionary)
+		if !casted {
+			return nil, fmt.Errorf("expected routing table server to be a dictionary, got %v", rawServer)
+		}
+		addresses := metadataStrings(server, "addresses")
+		for i, address := range addresses {
+			addresses[i] = bolt.ParseAddress(address)
+		}
+		switch metadataString(server, "role") {
+		case "ROUTE":
+			result.routers = append(result.routers, addresses...)
+		case "READ":
+			result.readers = append(result.readers, addresses...)
+		case "WRITE":
+			result.writers = append(result.writers, addresses...)
+		}
+	}
+	return result, nil
+}
+
+func without(addresses []string, address string) []string {
+	result := make([]string, 0, len(addresses))
+	for _, candidate := range addresses {
+		if candidate != address {
+			result = append(result, candidate)
+		}
+	}
+	return result
+}
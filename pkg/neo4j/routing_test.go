@@ -0,0 +1,235 @@
+package neo4j_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/bolttest"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	. "github.com/onsi/gomega"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestRouting(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("routes reads to readers and writes to writers", func(t *testing.T) {
+		cluster := cluster{
+			"router:7687": bolttest.NewServer(t,
+				bolttest.ExpectHello("Neo4j/4.4.0"),
+				bolttest.Expect("ROUTE", routingTable(300, []string{"router:7687"}, []string{"reader:7687"}, []string{"writer:7687"})),
+			),
+			"reader:7687": bolttest.NewServer(t, steps([]bolttest.Step{bolttest.ExpectHello("Neo4j/4.4.0")}, expectQuery("RETURN 42", 42))...),
+			"writer:7687": bolttest.NewServer(t, steps([]bolttest.Step{bolttest.ExpectHello("Neo4j/4.4.0")}, expectQuery("CREATE ()"))...),
+		}
+		driver, err := neo4j.NewDriver("neo4j://router:7687", username, password, cluster.dial)
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+
+		_, err = driver.Run("RETURN 42", neo4j.ReadAccessMode)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = driver.Run("CREATE ()", neo4j.WriteAccessMode)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(cluster["router:7687"].Received()).To(Equal([]string{"HELLO", "ROUTE"}))
+		Expect(cluster["reader:7687"].Received()).To(Equal([]string{"HELLO", "RUN", "PULL"}))
+		Expect(cluster["writer:7687"].Received()).To(Equal([]string{"HELLO", "RUN", "PULL"}))
+	})
+
+	t.Run("forgets writers that are no longer leaders", func(t *testing.T) {
+		cluster := cluster{
+			"router:7687": bolttest.NewServer(t,
+				bolttest.ExpectHello("Neo4j/4.4.0"),
+				bolttest.Expect("ROUTE", routingTable(300, []string{"router:7687"}, []string{"leader:7687"}, []string{"follower:7687"})),
+				bolttest.Expect("ROUTE", routingTable(300, []string{"router:7687"}, []string{"follower:7687"}, []string{"leader:7687"})),
+			),
+			"follower:7687": bolttest.NewServer(t,
+				bolttest.ExpectHello("Neo4j/4.4.0"),
+				bolttest.ExpectRun("CREATE ()", bolttest.Success(nil)),
+				bolttest.Expect("PULL", bolttest.Failure("Neo.ClientError.Cluster.NotALeader", "not a leader")),
+				bolttest.Expect("RESET", bolttest.Success(nil)),
+			),
+			"leader:7687": bolttest.NewServer(t, steps([]bolttest.Step{bolttest.ExpectHello("Neo4j/4.4.0")}, expectQuery("CREATE ()"))...),
+		}
+		driver, err := neo4j.NewDriver("neo4j://router:7687", username, password, cluster.dial)
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		_, err = driver.Run("CREATE ()", neo4j.WriteAccessMode)
+		Expect(err).To(MatchError(ContainSubstring("NotALeader")))
+
+		_, err = driver.Run("CREATE ()", neo4j.WriteAccessMode)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cluster["router:7687"].Received()).To(Equal([]string{"HELLO", "ROUTE", "ROUTE"}))
+		Expect(cluster["leader:7687"].Received()).To(Equal([]string{"HELLO", "RUN", "PULL"}))
+	})
+
+	t.Run("fetches the routing table once for concurrent sessions", func(t *testing.T) {
+		cluster := cluster{
+			"router:7687": bolttest.NewServer(t,
+				bolttest.ExpectHello("Neo4j/4.4.0"),
+				bolttest.Expect("ROUTE", routingTable(300, []string{"router:7687"}, []string{"reader:7687"}, []string{"router:7687"})),
+			),
+			// connections that cannot be reset after failed queries are
+			// discarded, so that every query runs on a connection of its own
+			"reader:7687": bolttest.NewServer(t,
+				bolttest.ExpectHello("Neo4j/4.4.0"),
+				bolttest.ExpectRun("RETURN 42", bolttest.Failure("Neo.ClientError.Statement.SyntaxError", "invalid syntax")),
+				bolttest.Expect("PULL", bolttest.Ignored()),
+				bolttest.Expect("RESET", bolttest.Failure("Neo.TransientError.General.DatabaseUnavailable", "unavailable")),
+			),
+		}
+		driver, err := neo4j.NewDriver("neo4j://router:7687", username, password, cluster.dial)
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		var running sync.WaitGroup
+
+		for i := 0; i < 10; i++ {
+			running.Add(1)
+			go func() {
+				defer running.Done()
+				_, err := driver.Run("RETURN 42", neo4j.ReadAccessMode)
+				var serverError *neo4j.Neo4jError
+				Expect(errors.As(err, &serverError)).To(BeTrue(), "expected server error, got %v", err)
+			}()
+		}
+		running.Wait()
+
+		Expect(cluster["router:7687"].Received()).To(Equal([]string{"HELLO", "ROUTE"}))
+		Expect(count(cluster["reader:7687"].Received(), "RUN")).To(Equal(10))
+	})
+
+	t.Run("refreshes expired routing tables", func(t *testing.T) {
+		table := routingTable(0, []string{"router:7687"}, []string{"router:7687"}, []string{"router:7687"})
+		cluster := cluster{
+			"router:7687": bolttest.NewServer(t, steps(
+				[]bolttest.Step{bolttest.ExpectHello("Neo4j/4.4.0"), bolttest.Expect("ROUTE", table)},
+				expectQuery("RETURN 42", 42),
+				[]bolttest.Step{bolttest.Expect("ROUTE", table)},
+				expectQuery("RETURN 42", 42),
+			)...),
+		}
+		driver, err := neo4j.NewDriver("neo4j://router:7687", username, password, cluster.dial)
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+
+		for i := 0; i < 2; i++ {
+			_, err = driver.Run("RETURN 42", neo4j.ReadAccessMode)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		Expect(count(cluster["router:7687"].Received(), "ROUTE")).To(Equal(2))
+	})
+
+	t.Run("calls routing procedure on servers without ROUTE", func(t *testing.T) {
+		cluster := cluster{
+			"router:7687": bolttest.NewServerWithVersion(t, 4, 2,
+				bolttest.ExpectHello("Neo4j/4.2.0"),
+				bolttest.ExpectRun("CALL dbms.routing.getRoutingTable($context, $database)", bolttest.Success(map[string]interface{}{"fields": []string{"ttl", "servers"}})),
+				bolttest.Expect("PULL",
+					bolttest.Record(300, routingTableServers([]string{"router:7687"}, []string{"member:7687"}, []string{"member:7687"})),
+					bolttest.Success(nil)),
+			),
+			"member:7687": bolttest.NewServer(t, steps([]bolttest.Step{bolttest.ExpectHello("Neo4j/4.4.0")}, expectQuery("RETURN 42", 42))...),
+		}
+		driver, err := neo4j.NewDriver("neo4j://router:7687", username, password, cluster.dial)
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+
+		result, err := driver.Run("RETURN 42", neo4j.ReadAccessMode)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(&packstream.List{packstream.Integer(42)}))
+		Expect(cluster["router:7687"].Received()).To(Equal([]string{"HELLO", "RUN", "PULL"}))
+		Expect(cluster["member:7687"].Received()).To(Equal([]string{"HELLO", "RUN", "PULL"}))
+	})
+
+	t.Run("resolves seed routers", func(t *testing.T) {
+		cluster := cluster{
+			"router:7687": bolttest.NewServer(t,
+				bolttest.ExpectHello("Neo4j/4.4.0"),
+				bolttest.Expect("ROUTE", routingTable(300, []string{"router:7687"}, []string{"member:7687"}, []string{"member:7687"})),
+			),
+			"member:7687": bolttest.NewServer(t, steps([]bolttest.Step{bolttest.ExpectHello("Neo4j/4.4.0")}, expectQuery("RETURN 42", 42))...),
+		}
+		var resolvedAddresses []string
+		driver, err := neo4j.NewDriver("neo4j://cluster.internal", username, password, cluster.dial, func(config *neo4j.Config) {
+			config.AddressResolver = func(address string) []string {
+				resolvedAddresses = append(resolvedAddresses, address)
+				return []string{"unreachable:7687", "router:7687"}
+			}
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+
+		_, err = driver.Run("RETURN 42", neo4j.ReadAccessMode)
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resolvedAddresses).To(Equal([]string{"cluster.internal:7687", "cluster.internal:7687"}))
+		Expect(cluster["member:7687"].Received()).To(Equal([]string{"HELLO", "RUN", "PULL"}))
+	})
+
+	t.Run("fails when resolving no servers", func(t *testing.T) {
+		_, err := neo4j.NewDriver("neo4j://cluster.internal", username, password, func(config *neo4j.Config) {
+			config.AddressResolver = func(string) []string {
+				return nil
+			}
+		})
+
+		Expect(err).To(MatchError("could not resolve any server from [cluster.internal:7687]"))
+	})
+
+	t.Run("rejects unsupported schemes", func(t *testing.T) {
+		_, err := neo4j.NewDriver("http://localhost", username, password)
+
+		Expect(err).To(MatchError(`unsupported URI scheme "http", expected bolt or neo4j`))
+	})
+}
+
+// cluster maps the addresses of cluster members to the stub servers standing
+// for them, so that routing tables can list members by name
+type cluster map[string]*bolttest.Server
+
+// dial configures the driver to reach the cluster members through the stub
+// servers
+func (c cluster) dial(config *neo4j.Config) {
+	config.Dialer = c
+}
+
+func (c cluster) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	server, found := c[address]
+	if !found {
+		return nil, fmt.Errorf("no route to %s", address)
+	}
+	return (&net.Dialer{}).DialContext(ctx, network, server.Address())
+}
+
+// routingTable answers ROUTE with the members of each role
+func routingTable(ttl int, routers, readers, writers []string) bolttest.Response {
+	return bolttest.Success(map[string]interface{}{"rt": map[string]interface{}{
+		"ttl":     ttl,
+		"servers": routingTableServers(routers, readers, writers),
+	}})
+}
+
+func routingTableServers(routers, readers, writers []string) []interface{} {
+	return []interface{}{
+		map[string]interface{}{"role": "ROUTE", "addresses": routers},
+		map[string]interface{}{"role": "READ", "addresses": readers},
+		map[string]interface{}{"role": "WRITE", "addresses": writers},
+	}
+}
+
+// count returns how many of the received messages have the name
+func count(received []string, name string) int {
+	result := 0
+	for _, message := range received {
+		if message == name {
+			result++
+		}
+	}
+	return result
+}
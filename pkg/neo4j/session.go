@@ -0,0 +1,182 @@
+package neo4j
+
+import (
+	"context"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/internal/bolt"
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	"sync"
+	"time"
+)
+
+type SessionConfig struct {
+	AccessMode AccessMode
+	// DatabaseName defaults to the user home database when empty
+	DatabaseName string
+}
+
+type Session struct {
+	driver *Driver
+	config SessionConfig
+	// ctx carries the session span, the spans of Run are started from it
+	ctx         context.Context
+	span        Span
+	lastResult  *Result
+	transaction *Transaction
+	// closeMutex guards closed, so that concurrent calls to Close only
+	// close the session once
+	closeMutex sync.Mutex
+	closed     bool
+}
+
+// Run sends the query and returns its result, the previous result of the
+// session is consumed beforehand
+func (s *Session) Run(query string) (*Result, error) {
+	return s.RunContext(s.ctx, query, nil)
+}
+
+// RunContext runs the query with the parameters like Run, its span is
+// started from the context instead of the session span
+func (s *Session) RunContext(ctx context.Context, query string, parameters map[string]interface{}) (*Result, error) {
+	if err := s.checkUsable(); err != nil {
+		return nil, err
+	}
+	parameterValues, err := newParameters(parameters)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.consumeLastResult(); err != nil {
+		return nil, err
+	}
+	ctx, span := s.driver.config.Tracer.StartSpan(ctx, RunOperation, SpanAttributes{
+		Query:    query,
+		Database: s.config.DatabaseName,
+	})
+	connector, err := s.acquire(ctx)
+	if err != nil {
+		span.End(err)
+		return nil, err
+	}
+	span.SetServerAddress(connector.Address())
+	measure := &queryMeasure{
+		metrics:  s.driver.config.Metrics,
+		span:     span,
+		address:  connector.Address(),
+		database: s.config.DatabaseName,
+		started:  time.Now(),
+	}
+	provider := s.driver.provider
+	err = connector.SendRun(query, parameterValues, s.config.AccessMode.String(), s.config.DatabaseName)
+	if err != nil {
+		measure.completed(err)
+		provider.release(connector, err)
+		return nil, err
+	}
+	result, err := newResult(connector, provider.release, query, measure)
+	if err != nil {
+		return nil, err
+	}
+	s.lastResult = result
+	return result, nil
+}
+
+// BeginTransaction starts an explicit transaction, which holds a connection
+// until it is committed or rolled back. No other query can be run on the
+// session in the meantime.
+func (s *Session) BeginTransaction(ctx context.Context) (*Transaction, error) {
+	if err := s.checkUsable(); err != nil {
+		return nil, err
+	}
+	if err := s.consumeLastResult(); err != nil {
+		return nil, err
+	}
+	ctx, span := s.driver.config.Tracer.StartSpan(ctx, BeginOperation, SpanAttributes{Database: s.config.DatabaseName})
+	connector, err := s.acquire(ctx)
+	if err != nil {
+		span.End(err)
+		return nil, err
+	}
+	span.SetServerAddress(connector.Address())
+	err = connector.SendBegin(s.config.AccessMode.String(), s.config.DatabaseName)
+	if err == nil {
+		_, err = connector.ReceiveSuccess()
+	}
+	if err != nil {
+		s.driver.provider.release(connector, err)
+		span.End(err)
+		return nil, err
+	}
+	span.End(nil)
+	s.transaction = &Transaction{session: s, connector: connector}
+	return s.transaction, nil
+}
+
+// Close rolls back the open transaction and consumes the last result, if
+// any, before closing the session
+func (s *Session) Close() error {
+	s.closeMutex.Lock()
+	closed := s.closed
+	s.closed = true
+	s.closeMutex.Unlock()
+	if closed {
+		return nil
+	}
+	var err error
+	if s.transaction != nil {
+		err = s.transaction.Rollback(s.ctx)
+	}
+	if resultErr := s.consumeLastResult(); err == nil {
+		err = resultErr
+	}
+	s.span.End(err)
+	s.driver.sessionClosed()
+	return err
+}
+
+func (s *Session) checkUsable() error {
+	s.closeMutex.Lock()
+	closed := s.closed
+	s.closeMutex.Unlock()
+	if closed {
+		return ErrSessionClosed
+	}
+	if s.transaction != nil {
+		return ErrTransactionOpen
+	}
+	return nil
+}
+
+// acquire traces the acquisition of a connection to a server able to serve
+// the session
+func (s *Session) acquire(ctx context.Context) (*bolt.Connector, error) {
+	ctx, span := s.driver.config.Tracer.StartSpan(ctx, AcquireOperation, SpanAttributes{Database: s.config.DatabaseName})
+	connector, err := s.driver.provider.acquire(ctx, s.config.AccessMode, s.config.DatabaseName)
+	if err == nil {
+		span.SetServerAddress(connector.Address())
+	}
+	span.End(err)
+	return connector, err
+}
+
+func (s *Session) consumeLastResult() error {
+	if s.lastResult == nil {
+		return nil
+	}
+	_, err := s.lastResult.Consume()
+	s.lastResult = nil
+	return err
+}
+
+// newParameters converts the query parameters to packstream values, such as
+// strings, numbers, time.Time, slices, maps and values read from records
+func newParameters(parameters map[string]interface{}) (*packstream.Dictionary, error) {
+	result := make(packstream.Dictionary, len(parameters))
+	for name, parameter := range parameters {
+		value, err := packstream.ValueOf(parameter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameter %s: %w", name, err)
+		}
+		result[name] = []packstream.Value{value}
+	}
+	return &result, nil
+}
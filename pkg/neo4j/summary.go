@@ -0,0 +1,268 @@
+package neo4j
+
+import (
+	"github.com/fbiville/go-usain-go/pkg/internal/packstream"
+	"time"
+)
+
+type QueryType int
+
+const (
+	QueryTypeUnknown QueryType = iota
+	QueryTypeReadOnly
+	QueryTypeReadWrite
+	QueryTypeWriteOnly
+	QueryTypeSchemaWrite
+)
+
+func (q QueryType) String() string {
+	return [...]string{"unknown", "r", "rw", "w", "s"}[q]
+}
+
+func parseQueryType(rawType string) QueryType {
+	switch rawType {
+	case "r":
+		return QueryTypeReadOnly
+	case "rw":
+		return QueryTypeReadWrite
+	case "w":
+		return QueryTypeWriteOnly
+	case "s":
+		return QueryTypeSchemaWrite
+	default:
+		return QueryTypeUnknown
+	}
+}
+
+type ResultSummary struct {
+	Query         string
+	QueryType     QueryType
+	Counters      Counters
+	Notifications []Notification
+	// Plan is set when the query is prefixed with EXPLAIN or PROFILE
+	Plan *Plan
+	// Profile is set when the query is prefixed with PROFILE
+	Profile              *ProfiledPlan
+	ResultAvailableAfter time.Duration
+	ResultConsumedAfter  time.Duration
+	Database             string
+	Bookmark             string
+	Server               ServerInfo
+}
+
+func (s *ResultSummary) complete(metadata *packstream.OrderedDictionary) {
+	s.ResultConsumedAfter = metadataMilliseconds(metadata, "t_last")
+	s.QueryType = parseQueryType(metadataString(metadata, "type"))
+	s.Database = metadataString(metadata, "db")
+	s.Bookmark = metadataString(metadata, "bookmark")
+	if stats, casted := metadata.Get("stats").(*packstream.OrderedDictionary); casted {
+		s.Counters = parseCounters(stats)
+	}
+	if notifications, casted := metadata.Get("notifications").(*packstream.List); casted {
+		for _, rawNotification := range *notifications {
+			if notification, casted := rawNotification.(*packstream.OrderedDictionary); casted {
+				s.Notifications = append(s.Notifications, parseNotification(notification))
+			}
+		}
+	}
+	if plan, casted := metadata.Get("plan").(*packstream.OrderedDictionary); casted {
+		s.Plan = parsePlan(plan)
+	}
+	if profile, casted := metadata.Get("profile").(*packstream.OrderedDictionary); casted {
+		s.Plan = parsePlan(profile)
+		s.Profile = parseProfiledPlan(profile)
+	}
+}
+
+type Counters struct {
+	NodesCreated          int64
+	NodesDeleted          int64
+	RelationshipsCreated  int64
+	RelationshipsDeleted  int64
+	PropertiesSet         int64
+	LabelsAdded           int64
+	LabelsRemoved         int64
+	IndexesAdded          int64
+	IndexesRemoved        int64
+	ConstraintsAdded      int64
+	ConstraintsRemoved    int64
+	SystemUpdates         int64
+	containsUpdates       bool
+	containsSystemUpdates bool
+}
+
+// ContainsUpdates returns true if the query updated the graph
+func (c Counters) ContainsUpdates() bool {
+	return c.containsUpdates
+}
+
+// ContainsSystemUpdates returns true if the query updated the system graph
+func (c Counters) ContainsSystemUpdates() bool {
+	return c.containsSystemUpdates
+}
+
+func parseCounters(stats *packstream.OrderedDictionary) Counters {
+	result := Counters{
+		NodesCreated:         metadataInteger(stats, "nodes-created"),
+		NodesDeleted:         metadataInteger(stats, "nodes-deleted"),
+		RelationshipsCreated: metadataInteger(stats, "relationships-created"),
+		RelationshipsDeleted: metadataInteger(stats, "relationships-deleted"),
+		PropertiesSet:        metadataInteger(stats, "properties-set"),
+		LabelsAdded:          metadataInteger(stats, "labels-added"),
+		LabelsRemoved:        metadataInteger(stats, "labels-removed"),
+		IndexesAdded:         metadataInteger(stats, "indexes-added"),
+		IndexesRemoved:       metadataInteger(stats, "indexes-removed"),
+		ConstraintsAdded:     metadataInteger(stats, "constraints-added"),
+		ConstraintsRemoved:   metadataInteger(stats, "constraints-removed"),
+		SystemUpdates:        metadataInteger(stats, "system-updates"),
+	}
+	// older servers do not send the contains-* flags
+	if containsUpdates, casted := stats.Get("contains-updates").(packstream.Boolean); casted {
+		result.containsUpdates = bool(containsUpdates)
+	} else {
+		result.containsUpdates = result.NodesCreated+result.NodesDeleted+
+			result.RelationshipsCreated+result.RelationshipsDeleted+
+			result.PropertiesSet+result.LabelsAdded+result.LabelsRemoved+
+			result.IndexesAdded+result.IndexesRemoved+
+			result.ConstraintsAdded+result.ConstraintsRemoved > 0
+	}
+	if containsSystemUpdates, casted := stats.Get("contains-system-updates").(packstream.Boolean); casted {
+		result.containsSystemUpdates = bool(containsSystemUpdates)
+	} else {
+		result.containsSystemUpdates = result.SystemUpdates > 0
+	}
+	return result
+}
+
+type Notification struct {
+	Code        string
+	Title       string
+	Description string
+	Severity    string
+	Category    string
+	// Position is nil when the notification does not relate to a specific
+	// part of the query
+	Position *InputPosition
+}
+
+type InputPosition struct {
+	Offset int64
+	Line   int64
+	Column int64
+}
+
+func parseNotification(notification *packstream.OrderedDictionary) Notification {
+	result := Notification{
+		Code:        metadataString(notification, "code"),
+		Title:       metadataString(notification, "title"),
+		Description: metadataString(notification, "description"),
+		Severity:    metadataString(notification, "severity"),
+		Category:    metadataString(notification, "category"),
+	}
+	if position, casted := notification.Get("position").(*packstream.OrderedDictionary); casted {
+		result.Position = &InputPosition{
+			Offset: metadataInteger(position, "offset"),
+			Line:   metadataInteger(position, "line"),
+			Column: metadataInteger(position, "column"),
+		}
+	}
+	return result
+}
+
+type Plan struct {
+	Operator    string
+	Arguments   map[string]packstream.Value
+	Identifiers []string
+	Children    []*Plan
+}
+
+func parsePlan(plan *packstream.OrderedDictionary) *Plan {
+	result := &Plan{
+		Operator:    metadataString(plan, "operatorType"),
+		Arguments:   map[string]packstream.Value{},
+		Identifiers: metadataStrings(plan, "identifiers"),
+	}
+	if arguments, casted := plan.Get("args").(*packstream.OrderedDictionary); casted {
+		result.Arguments = arguments.AsMap()
+	}
+	if children, casted := plan.Get("children").(*packstream.List); casted {
+		for _, rawChild := range *children {
+			if child, casted := rawChild.(*packstream.OrderedDictionary); casted {
+				result.Children = append(result.Children, parsePlan(child))
+			}
+		}
+	}
+	return result
+}
+
+type ProfiledPlan struct {
+	Operator          string
+	Arguments         map[string]packstream.Value
+	Identifiers       []string
+	DbHits            int64
+	Records           int64
+	PageCacheHits     int64
+	PageCacheMisses   int64
+	PageCacheHitRatio float64
+	// Time is only reported by some Cypher runtimes
+	Time     time.Duration
+	Children []*ProfiledPlan
+}
+
+func parseProfiledPlan(profile *packstream.OrderedDictionary) *ProfiledPlan {
+	plan := parsePlan(profile)
+	result := &ProfiledPlan{
+		Operator:        plan.Operator,
+		Arguments:       plan.Arguments,
+		Identifiers:     plan.Identifiers,
+		DbHits:          metadataInteger(profile, "dbHits"),
+		Records:         metadataInteger(profile, "rows"),
+		PageCacheHits:   metadataInteger(profile, "pageCacheHits"),
+		PageCacheMisses: metadataInteger(profile, "pageCacheMisses"),
+		// time is reported in nanoseconds
+		Time: time.Duration(metadataInteger(profile, "time")),
+	}
+	if ratio, casted := profile.Get("pageCacheHitRatio").(packstream.Float); casted {
+		result.PageCacheHitRatio = float64(ratio)
+	}
+	if children, casted := profile.Get("children").(*packstream.List); casted {
+		for _, rawChild := range *children {
+			if child, casted := rawChild.(*packstream.OrderedDictionary); casted {
+				result.Children = append(result.Children, parseProfiledPlan(child))
+			}
+		}
+	}
+	return result
+}
+
+func metadataString(metadata *packstream.OrderedDictionary, key string) string {
+	if value, casted := metadata.Get(key).(*packstream.String); casted {
+		return string(*value)
+	}
+	return ""
+}
+
+func metadataInteger(metadata *packstream.OrderedDictionary, key string) int64 {
+	if value, casted := metadata.Get(key).(packstream.Integer); casted {
+		return int64(value)
+	}
+	return 0
+}
+
+func metadataMilliseconds(metadata *packstream.OrderedDictionary, key string) time.Duration {
+	return time.Duration(metadataInteger(metadata, key)) * time.Millisecond
+}
+
+func metadataStrings(metadata *packstream.OrderedDictionary, key string) []string {
+	values, casted := metadata.Get(key).(*packstream.List)
+	if !casted {
+		return nil
+	}
+	result := make([]string, 0, len(*values))
+	for _, value := range *values {
+		if str, casted := value.(*packstream.String); casted {
+			result = append(result, string(*str))
+		}
+	}
+	return result
+}
@@ -0,0 +1,59 @@
+package neo4j
+
+import "context"
+
+// Tracer starts the spans of the driver operations, to be exported to a
+// distributed tracing stack. The returned context carries the span, so that
+// the spans of nested operations are started from it. Methods may be called
+// concurrently.
+type Tracer interface {
+	StartSpan(ctx context.Context, operation string, attributes SpanAttributes) (context.Context, Span)
+}
+
+// Span is ended once the operation it traces completes
+type Span interface {
+	// SetServerAddress is called once the server the operation is sent to is
+	// known, when it was not at the start of the span
+	SetServerAddress(address string)
+	// End is called once, err is nil when the operation succeeded
+	End(err error)
+}
+
+// SpanAttributes describes a traced operation, fields are empty when they
+// do not apply or are not known yet
+type SpanAttributes struct {
+	Query    string
+	Database string
+	// ServerAddress is the host:port of the server
+	ServerAddress string
+}
+
+// Operations traced by the driver
+const (
+	// SessionOperation spans from the opening of a session to its closing
+	SessionOperation = "neo4j.session"
+	// AcquireOperation spans the acquisition of a connection from the pool,
+	// including its creation when no idle connection is available
+	AcquireOperation = "neo4j.connection.acquire"
+	// BeginOperation spans the start of an explicit transaction
+	BeginOperation = "neo4j.transaction.begin"
+	// CommitOperation spans the commit of an explicit transaction
+	CommitOperation = "neo4j.transaction.commit"
+	// RollbackOperation spans the rollback of an explicit transaction
+	RollbackOperation = "neo4j.transaction.rollback"
+	// RunOperation spans a query until all its records are received
+	RunOperation = "neo4j.run"
+)
+
+// NoopTracer starts spans that are discarded, it is the default
+type NoopTracer struct{}
+
+func (NoopTracer) StartSpan(ctx context.Context, _ string, _ SpanAttributes) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetServerAddress(string) {}
+
+func (noopSpan) End(error) {}
@@ -0,0 +1,203 @@
+package neo4j_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/fbiville/go-usain-go/pkg/bolttest"
+	"github.com/fbiville/go-usain-go/pkg/neo4j"
+	. "github.com/onsi/gomega"
+	"sync"
+	"testing"
+)
+
+func TestTracing(t *testing.T) {
+	RegisterTestingT(t)
+
+	t.Run("traces queries under the caller span", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.ExpectRun("RETURN 42", bolttest.Success(map[string]interface{}{"fields": []string{"42"}})),
+			bolttest.Expect("PULL", bolttest.Record(42), bolttest.Success(nil)),
+		)
+		tracer := &recordingTracer{}
+		driver, err := neo4j.NewDriver(server.URI(), username, password, func(config *neo4j.Config) {
+			config.Tracer = tracer
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		ctx := context.WithValue(context.Background(), spanKey{}, "handler")
+
+		session, err := driver.NewSessionContext(ctx, neo4j.SessionConfig{DatabaseName: "movies"})
+		Expect(err).NotTo(HaveOccurred())
+		result, err := session.Run("RETURN 42")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = result.Consume()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(session.Close()).To(Succeed())
+
+		address := server.Address()
+		Expect(tracer.spans()).To(Equal([]string{
+			"start neo4j.session under handler db=movies",
+			"start neo4j.run under neo4j.session query=RETURN 42 db=movies",
+			"start neo4j.connection.acquire under neo4j.run db=movies",
+			"end neo4j.connection.acquire at " + address + " err=<nil>",
+			"end neo4j.run at " + address + " err=<nil>",
+			"end neo4j.session err=<nil>",
+		}))
+	})
+
+	t.Run("traces transactions", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.Expect("BEGIN", bolttest.Success(nil)),
+			bolttest.ExpectRun("CREATE ()", bolttest.Success(map[string]interface{}{"fields": []string{}})),
+			bolttest.Expect("PULL", bolttest.Success(nil)),
+			bolttest.Expect("COMMIT", bolttest.Success(map[string]interface{}{"bookmark": "bm:1"})),
+		)
+		tracer := &recordingTracer{}
+		driver, err := neo4j.NewDriver(server.URI(), username, password, func(config *neo4j.Config) {
+			config.Tracer = tracer
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		session, err := driver.NewSession(neo4j.SessionConfig{AccessMode: neo4j.WriteAccessMode})
+		Expect(err).NotTo(HaveOccurred())
+		ctx := context.WithValue(context.Background(), spanKey{}, "handler")
+
+		transaction, err := session.BeginTransaction(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = session.Run("RETURN 1")
+		Expect(err).To(Equal(neo4j.ErrTransactionOpen))
+		_, err = transaction.Run(ctx, "CREATE ()", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(transaction.Commit(ctx)).To(Succeed())
+		Expect(transaction.Commit(ctx)).To(Equal(neo4j.ErrTransactionClosed))
+		Expect(session.Close()).To(Succeed())
+
+		address := server.Address()
+		Expect(tracer.spans()).To(Equal([]string{
+			"start neo4j.session under none",
+			"start neo4j.transaction.begin under handler",
+			"start neo4j.connection.acquire under neo4j.transaction.begin",
+			"end neo4j.connection.acquire at " + address + " err=<nil>",
+			"end neo4j.transaction.begin at " + address + " err=<nil>",
+			"start neo4j.run under handler query=CREATE () at " + address,
+			"start neo4j.transaction.commit under handler at " + address,
+			"end neo4j.run at " + address + " err=<nil>",
+			"end neo4j.transaction.commit at " + address + " err=<nil>",
+			"end neo4j.session err=<nil>",
+		}))
+	})
+
+	t.Run("closes transactions after failures", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.Expect("BEGIN", bolttest.Success(nil)),
+			bolttest.ExpectRun("RETURN x", bolttest.Failure("Neo.ClientError.Statement.SyntaxError", "invalid query")),
+			bolttest.Expect("PULL", bolttest.Ignored()),
+			bolttest.Expect("RESET", bolttest.Success(nil)),
+		)
+		tracer := &recordingTracer{}
+		driver, err := neo4j.NewDriver(server.URI(), username, password, func(config *neo4j.Config) {
+			config.Tracer = tracer
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		session, err := driver.NewSession(neo4j.SessionConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		transaction, err := session.BeginTransaction(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = transaction.Run(context.Background(), "RETURN x", nil)
+
+		Expect(err).To(HaveOccurred())
+		Expect(tracer.spans()).To(ContainElement("end neo4j.run at " + server.Address() + " err=" + err.Error()))
+		Expect(transaction.Rollback(context.Background())).To(Equal(neo4j.ErrTransactionClosed))
+		Expect(session.Close()).To(Succeed())
+	})
+
+	t.Run("rolls back open transactions when closing sessions", func(t *testing.T) {
+		server := bolttest.NewServer(t,
+			bolttest.ExpectHello("Neo4j/4.4.0"),
+			bolttest.Expect("BEGIN", bolttest.Success(nil)),
+			bolttest.Expect("ROLLBACK", bolttest.Success(nil)),
+		)
+		tracer := &recordingTracer{}
+		driver, err := neo4j.NewDriver(server.URI(), username, password, func(config *neo4j.Config) {
+			config.Tracer = tracer
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer driver.Close()
+		session, err := driver.NewSession(neo4j.SessionConfig{})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = session.BeginTransaction(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(session.Close()).To(Succeed())
+
+		Expect(tracer.spans()).To(ContainElement("start neo4j.transaction.rollback under neo4j.session at " + server.Address()))
+	})
+}
+
+type spanKey struct{}
+
+// recordingTracer records the start and end of spans as strings, naming the
+// parent span after the operation of the span the context carries
+type recordingTracer struct {
+	mutex    sync.Mutex
+	recorded []string
+}
+
+func (r *recordingTracer) StartSpan(ctx context.Context, operation string, attributes neo4j.SpanAttributes) (context.Context, neo4j.Span) {
+	parent, found := ctx.Value(spanKey{}).(string)
+	if !found {
+		parent = "none"
+	}
+	event := fmt.Sprintf("start %s under %s", operation, parent)
+	if attributes.Query != "" {
+		event += " query=" + attributes.Query
+	}
+	if attributes.Database != "" {
+		event += " db=" + attributes.Database
+	}
+	if attributes.ServerAddress != "" {
+		event += " at " + attributes.ServerAddress
+	}
+	r.record(event)
+	span := &recordingSpan{tracer: r, operation: operation, address: attributes.ServerAddress}
+	return context.WithValue(ctx, spanKey{}, operation), span
+}
+
+func (r *recordingTracer) record(event string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.recorded = append(r.recorded, event)
+}
+
+func (r *recordingTracer) spans() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.recorded...)
+}
+
+type recordingSpan struct {
+	tracer    *recordingTracer
+	operation string
+	address   string
+}
+
+func (r *recordingSpan) SetServerAddress(address string) {
+	r.address = address
+}
+
+func (r *recordingSpan) End(err error) {
+	event := "end " + r.operation
+	if r.address != "" {
+		event += " at " + r.address
+	}
+	errorMessage := "<nil>"
+	if err != nil {
+		errorMessage = err.Error()
+	}
+	r.tracer.record(event + " err=" + errorMessage)
+}
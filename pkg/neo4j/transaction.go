@@ -0,0 +1,122 @@
+package neo4j
+
+import (
+	"context"
+	"github.com/fbiville/go-usain-go/pkg/internal/bolt"
+	"time"
+)
+
+// Transaction is an explicit transaction, started with
+// Session.BeginTransaction. It is closed once committed or rolled back, or
+// as soon as one of its queries fails, in which case the server rolls it
+// back.
+type Transaction struct {
+	session *Session
+	// connector is nil once the transaction is closed
+	connector  *bolt.Connector
+	lastResult *Result
+}
+
+// Run sends the query with the parameters and returns its result, the
+// previous result of the transaction is consumed beforehand
+func (t *Transaction) Run(ctx context.Context, query string, parameters map[string]interface{}) (*Result, error) {
+	if t.connector == nil {
+		return nil, ErrTransactionClosed
+	}
+	parameterValues, err := newParameters(parameters)
+	if err != nil {
+		// queries failing before they are sent close the transaction as well
+		_ = t.Rollback(ctx)
+		return nil, err
+	}
+	if err := t.consumeLastResult(); err != nil {
+		return nil, err
+	}
+	config := t.session.driver.config
+	address := t.connector.Address()
+	_, span := config.Tracer.StartSpan(ctx, RunOperation, SpanAttributes{
+		Query:         query,
+		Database:      t.session.config.DatabaseName,
+		ServerAddress: address,
+	})
+	measure := &queryMeasure{
+		metrics:  config.Metrics,
+		span:     span,
+		address:  address,
+		database: t.session.config.DatabaseName,
+		started:  time.Now(),
+	}
+	if err := t.connector.SendRunInTransaction(query, parameterValues); err != nil {
+		measure.completed(err)
+		t.close(err)
+		return nil, err
+	}
+	result, err := newResult(t.connector, t.resultCompleted, query, measure)
+	if err != nil {
+		return nil, err
+	}
+	t.lastResult = result
+	return result, nil
+}
+
+// Commit consumes the last result, if any, and commits the transaction
+func (t *Transaction) Commit(ctx context.Context) error {
+	return t.end(ctx, CommitOperation, func(connector *bolt.Connector) error {
+		return connector.SendCommit()
+	})
+}
+
+// Rollback consumes the last result, if any, and rolls the transaction back
+func (t *Transaction) Rollback(ctx context.Context) error {
+	return t.end(ctx, RollbackOperation, func(connector *bolt.Connector) error {
+		return connector.SendRollback()
+	})
+}
+
+func (t *Transaction) end(ctx context.Context, operation string, send func(*bolt.Connector) error) error {
+	if t.connector == nil {
+		return ErrTransactionClosed
+	}
+	_, span := t.session.driver.config.Tracer.StartSpan(ctx, operation, SpanAttributes{
+		Database:      t.session.config.DatabaseName,
+		ServerAddress: t.connector.Address(),
+	})
+	err := t.consumeLastResult()
+	if err != nil {
+		// the failed result closed the transaction already
+		span.End(err)
+		return err
+	}
+	err = send(t.connector)
+	if err == nil {
+		_, err = t.connector.ReceiveSuccess()
+	}
+	t.close(err)
+	span.End(err)
+	return err
+}
+
+// resultCompleted keeps the connection of the transaction once a result is
+// consumed, unless it failed
+func (t *Transaction) resultCompleted(_ *bolt.Connector, err error) {
+	if err != nil {
+		t.close(err)
+	}
+}
+
+// close releases the connection and frees the session for other queries
+func (t *Transaction) close(err error) {
+	t.session.driver.provider.release(t.connector, err)
+	t.connector = nil
+	t.lastResult = nil
+	t.session.transaction = nil
+}
+
+func (t *Transaction) consumeLastResult() error {
+	if t.lastResult == nil {
+		return nil
+	}
+	_, err := t.lastResult.Consume()
+	t.lastResult = nil
+	return err
+}
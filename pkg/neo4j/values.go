@@ -0,0 +1,49 @@
+package neo4j
+
+import "github.com/fbiville/go-usain-go/pkg/internal/packstream"
+
+// Value is a value sent by the server, such as a record value, of one of the
+// types below
+type Value = packstream.Value
+
+type (
+	Null    = packstream.Nil
+	Boolean = packstream.Boolean
+	Integer = packstream.Integer
+	Float   = packstream.Float
+	String  = packstream.String
+	List    = packstream.List
+	// Map keeps the entries in the order the server sent them
+	Map                 = packstream.OrderedDictionary
+	Node                = packstream.Node
+	Relationship        = packstream.Relationship
+	UnboundRelationship = packstream.UnboundRelationship
+	// DateTime holds the DATETIME values of the protocol version, with
+	// either an offset or a time zone
+	DateTime = packstream.DateTime
+	// Structure holds the values that are not hydrated to a dedicated type,
+	// such as paths, dates, times, durations and points, named after their
+	// tag with Name
+	Structure = packstream.Structure
+)
+
+// Structures holds user-defined structures, hydrated into custom values of
+// Go types implementing Value, per protocol version
+type Structures = packstream.Structures
+
+type (
+	// HydrateFunc turns a received structure into a custom value
+	HydrateFunc = packstream.HydrateFunc
+	// DehydrateFunc turns a custom value back into its structure, and
+	// reports whether it knows about the value
+	DehydrateFunc = packstream.DehydrateFunc
+	// ProtocolVersion identifies the Bolt protocol version structures are
+	// registered for
+	ProtocolVersion = packstream.ProtocolVersion
+)
+
+// NewStructures creates an empty set of user-defined structures, to be set
+// as Config.Structures once registered
+func NewStructures() *Structures {
+	return packstream.NewStructures()
+}